@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kidandcat/testit/pkg/dsl/ast"
+	"github.com/kidandcat/testit/pkg/parser"
+)
+
+// runFmt implements "testit fmt", which rewrites .test files in the
+// canonical layout, like gofmt does for Go
+func runFmt(args []string) int {
+	flags := flag.NewFlagSet("fmt", flag.ExitOnError)
+	write := flags.Bool("w", false, "Write the result back to the file instead of printing it")
+	list := flags.Bool("l", false, "List files whose formatting differs")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: testit fmt [-l] [-w] [path ...]\n\nWithout paths, formats standard input. Directories are searched for .test and .testit files.\n\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		if *write {
+			fmt.Fprintln(os.Stderr, "testit fmt: cannot use -w with standard input")
+			return 2
+		}
+		src, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		return formatFile("<stdin>", src, false, *list)
+	}
+
+	files, err := sourceFiles(flags.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+
+	status := 0
+	for _, file := range files {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			status = 1
+			continue
+		}
+		if s := formatFile(file, src, *write, *list); s != 0 {
+			status = s
+		}
+	}
+	return status
+}
+
+func formatFile(name string, src []byte, write, list bool) int {
+	out, err := ast.Source(name, src)
+	if err != nil {
+		printErrors(os.Stderr, err)
+		return 1
+	}
+
+	changed := !bytes.Equal(src, out)
+	if list && changed {
+		fmt.Println(name)
+	}
+	if write && changed {
+		info, err := os.Stat(name)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+		if err := os.WriteFile(name, out, info.Mode().Perm()); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 1
+		}
+	}
+	if !write && !list {
+		os.Stdout.Write(out)
+	}
+	return 0
+}
+
+// sourceFiles expands directories in paths into the .test and .testit files
+// below them
+func sourceFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		err = filepath.WalkDir(path, func(file string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !d.IsDir() && (strings.HasSuffix(file, ".test") || strings.HasSuffix(file, ".testit")) {
+				files = append(files, file)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// printErrors prints each error of a parser.ErrorList on its own line
+func printErrors(w io.Writer, err error) {
+	var list parser.ErrorList
+	if !errors.As(err, &list) {
+		fmt.Fprintln(w, err)
+		return
+	}
+	for _, e := range list {
+		fmt.Fprintln(w, e)
+	}
+}
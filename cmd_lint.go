@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/kidandcat/testit/pkg/lint"
+)
+
+// runLint implements "testit lint", which checks .test files for mistakes
+// without running them
+func runLint(args []string) int {
+	flags := flag.NewFlagSet("lint", flag.ExitOnError)
+	jsonOutput := flags.Bool("json", false, "Print diagnostics as a JSON array")
+	disable := flags.String("disable", "", "Comma-separated rules to skip")
+	listRules := flags.Bool("rules", false, "List the available rules and exit")
+	maxWarnings := flags.Int("max-warnings", -1, "Exit with status 1 if there are more warnings than this, -1 for no limit")
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: testit lint [-json] [-disable rule,...] [-max-warnings n] [path ...]\n\nWithout paths, lints the current directory. Directories are searched for .test and .testit files.\n\n")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if *listRules {
+		for _, rule := range lint.Rules() {
+			fmt.Printf("%-26s %-8s %s\n", rule.Name, rule.Severity, rule.Doc)
+		}
+		return 0
+	}
+
+	skip := make(map[string]bool)
+	for _, name := range strings.Split(*disable, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			skip[name] = true
+		}
+	}
+	var rules []*lint.Rule
+	for _, rule := range lint.Rules() {
+		if !skip[rule.Name] {
+			rules = append(rules, rule)
+		}
+	}
+
+	paths := flags.Args()
+	if len(paths) == 0 {
+		paths = []string{"."}
+	}
+	files, err := sourceFiles(paths)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	diags, err := lint.Files(files, rules)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	if *jsonOutput {
+		if diags == nil {
+			diags = []lint.Diagnostic{}
+		}
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(diags)
+	} else {
+		for _, d := range diags {
+			fmt.Println(d)
+		}
+	}
+
+	// Warnings alone pass, unless there are more than -max-warnings
+	errs, warnings := 0, 0
+	for _, d := range diags {
+		if d.Severity == lint.Error {
+			errs++
+		} else {
+			warnings++
+		}
+	}
+	if errs > 0 || (*maxWarnings >= 0 && warnings > *maxWarnings) {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/kidandcat/testit/pkg/lsp"
+)
+
+// runLSP implements "testit lsp", a language server for editors, spoken over
+// standard input and output
+func runLSP(args []string) int {
+	flags := flag.NewFlagSet("lsp", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage: testit lsp\n\nRuns a Language Server Protocol server on standard input and output. Editors start it themselves.\n")
+	}
+	flags.Parse(args)
+
+	if err := lsp.NewServer(os.Stdin, os.Stdout).Run(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"errors"
 	"flag"
 	"fmt"
 	"log"
@@ -27,14 +28,35 @@ const (
 )
 
 func main() {
+	// Subcommands; anything else runs tests
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "fmt":
+			os.Exit(runFmt(os.Args[2:]))
+		case "lint":
+			os.Exit(runLint(os.Args[2:]))
+		case "lsp":
+			os.Exit(runLSP(os.Args[2:]))
+		}
+	}
+
 	var (
 		headless           = flag.Bool("headless", true, "Run browser in headless mode")
 		timeout            = flag.Duration("timeout", 30*time.Second, "Test timeout")
+		stepTimeout        = flag.Duration("step-timeout", 0, "Default timeout for a single step (0 uses only the test timeout)")
 		failOnConsoleError = flag.Bool("fail-on-console-error", true, "Fail tests when console errors occur")
+		failOn             = flag.String("fail-on", "", "Page error types that fail a test, e.g. error,exception,http (default error,exception,rejection)")
 		pattern            = flag.String("pattern", "*.test", "File pattern for test files")
 		configFile         = flag.String("config", "", "Config file path")
 		screenshotDir      = flag.String("screenshot-dir", "", "Screenshot directory")
 		updateScreenshots  = flag.Bool("update-screenshots", false, "Update baseline screenshots")
+		updateSnapshots    = flag.Bool("update-snapshots", false, "Update baseline HTML snapshots")
+		ci                 = flag.Bool("ci", false, "Only save missing screenshot and snapshot baselines, never update them")
+		workers            = flag.Int("workers", 1, "Number of tests to run in parallel")
+		device             = flag.String("device", "", "Device to emulate, e.g. \"iPhone 13\"")
+		run                = flag.String("run", "", "Only run tests whose name matches this regular expression")
+		tags               = flag.String("tags", "", "Only run tests with these tags, e.g. smoke,!slow")
+		list               = flag.Bool("list", false, "List the tests that would run and exit")
 	)
 
 	flag.Parse()
@@ -43,7 +65,9 @@ func main() {
 	runnerConfig := &fasttest.Config{
 		Headless:           *headless,
 		Timeout:            *timeout,
+		StepTimeout:        *stepTimeout,
 		FailOnConsoleError: *failOnConsoleError,
+		Workers:            *workers,
 	}
 
 	// Load config file if available
@@ -64,44 +88,80 @@ func main() {
 			if !isFlagSet("timeout") && fileConfig.Timeout != nil {
 				runnerConfig.Timeout = fileConfig.Timeout.Duration
 			}
+			if !isFlagSet("step-timeout") && fileConfig.StepTimeout != nil {
+				runnerConfig.StepTimeout = fileConfig.StepTimeout.Duration
+			}
+			if len(fileConfig.ActionTimeouts) > 0 {
+				runnerConfig.ActionTimeouts = make(map[string]time.Duration, len(fileConfig.ActionTimeouts))
+				for action, d := range fileConfig.ActionTimeouts {
+					if d != nil {
+						runnerConfig.ActionTimeouts[action] = d.Duration
+					}
+				}
+			}
 			if !isFlagSet("fail-on-console-error") && fileConfig.FailOnConsoleError != nil {
 				runnerConfig.FailOnConsoleError = *fileConfig.FailOnConsoleError
 			}
+			if !isFlagSet("fail-on") {
+				runnerConfig.FailOn = fileConfig.FailOn
+			}
+			runnerConfig.ConsoleErrorIgnore = fileConfig.ConsoleErrorIgnore
 			if fileConfig.ScreenshotDir != "" && *screenshotDir == "" {
 				runnerConfig.ScreenshotDir = fileConfig.ScreenshotDir
 			}
 			if fileConfig.UpdateScreenshots && !*updateScreenshots {
 				runnerConfig.UpdateScreenshots = fileConfig.UpdateScreenshots
 			}
+			if fileConfig.UpdateSnapshots && !*updateSnapshots {
+				runnerConfig.UpdateSnapshots = fileConfig.UpdateSnapshots
+			}
 			runnerConfig.ScreenshotThreshold = fileConfig.ScreenshotThreshold
+			runnerConfig.PixelThreshold = fileConfig.PixelThreshold
+			runnerConfig.ScreenshotMinSSIM = fileConfig.ScreenshotMinSSIM
+			runnerConfig.ViewportWidth = fileConfig.ViewportWidth
+			runnerConfig.ViewportHeight = fileConfig.ViewportHeight
+			if fileConfig.Device != "" && *device == "" {
+				runnerConfig.Device = fileConfig.Device
+			}
+			runnerConfig.Vars = fileConfig.Vars
+			if !isFlagSet("workers") && fileConfig.Workers > 0 {
+				runnerConfig.Workers = fileConfig.Workers
+			}
 		}
 	}
 
 	// CLI flags override everything
+	if *failOn != "" {
+		runnerConfig.FailOn = strings.Split(*failOn, ",")
+	}
+	failOnTypes, err := fasttest.ParseErrorTypes(runnerConfig.FailOn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	runnerConfig.FailOn = failOnTypes
+	for _, pattern := range runnerConfig.ConsoleErrorIgnore {
+		if _, err := fasttest.ParseErrorPattern(pattern); err != nil {
+			log.Fatal(err)
+		}
+	}
 	if *screenshotDir != "" {
 		runnerConfig.ScreenshotDir = *screenshotDir
 	}
 	if *updateScreenshots {
 		runnerConfig.UpdateScreenshots = true
 	}
-
-	runner := fasttest.NewRunner(runnerConfig)
-	if err := runner.Start(); err != nil {
-		log.Fatal("Failed to start browser:", err)
+	if *updateSnapshots {
+		runnerConfig.UpdateSnapshots = true
+	}
+	runnerConfig.NoBaselineUpdates = *ci
+	if *device != "" {
+		runnerConfig.Device = *device
 	}
-	defer runner.Stop()
 
-	// Set up signal handling for graceful shutdown
-	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
-	
-	// Handle cleanup on signal
-	go func() {
-		<-sigChan
-		fmt.Println("\nReceived interrupt signal, shutting down gracefully...")
-		runner.Stop()
-		os.Exit(0)
-	}()
+	filter, err := fasttest.ParseFilter(*run, *tags)
+	if err != nil {
+		log.Fatal(err)
+	}
 
 	testFiles, err := findTestFiles(*pattern, flag.Args())
 	if err != nil {
@@ -113,22 +173,67 @@ func main() {
 	}
 
 	p := parser.New()
+	var suites []fasttest.Suite
 	totalTests := 0
 
+	parseErrors := 0
+
 	for _, file := range testFiles {
-		tests, err := p.ParseFile(file)
+		suite, err := p.ParseSuite(file)
 		if err != nil {
-			log.Printf("Failed to parse %s: %v", file, err)
+			parseErrors += printParseError(file, err)
 			continue
 		}
 
-		for _, test := range tests {
-			runner.AddTest(test)
-			totalTests++
+		// Libraries that only hold procedures and setup for imports have no
+		// tests, and neither do files whose tests are all filtered out
+		filtered := filter.Apply(*suite)
+		if len(filtered.Tests) == 0 {
+			continue
 		}
+		suites = append(suites, filtered)
+		totalTests += len(filtered.Tests)
 	}
 
-	fmt.Printf("%sRunning %d tests from %d files...%s\n\n", colorYellow, totalTests, len(testFiles), colorReset)
+	// Running the tests that did parse would report success for a suite
+	// that is partly broken
+	if parseErrors > 0 {
+		fmt.Printf("%s%d parse errors, no tests were run%s\n", colorRed, parseErrors, colorReset)
+		os.Exit(1)
+	}
+
+	if *list {
+		listTests(suites)
+		return
+	}
+
+	runner := fasttest.NewRunner(runnerConfig)
+	if err := runner.Start(); err != nil {
+		log.Fatal("Failed to start browser:", err)
+	}
+	defer runner.Stop()
+
+	// Set up signal handling for graceful shutdown
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	
+	// Handle cleanup on signal
+	go func() {
+		<-sigChan
+		fmt.Println("\nReceived interrupt signal, shutting down gracefully...")
+		runner.Stop()
+		os.Exit(0)
+	}()
+
+	for _, suite := range suites {
+		runner.AddSuite(suite)
+	}
+
+	if runnerConfig.Workers > 1 {
+		fmt.Printf("%sRunning %d tests from %d files on %d workers...%s\n\n", colorYellow, totalTests, len(suites), runnerConfig.Workers, colorReset)
+	} else {
+		fmt.Printf("%sRunning %d tests from %d files...%s\n\n", colorYellow, totalTests, len(suites), colorReset)
+	}
 
 	s := spinner.New(spinner.CharSets[9], 100*time.Millisecond)
 	s.Start()
@@ -139,13 +244,26 @@ func main() {
 	go func() {
 		for result := range resultsChan {
 			s.Stop()
-			if result.Passed {
-				fmt.Printf("%s✓ PASS%s %s (%s)\n", colorGreen, colorReset, result.Name, result.Duration.Round(time.Millisecond))
+			if result.Skipped {
+				fmt.Printf("%s- SKIP%s %s\n", colorYellow, colorReset, result.Name)
+			} else if result.Passed {
+				// Passing before_all/after_all hooks are not worth a line
+				if result.Hook == "" {
+					fmt.Printf("%s✓ PASS%s %s (%s)\n", colorGreen, colorReset, result.Name, result.Duration.Round(time.Millisecond))
+				}
 			} else {
 				fmt.Printf("%s✗ FAIL%s %s (%s)\n", colorRed, colorReset, result.Name, result.Duration.Round(time.Millisecond))
-				if result.Error != nil {
-					fmt.Printf("  %sError: %v%s\n", colorRed, result.Error, colorReset)
+				for _, err := range []error{result.Error, result.AfterEachError} {
+					if err == nil {
+						continue
+					}
+					fmt.Printf("  %sError: %v%s\n", colorRed, err, colorReset)
+					var stepErr *fasttest.StepError
+					if errors.As(err, &stepErr) {
+						printCodeFrame(stepErr.Step)
+					}
 				}
+				printPageErrors(result.Errors)
 			}
 			s.Start()
 			wg.Done()
@@ -156,9 +274,11 @@ func main() {
 	wg.Wait()
 	s.Stop()
 
+	printBaselineSummary(runner.Baselines())
+
 	failed := 0
 	for _, result := range results {
-		if !result.Passed {
+		if result.Failed() {
 			failed++
 		}
 	}
@@ -168,6 +288,133 @@ func main() {
 	}
 }
 
+// printParseError prints every error in a file that failed to parse and
+// returns how many there were
+func printParseError(file string, err error) int {
+	var list parser.ErrorList
+	if !errors.As(err, &list) {
+		fmt.Printf("%s%s: %v%s\n", colorRed, file, err, colorReset)
+		return 1
+	}
+	for _, e := range list {
+		fmt.Printf("%s%v%s\n", colorRed, e, colorReset)
+	}
+	return len(list)
+}
+
+// listTests prints the tests that would run, one per line, with their tags
+func listTests(suites []fasttest.Suite) {
+	for _, suite := range suites {
+		for _, test := range suite.Tests {
+			line := fmt.Sprintf("%s: %s", suite.Name, test.Name)
+			for _, tag := range test.Tags {
+				line += " @" + tag
+			}
+			if test.Skip {
+				line += " (skip)"
+			} else if test.Only {
+				line += " (only)"
+			}
+			fmt.Println(line)
+		}
+	}
+}
+
+// printBaselineSummary says which screenshot and snapshot baselines were
+// written, if any were checked
+func printBaselineSummary(summary fasttest.BaselineSummary) {
+	if summary.Empty() {
+		return
+	}
+	fmt.Printf("\nBaselines: %d updated, %d new, %d unchanged", len(summary.Updated), len(summary.New), len(summary.Unchanged))
+	if len(summary.Mismatched) > 0 {
+		fmt.Printf(", %s%d mismatched%s", colorRed, len(summary.Mismatched), colorReset)
+	}
+	fmt.Println()
+	for _, path := range summary.Updated {
+		fmt.Printf("  %supdated%s %s\n", colorYellow, colorReset, path)
+	}
+	for _, path := range summary.New {
+		fmt.Printf("  %snew%s     %s\n", colorGreen, colorReset, path)
+	}
+}
+
+// maxPageErrors is how many page errors are shown for a failed test
+const maxPageErrors = 5
+
+// printPageErrors lists the errors the page reported during a failed test,
+// with their stack traces
+func printPageErrors(pageErrors []fasttest.ConsoleError) {
+	for i, e := range pageErrors {
+		if i == maxPageErrors {
+			fmt.Printf("  %s... and %d more page errors%s\n", colorYellow, len(pageErrors)-i, colorReset)
+			break
+		}
+		fmt.Printf("  %s%s%s\n", colorYellow, e, colorReset)
+		for _, line := range strings.Split(e.Stack, "\n") {
+			if strings.TrimSpace(line) != "" {
+				fmt.Printf("    %s\n", strings.TrimSpace(line))
+			}
+		}
+	}
+}
+
+// codeFrameContext is how many lines are shown around a failing step
+const codeFrameContext = 2
+
+// printCodeFrame shows the failing step's line of the .test file with the
+// lines around it, then the call for steps of a procedure
+func printCodeFrame(step fasttest.Step) {
+	printFrame(step.File, step.Line, step.Text)
+	if step.CallLine > 0 {
+		fmt.Printf("  %sCalled from:%s\n", colorBlue, colorReset)
+		printFrame(step.CallFile, step.CallLine, "")
+	}
+}
+
+// printFrame shows a line of a file with the lines around it. text is
+// shown instead when the file cannot be read.
+func printFrame(file string, line int, text string) {
+	if line == 0 {
+		return
+	}
+
+	var lines []string
+	if file != "" {
+		if content, err := os.ReadFile(file); err == nil {
+			lines = strings.Split(string(content), "\n")
+		}
+	}
+	if line > len(lines) {
+		// The file is gone or has changed; the step's own text is all we have
+		if text != "" {
+			fmt.Printf("  %s> %d | %s%s\n", colorBlue, line, text, colorReset)
+		}
+		return
+	}
+
+	first := line - codeFrameContext
+	if first < 1 {
+		first = 1
+	}
+	last := line + codeFrameContext
+	if last > len(lines) {
+		last = len(lines)
+	}
+	width := len(fmt.Sprint(last))
+
+	fmt.Println()
+	for n := first; n <= last; n++ {
+		src := strings.TrimRight(lines[n-1], "\r")
+		if n == line {
+			fmt.Printf("  %s> %*d | %s%s\n", colorRed, width, n, src, colorReset)
+		} else {
+			fmt.Printf("    %*d | %s\n", width, n, src)
+		}
+	}
+	fmt.Println()
+}
+
 func findTestFiles(pattern string, args []string) ([]string, error) {
 	var files []string
 
@@ -15,13 +15,22 @@ type FileConfig struct {
 	Headless            *bool                `yaml:"headless" json:"headless"`
 	Timeout             *Duration            `yaml:"timeout" json:"timeout"`
 	FailOnConsoleError  *bool                `yaml:"failOnConsoleError" json:"failOnConsoleError"`
+	FailOn              []string             `yaml:"failOn" json:"failOn"`
+	ConsoleErrorIgnore  []string             `yaml:"consoleErrorIgnore" json:"consoleErrorIgnore"`
 	ScreenshotDir       string               `yaml:"screenshotDir" json:"screenshotDir"`
 	UpdateScreenshots   bool                 `yaml:"updateScreenshots" json:"updateScreenshots"`
+	UpdateSnapshots     bool                 `yaml:"updateSnapshots" json:"updateSnapshots"`
 	ScreenshotThreshold float64              `yaml:"screenshotThreshold" json:"screenshotThreshold"`
+	PixelThreshold      float64              `yaml:"pixelThreshold" json:"pixelThreshold"`
+	ScreenshotMinSSIM   float64              `yaml:"screenshotMinSSIM" json:"screenshotMinSSIM"`
 	ViewportWidth       int                  `yaml:"viewportWidth" json:"viewportWidth"`
 	ViewportHeight      int                  `yaml:"viewportHeight" json:"viewportHeight"`
+	Device              string               `yaml:"device" json:"device"`
 	BrowserType         string               `yaml:"browserType" json:"browserType"`
+	StepTimeout         *Duration            `yaml:"stepTimeout" json:"stepTimeout"`
 	ActionTimeouts      map[string]*Duration `yaml:"actionTimeouts" json:"actionTimeouts"`
+	Workers             int                  `yaml:"workers" json:"workers"`
+	Vars                map[string]string    `yaml:"vars" json:"vars"`
 }
 
 // Duration is a custom type for unmarshaling duration strings
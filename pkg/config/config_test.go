@@ -69,8 +69,17 @@ func TestLoadConfig(t *testing.T) {
 timeout: 45s
 screenshotDir: custom_dir
 screenshotThreshold: 0.05
+pixelThreshold: 0.2
+screenshotMinSSIM: 0.98
 viewportWidth: 1920
-viewportHeight: 1080`,
+viewportHeight: 1080
+workers: 4
+consoleErrorIgnore:
+  - ResizeObserver loop
+  - widget\.intercom\.io
+vars:
+  HOST: http://localhost:8080
+  USER: admin`,
 			check: func(t *testing.T, cfg *FileConfig) {
 				if cfg.Headless == nil || *cfg.Headless != false {
 					t.Error("Expected headless to be false")
@@ -84,9 +93,21 @@ viewportHeight: 1080`,
 				if cfg.ScreenshotThreshold != 0.05 {
 					t.Error("Expected threshold to be 0.05")
 				}
+				if cfg.PixelThreshold != 0.2 || cfg.ScreenshotMinSSIM != 0.98 {
+					t.Errorf("Unexpected pixelThreshold %v or screenshotMinSSIM %v", cfg.PixelThreshold, cfg.ScreenshotMinSSIM)
+				}
 				if cfg.ViewportWidth != 1920 {
 					t.Error("Expected viewport width to be 1920")
 				}
+				if cfg.Workers != 4 {
+					t.Error("Expected workers to be 4")
+				}
+				if len(cfg.ConsoleErrorIgnore) != 2 || cfg.ConsoleErrorIgnore[1] != `widget\.intercom\.io` {
+					t.Errorf("Unexpected consoleErrorIgnore: %v", cfg.ConsoleErrorIgnore)
+				}
+				if cfg.Vars["HOST"] != "http://localhost:8080" || cfg.Vars["USER"] != "admin" {
+					t.Errorf("Unexpected vars: %v", cfg.Vars)
+				}
 			},
 		},
 		{
@@ -96,8 +117,10 @@ viewportHeight: 1080`,
   "headless": true,
   "timeout": "30s",
   "failOnConsoleError": true,
+  "failOn": ["exception", "http"],
   "screenshotDir": "screenshots",
-  "updateScreenshots": true
+  "updateScreenshots": true,
+  "device": "iPhone 13"
 }`,
 			check: func(t *testing.T, cfg *FileConfig) {
 				if cfg.Headless == nil || *cfg.Headless != true {
@@ -106,20 +129,30 @@ viewportHeight: 1080`,
 				if cfg.FailOnConsoleError == nil || *cfg.FailOnConsoleError != true {
 					t.Error("Expected failOnConsoleError to be true")
 				}
+				if len(cfg.FailOn) != 2 || cfg.FailOn[0] != "exception" || cfg.FailOn[1] != "http" {
+					t.Errorf("Unexpected failOn: %v", cfg.FailOn)
+				}
 				if cfg.UpdateScreenshots != true {
 					t.Error("Expected updateScreenshots to be true")
 				}
+				if cfg.Device != "iPhone 13" {
+					t.Error("Expected device to be iPhone 13")
+				}
 			},
 		},
 		{
 			name:     "config with action timeouts",
 			filename: "test.yaml",
 			content: `timeout: 30s
+stepTimeout: 15s
 actionTimeouts:
   navigate: 20s
   click: 10s
   type: 5s`,
 			check: func(t *testing.T, cfg *FileConfig) {
+				if cfg.StepTimeout == nil || cfg.StepTimeout.Duration != 15*time.Second {
+					t.Error("Expected step timeout to be 15s")
+				}
 				if cfg.ActionTimeouts == nil {
 					t.Fatal("Expected actionTimeouts to be set")
 				}
@@ -0,0 +1,142 @@
+// Package ast declares the syntax tree of .test files.
+//
+// The parser package turns a file straight into runnable steps. The tree
+// keeps what that loses, like comments, layout and source positions, so tools
+// such as the formatter can inspect and rewrite files.
+package ast
+
+import "fmt"
+
+// Pos is a position in a file, 1-based
+type Pos struct {
+	Line   int
+	Column int
+}
+
+func (p Pos) String() string {
+	return fmt.Sprintf("%d:%d", p.Line, p.Column)
+}
+
+// Node is any node of the tree
+type Node interface {
+	Pos() Pos
+	// EndLine is the last line the node covers
+	EndLine() int
+}
+
+// File is a parsed .test file
+type File struct {
+	Name  string
+	Stmts []Node // Comments, imports, top-level steps, defines, tests and hooks
+}
+
+// Comment is a line starting with #
+type Comment struct {
+	Start Pos
+	Text  string // Including the #
+}
+
+func (c *Comment) Pos() Pos     { return c.Start }
+func (c *Comment) EndLine() int { return c.Start.Line }
+
+// Arg is an argument of a step or header
+type Arg struct {
+	Start  Pos
+	Value  string
+	Quoted bool
+}
+
+// Step is a line inside a block, or a top-level set. call is a step too, with
+// the procedure name as its first argument.
+type Step struct {
+	Start  Pos
+	Action string
+	// Args are the step's arguments. For known actions whose last argument
+	// takes the rest of the line, unquoted words are already joined into one.
+	Args []*Arg
+	Last int // Last line, after the first when the step has a triple-quoted string
+}
+
+func (s *Step) Pos() Pos     { return s.Start }
+func (s *Step) EndLine() int { return s.Last }
+
+// Import is an import or include line
+type Import struct {
+	Start   Pos
+	Keyword string // "import" or "include"
+	Path    *Arg
+}
+
+func (i *Import) Pos() Pos     { return i.Start }
+func (i *Import) EndLine() int { return i.Start.Line }
+
+// Test is a test block
+type Test struct {
+	Start    Pos
+	Name     string
+	NamePos  Pos
+	Skip     bool
+	Only     bool
+	Tags     []string
+	Body     []Node // Steps and comments
+	Examples *Examples
+}
+
+func (t *Test) Pos() Pos { return t.Start }
+func (t *Test) EndLine() int {
+	if t.Examples != nil {
+		return t.Examples.EndLine()
+	}
+	return blockEnd(t.Start.Line, t.Body)
+}
+
+// Hook is a before_all, before_each, after_each or after_all block
+type Hook struct {
+	Start Pos
+	Kind  string
+	Body  []Node
+}
+
+func (h *Hook) Pos() Pos     { return h.Start }
+func (h *Hook) EndLine() int { return blockEnd(h.Start.Line, h.Body) }
+
+// Define is a procedure declared with define ... end
+type Define struct {
+	Start   Pos
+	Name    string
+	NamePos Pos
+	Params  []string
+	Body    []Node
+	End     Pos // The end line
+}
+
+func (d *Define) Pos() Pos     { return d.Start }
+func (d *Define) EndLine() int { return d.End.Line }
+
+// Examples is the data table of a test, written inline or loaded from a file
+type Examples struct {
+	Start  Pos
+	Source *Arg // CSV or JSON file, nil for an inline table
+	Rows   []*Row
+}
+
+func (e *Examples) Pos() Pos { return e.Start }
+func (e *Examples) EndLine() int {
+	if len(e.Rows) > 0 {
+		return e.Rows[len(e.Rows)-1].Start.Line
+	}
+	return e.Start.Line
+}
+
+// Row is a | table | row | of examples. The first row holds column names.
+type Row struct {
+	Start Pos
+	Cells []string
+}
+
+func blockEnd(header int, body []Node) int {
+	if len(body) > 0 {
+		return body[len(body)-1].EndLine()
+	}
+	return header
+}
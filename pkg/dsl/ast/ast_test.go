@@ -0,0 +1,258 @@
+package ast
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/kidandcat/testit/pkg/parser"
+)
+
+const messy = `# Shared setup
+import   common/auth.testit
+set BASE_URL   http://localhost:3000
+
+
+define login(user,pass)
+    navigate ${BASE_URL}/login
+    type #username ${user}
+  type #password   ${pass}
+end
+# Logs in as an admin
+only test Login   @smoke  @auth
+      call login admin 'secret'
+
+  # Then check the page
+  assert_text   h1 Welcome  back
+  type '#my field' "it's"
+  type #bio """
+      First line
+        indented
+      """
+  click div > span.item
+before_each
+    navigate ${BASE_URL}
+test "Search ${term}"
+  type #q ${term}
+
+  examples:
+  | term | count |
+  |shoes|3|
+  | long hats | 10 |
+`
+
+const formatted = `# Shared setup
+import "common/auth.testit"
+set BASE_URL http://localhost:3000
+
+define login(user, pass)
+  navigate ${BASE_URL}/login
+  type #username ${user}
+  type #password ${pass}
+end
+
+# Logs in as an admin
+only test "Login" @smoke @auth
+  call login admin secret
+
+  # Then check the page
+  assert_text h1 "Welcome  back"
+  type "#my field" it's
+  type #bio """
+    First line
+      indented
+    """
+  click div > span.item
+
+before_each
+  navigate ${BASE_URL}
+
+test "Search ${term}"
+  type #q ${term}
+
+  examples:
+    | term      | count |
+    | shoes     | 3     |
+    | long hats | 10    |
+`
+
+func TestParse(t *testing.T) {
+	file, err := Parse("messy.test", []byte(messy))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(file.Stmts) != 8 {
+		t.Fatalf("Expected 8 statements, got %d", len(file.Stmts))
+	}
+
+	imp, ok := file.Stmts[1].(*Import)
+	if !ok || imp.Keyword != "import" || imp.Path.Value != "common/auth.testit" {
+		t.Errorf("Unexpected import %+v", file.Stmts[1])
+	}
+
+	def, ok := file.Stmts[3].(*Define)
+	if !ok || def.Name != "login" || len(def.Params) != 2 || len(def.Body) != 3 || def.End.Line != 10 {
+		t.Fatalf("Unexpected define %+v", file.Stmts[3])
+	}
+	if def.NamePos != (Pos{Line: 6, Column: 8}) {
+		t.Errorf("Unexpected define name position %s", def.NamePos)
+	}
+
+	if _, ok := file.Stmts[4].(*Comment); !ok {
+		t.Errorf("Expected the comment before the test at the top level, got %T", file.Stmts[4])
+	}
+	test, ok := file.Stmts[5].(*Test)
+	if !ok || test.Name != "Login" || !test.Only || len(test.Tags) != 2 || test.NamePos != (Pos{Line: 12, Column: 11}) {
+		t.Fatalf("Unexpected test %+v", file.Stmts[5])
+	}
+	if len(test.Body) != 6 {
+		t.Fatalf("Expected 6 nodes in the test body, got %d", len(test.Body))
+	}
+	if c, ok := test.Body[1].(*Comment); !ok || c.Text != "# Then check the page" {
+		t.Errorf("Expected comment in the test body, got %+v", test.Body[1])
+	}
+	// The words of a rest-of-line argument are one argument
+	step := test.Body[2].(*Step)
+	if step.Action != "assert_text" || len(step.Args) != 2 || step.Args[1].Value != "Welcome  back" {
+		t.Errorf("Unexpected step %+v", step)
+	}
+	bio := test.Body[4].(*Step)
+	if bio.Start.Line != 18 || bio.EndLine() != 21 || bio.Args[1].Value != "First line\n  indented" {
+		t.Errorf("Unexpected multi-line step %+v", bio)
+	}
+
+	search := file.Stmts[7].(*Test)
+	if search.Examples == nil || len(search.Examples.Rows) != 3 || search.Examples.Rows[2].Cells[0] != "long hats" {
+		t.Errorf("Unexpected examples %+v", search.Examples)
+	}
+}
+
+func TestFormat(t *testing.T) {
+	got, err := Source("messy.test", []byte(messy))
+	if err != nil {
+		t.Fatalf("Source() error = %v", err)
+	}
+	if string(got) != formatted {
+		t.Errorf("Source() =\n%s\nwant\n%s", got, formatted)
+	}
+
+	again, err := Source("formatted.test", got)
+	if err != nil {
+		t.Fatalf("Source() error = %v", err)
+	}
+	if string(again) != string(got) {
+		t.Errorf("Formatting is not idempotent:\n%s", again)
+	}
+}
+
+// Formatting must not change what the tests do
+func TestFormatKeepsSteps(t *testing.T) {
+	input := `test "Quoting"
+  type #a "say \"hi\""
+  type #b 'both " and \''
+  assert_text h1 "  padded  "
+  type #c "tab\there"
+  assert_url /path\d+\
+  type #d """
+  not indented
+      indented
+  """
+  set empty ""
+  type #e "ends with newline\n"`
+
+	got, err := Source("", []byte(input))
+	if err != nil {
+		t.Fatalf("Source() error = %v", err)
+	}
+
+	before, err := parser.New().ParseString(input)
+	if err != nil {
+		t.Fatalf("ParseString(input) error = %v", err)
+	}
+	after, err := parser.New().ParseString(string(got))
+	if err != nil {
+		t.Fatalf("ParseString(formatted) error = %v\n%s", err, got)
+	}
+	for i := range before[0].Steps {
+		b, a := before[0].Steps[i], after[0].Steps[i]
+		if a.Action != b.Action || a.Target != b.Target || a.Value != b.Value {
+			t.Errorf("Step %d changed from %+v to %+v", i, b, a)
+		}
+	}
+}
+
+// Quoted words the parser would not join must not become valid once formatted
+func TestFormatKeepsQuotedWords(t *testing.T) {
+	input := "test \"Quoted words\"\n  type #x 'it''s'\n  click \"a\" \"b\"\n  click a 'b c'\n"
+	want := "test \"Quoted words\"\n  type #x \"it\" \"s\"\n  click \"a\" \"b\"\n  click a \"b c\"\n"
+
+	got, err := Source("", []byte(input))
+	if err != nil {
+		t.Fatalf("Source() error = %v", err)
+	}
+	if string(got) != want {
+		t.Errorf("Source() =\n%s\nwant\n%s", got, want)
+	}
+
+	_, err = parser.New().ParseString(string(got))
+	var list parser.ErrorList
+	if !errors.As(err, &list) || len(list) != 3 {
+		t.Errorf("Expected the 3 steps to stay invalid, got %v", err)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	input := `define broken(
+  click #a
+test "Unclosed
+  click #b
+end
+| a |
+define ok(a)
+  click #c`
+
+	_, err := Parse("bad.test", []byte(input))
+	var list parser.ErrorList
+	if !errors.As(err, &list) {
+		t.Fatalf("Expected an ErrorList, got %v", err)
+	}
+
+	var msgs []string
+	for _, e := range list {
+		msgs = append(msgs, e.Error())
+	}
+	want := []string{
+		"bad.test:3:6: unterminated string",
+		"bad.test:1:1: invalid define, expected define name(param, ...)",
+		"bad.test:5:1: end without define",
+		"bad.test:6:1: table row without examples:",
+		"bad.test:7:1: missing end for define ok",
+	}
+	if strings.Join(msgs, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Got errors:\n%s\nwant:\n%s", strings.Join(msgs, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+// Headers are checked by the same code as in the parser, so both report the
+// same errors
+func TestParseHeaderErrors(t *testing.T) {
+	for _, input := range []string{
+		"test \"Bad tag\" @no!pe\n  click #a",
+		"define login(user, 2nd)\n  click #a\nend",
+		"define\n  click #a\nend",
+		"test\n  click #a",
+	} {
+		_, err := Parse("", []byte(input))
+		_, want := parser.New().ParseString(input)
+		if err == nil || want == nil {
+			t.Errorf("Parse(%q) error = %v, parser error = %v, want both to fail", input, err, want)
+			continue
+		}
+		var got, expected parser.ErrorList
+		errors.As(err, &got)
+		errors.As(want, &expected)
+		if got[0].Msg != expected[0].Msg || got[0].Column != expected[0].Column {
+			t.Errorf("Parse(%q) error = %d %q, parser says %d %q", input, got[0].Column, got[0].Msg, expected[0].Column, expected[0].Msg)
+		}
+	}
+}
@@ -0,0 +1,276 @@
+package ast
+
+import (
+	"bytes"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/kidandcat/testit/pkg/parser"
+)
+
+// indent is one level of indentation inside a block
+const indent = "  "
+
+// Source formats the source of a .test file. It fails, like gofmt, if the
+// file does not parse.
+func Source(filename string, src []byte) ([]byte, error) {
+	file, err := Parse(filename, src)
+	if err != nil {
+		return nil, err
+	}
+	return Format(file), nil
+}
+
+// Format prints a file in the canonical layout: headers at the start of the
+// line, block contents indented by two spaces, at most one blank line in a
+// row and one after every block, single spaces between arguments, and quotes
+// only where they are needed. Comments are kept where they were.
+func Format(file *File) []byte {
+	pr := &printer{}
+	var prev Node
+	for _, stmt := range file.Stmts {
+		if prev != nil && (blankBetween(prev, stmt) || isBlock(prev)) {
+			pr.buf.WriteByte('\n')
+		}
+		pr.stmt(stmt, "")
+		prev = stmt
+	}
+	return pr.buf.Bytes()
+}
+
+type printer struct {
+	buf bytes.Buffer
+}
+
+func (pr *printer) line(prefix string, parts ...string) {
+	pr.buf.WriteString(prefix)
+	pr.buf.WriteString(strings.Join(parts, " "))
+	pr.buf.WriteByte('\n')
+}
+
+func (pr *printer) stmt(node Node, prefix string) {
+	switch n := node.(type) {
+	case *Comment:
+		pr.line(prefix, n.Text)
+
+	case *Import:
+		pr.line(prefix, n.Keyword, quote(n.Path.Value))
+
+	case *Step:
+		pr.step(n, prefix)
+
+	case *Hook:
+		pr.line(prefix, n.Kind)
+		pr.body(n.Start.Line, n.Body, prefix+indent)
+
+	case *Define:
+		header := "define " + n.Name
+		if len(n.Params) > 0 {
+			header += "(" + strings.Join(n.Params, ", ") + ")"
+		}
+		pr.line(prefix, header)
+		pr.body(n.Start.Line, n.Body, prefix+indent)
+		pr.line(prefix, "end")
+
+	case *Test:
+		var parts []string
+		if n.Skip {
+			parts = append(parts, "skip")
+		} else if n.Only {
+			parts = append(parts, "only")
+		}
+		parts = append(parts, "test", quote(n.Name))
+		for _, tag := range n.Tags {
+			parts = append(parts, "@"+tag)
+		}
+		pr.line(prefix, parts...)
+		pr.body(n.Start.Line, n.Body, prefix+indent)
+		if n.Examples != nil {
+			if blankBetween(lastNode(n.Start.Line, n.Body), n.Examples) {
+				pr.buf.WriteByte('\n')
+			}
+			pr.examples(n.Examples, prefix+indent)
+		}
+	}
+}
+
+func (pr *printer) body(header int, body []Node, prefix string) {
+	prevLine := header
+	for _, node := range body {
+		if node.Pos().Line > prevLine+1 && prevLine != header {
+			pr.buf.WriteByte('\n')
+		}
+		pr.stmt(node, prefix)
+		prevLine = node.EndLine()
+	}
+}
+
+func (pr *printer) step(step *Step, prefix string) {
+	parts := []string{step.Action}
+	spec, known := parser.LookupAction(step.Action)
+	// A rest-of-line action with more arguments than it takes has words
+	// parseStep could not join, because some are quoted. Each keeps its
+	// quotes: written bare they would join into one valid argument, like
+	// click "a" "b" becoming click a b.
+	apart := known && spec.Rest && len(step.Args) > len(spec.Args)
+	for i, arg := range step.Args {
+		if apart && arg.Quoted {
+			parts = append(parts, quoteArg(arg.Value, prefix+indent))
+			continue
+		}
+		rest := known && spec.Rest && i == len(spec.Args)-1 && i == len(step.Args)-1
+		parts = append(parts, formatArg(arg.Value, rest, prefix+indent))
+	}
+	pr.line(prefix, parts...)
+}
+
+func (pr *printer) examples(ex *Examples, prefix string) {
+	if ex.Source != nil {
+		pr.line(prefix, "examples:", quote(ex.Source.Value))
+	} else {
+		pr.line(prefix, "examples:")
+	}
+
+	var widths []int
+	for _, row := range ex.Rows {
+		for i, cell := range row.Cells {
+			if i >= len(widths) {
+				widths = append(widths, 0)
+			}
+			if n := utf8.RuneCountInString(cell); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+	for _, row := range ex.Rows {
+		var b strings.Builder
+		b.WriteString("|")
+		for i, cell := range row.Cells {
+			b.WriteString(" ")
+			b.WriteString(cell)
+			b.WriteString(strings.Repeat(" ", widths[i]-utf8.RuneCountInString(cell)))
+			b.WriteString(" |")
+		}
+		pr.line(prefix+indent, b.String())
+	}
+}
+
+// formatArg writes an argument bare when the lexer would read it back
+// unchanged, and quoted otherwise. A rest-of-line argument may keep single
+// spaces between its words, since the parser joins them again.
+func formatArg(value string, rest bool, prefix string) string {
+	if !needsQuotes(value, rest) {
+		return value
+	}
+	return quoteArg(value, prefix)
+}
+
+// quoteArg quotes an argument, with triple quotes if it has several lines
+func quoteArg(value string, prefix string) string {
+	if canTripleQuote(value) {
+		lines := strings.Split(value, "\n")
+		var b strings.Builder
+		b.WriteString(`"""` + "\n")
+		for _, line := range lines {
+			if line != "" {
+				b.WriteString(prefix + line)
+			}
+			b.WriteString("\n")
+		}
+		b.WriteString(prefix + `"""`)
+		return b.String()
+	}
+	return quote(value)
+}
+
+func needsQuotes(value string, rest bool) bool {
+	if value == "" || strings.ContainsAny(value, "\t\r\n") {
+		return true
+	}
+	words := []string{value}
+	if rest {
+		words = strings.Split(value, " ")
+	} else if strings.Contains(value, " ") {
+		return true
+	}
+	for _, word := range words {
+		if word == "" || word[0] == '"' || word[0] == '\'' {
+			return true
+		}
+	}
+	return false
+}
+
+// canTripleQuote reports whether a multi-line value reads back the same from
+// an indented triple-quoted string, which drops the indentation its lines
+// share and a trailing line break
+func canTripleQuote(value string) bool {
+	if !strings.Contains(value, "\n") || strings.Contains(value, `"""`) ||
+		strings.Contains(value, "\r") || strings.HasSuffix(value, "\n") {
+		return false
+	}
+	for _, line := range strings.Split(value, "\n") {
+		if line != "" && line[0] != ' ' && line[0] != '\t' {
+			return true
+		}
+	}
+	return false
+}
+
+// quote wraps value in double quotes, or single quotes if that avoids
+// escaping. Backslashes are doubled only where the lexer would read them as
+// an escape.
+func quote(value string) string {
+	q := byte('"')
+	if strings.Contains(value, `"`) && !strings.Contains(value, "'") {
+		q = '\''
+	}
+
+	var b strings.Builder
+	b.WriteByte(q)
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		switch c {
+		case q:
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\\':
+			if i+1 == len(value) || strings.IndexByte(`ntr\"'`, value[i+1]) >= 0 {
+				b.WriteString(`\\`)
+			} else {
+				b.WriteByte(c)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte(q)
+	return b.String()
+}
+
+func isBlock(node Node) bool {
+	switch node.(type) {
+	case *Test, *Hook, *Define:
+		return true
+	}
+	return false
+}
+
+// blankBetween reports whether the source had a blank line between two nodes
+func blankBetween(prev, next Node) bool {
+	return next.Pos().Line > prev.EndLine()+1
+}
+
+// lastNode returns the last node of a body, or a stand-in for the header
+func lastNode(header int, body []Node) Node {
+	if len(body) > 0 {
+		return body[len(body)-1]
+	}
+	return &Comment{Start: Pos{Line: header}}
+}
@@ -0,0 +1,273 @@
+package ast
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/kidandcat/testit/pkg/parser"
+)
+
+// Parse builds the tree of a .test file. It only checks the structure of the
+// file, like blocks and quoting; whether steps are valid is left to the
+// parser package. Errors are returned as a parser.ErrorList, together with
+// the part of the tree that could be built.
+func Parse(filename string, src []byte) (*File, error) {
+	lines, errs := parser.Lex(string(src))
+	p := &treeParser{file: &File{Name: filename}, lines: lines, errs: errs}
+	p.parse()
+
+	for _, e := range p.errs {
+		e.File = filename
+	}
+	return p.file, p.errs.Err()
+}
+
+type treeParser struct {
+	file  *File
+	lines []parser.Line
+	errs  parser.ErrorList
+
+	// Statements of the block being read, nil at the top level
+	block  *[]Node
+	test   *Test
+	define *Define
+}
+
+func (p *treeParser) errorf(pos Pos, format string, args ...interface{}) {
+	p.errs = append(p.errs, &parser.Error{Line: pos.Line, Column: pos.Column, Msg: fmt.Sprintf(format, args...)})
+}
+
+// report adds an error from the parser package at pos, or at the token it
+// is about if it names one
+func (p *treeParser) report(pos Pos, err error) {
+	var e *parser.Error
+	if errors.As(err, &e) {
+		if e.Column > 0 {
+			pos = Pos{Line: e.Line, Column: e.Column}
+		}
+		p.errorf(pos, "%s", e.Msg)
+	} else {
+		p.errorf(pos, "%v", err)
+	}
+}
+
+func (p *treeParser) add(node Node) {
+	if p.block != nil {
+		*p.block = append(*p.block, node)
+	} else {
+		p.file.Stmts = append(p.file.Stmts, node)
+	}
+}
+
+// closeBlock ends the test or hook being read. Procedures only end at end.
+func (p *treeParser) closeBlock() {
+	if p.define != nil {
+		// An invalid define has been reported already
+		if p.define.Name != "" {
+			p.errorf(p.define.Start, "missing end for define %s", p.define.Name)
+		}
+		p.define.End = p.define.Start
+		if n := len(p.define.Body); n > 0 {
+			p.define.End = Pos{Line: p.define.Body[n-1].EndLine()}
+		}
+		p.define = nil
+	}
+	p.block = nil
+	p.test = nil
+}
+
+func (p *treeParser) parse() {
+	for i, l := range p.lines {
+		pos := Pos{Line: l.Num, Column: l.Indent()}
+		text := strings.TrimSpace(l.Text)
+
+		if l.Comment {
+			// A comment at the start of the line before the next header is
+			// about what follows, not part of the block above it
+			if p.define == nil && pos.Column == 1 && p.headerFollows(i) {
+				p.closeBlock()
+			}
+			p.add(&Comment{Start: pos, Text: text})
+			continue
+		}
+
+		if l.TableRow {
+			if p.test == nil || p.test.Examples == nil || p.define != nil {
+				p.errorf(pos, "table row without examples:")
+				continue
+			}
+			p.test.Examples.Rows = append(p.test.Examples.Rows, parseRow(pos, text))
+			continue
+		}
+
+		switch {
+		case parser.IsDefine(l):
+			if p.define != nil {
+				p.errorf(pos, "missing end for define %s at line %d", p.define.Name, p.define.Start.Line)
+			}
+			p.closeBlock()
+			p.define = p.parseDefine(l, pos)
+			p.file.Stmts = append(p.file.Stmts, p.define)
+			p.block = &p.define.Body
+
+		case parser.IsEnd(l):
+			if p.define == nil {
+				p.errorf(pos, "end without define")
+				continue
+			}
+			p.define.End = pos
+			p.define = nil
+			p.block = nil
+
+		case parser.StartsBlock(l) || parser.IsImport(l):
+			p.closeBlock()
+			p.parseHeader(l, pos)
+
+		case parser.IsExamples(l) && p.define == nil:
+			if p.test == nil {
+				p.errorf(pos, "examples must be inside a test")
+				continue
+			}
+			if p.test.Examples != nil {
+				p.errorf(pos, "test already has examples at line %d", p.test.Examples.Start.Line)
+				continue
+			}
+			ex := &Examples{Start: pos}
+			if len(l.Tokens) > 1 {
+				ex.Source = arg(l.Tokens[1])
+			}
+			p.test.Examples = ex
+
+		default:
+			p.add(parseStep(l, pos))
+		}
+	}
+
+	if p.define != nil {
+		p.closeBlock()
+	}
+}
+
+// headerFollows reports whether the next line that is not a comment starts
+// a new block, or there is none
+func (p *treeParser) headerFollows(i int) bool {
+	for _, l := range p.lines[i+1:] {
+		if l.Comment {
+			continue
+		}
+		if l.TableRow {
+			return false
+		}
+		return parser.StartsBlock(l) || parser.IsImport(l)
+	}
+	return true
+}
+
+func (p *treeParser) parseHeader(l parser.Line, pos Pos) {
+	keyword := l.Tokens[0].Value
+	switch {
+	case parser.IsImport(l):
+		imp := &Import{Start: pos, Keyword: keyword}
+		if len(l.Tokens) != 2 {
+			p.errorf(pos, "%s requires a file path", keyword)
+			return
+		}
+		imp.Path = arg(l.Tokens[1])
+		p.file.Stmts = append(p.file.Stmts, imp)
+
+	case parser.IsHook(l):
+		hook := &Hook{Start: pos, Kind: keyword}
+		p.file.Stmts = append(p.file.Stmts, hook)
+		p.block = &hook.Body
+
+	default:
+		test := p.parseTest(l, pos)
+		p.file.Stmts = append(p.file.Stmts, test)
+		p.test = test
+		p.block = &test.Body
+	}
+}
+
+func (p *treeParser) parseTest(l parser.Line, pos Pos) *Test {
+	h, err := parser.ParseTestHeader(l)
+	if err != nil {
+		p.report(pos, err)
+	}
+	test := &Test{Start: pos, Name: h.Name, Skip: h.Skip, Only: h.Only, Tags: h.Tags}
+	if h.Name != "" {
+		test.NamePos = Pos{Line: h.NameToken.Line, Column: h.NameToken.Column}
+	}
+	return test
+}
+
+func (p *treeParser) parseDefine(l parser.Line, pos Pos) *Define {
+	def := &Define{Start: pos}
+	h, err := parser.ParseDefine(l)
+	if err != nil {
+		p.report(pos, err)
+		return def
+	}
+	def.Name = h.Name
+	def.NamePos = Pos{Line: pos.Line, Column: h.NameOffset + 1}
+	def.Params = h.Params
+	return def
+}
+
+// parseStep makes a step of a line. The words that make up a rest-of-line
+// argument are joined the way the parser joins them.
+func parseStep(l parser.Line, pos Pos) *Step {
+	step := &Step{
+		Start:  pos,
+		Action: l.Tokens[0].Value,
+		Last:   l.Num + strings.Count(l.Text, "\n"),
+	}
+	tokens := l.Tokens[1:]
+
+	spec, ok := parser.LookupAction(step.Action)
+	if ok && spec.Rest && len(tokens) > len(spec.Args) && len(spec.Args) > 0 {
+		last := len(spec.Args) - 1
+		rest := tokens[last:]
+		quoted := false
+		for _, tok := range rest {
+			quoted = quoted || tok.Quoted
+		}
+		// A quoted string among several words is an error for the parser.
+		// The words are kept apart, with their quoting, so the formatter
+		// writes them back as they were and the error stays.
+		if !quoted {
+			for _, tok := range tokens[:last] {
+				step.Args = append(step.Args, arg(tok))
+			}
+			joined := arg(rest[0])
+			joined.Value = l.Join(rest)
+			step.Args = append(step.Args, joined)
+			return step
+		}
+	}
+
+	for _, tok := range tokens {
+		step.Args = append(step.Args, arg(tok))
+	}
+	return step
+}
+
+func parseRow(pos Pos, text string) *Row {
+	row := &Row{Start: pos}
+	cells := strings.Split(text, "|")
+	if len(cells) >= 2 {
+		cells = cells[1:]
+		// The closing | is optional here; the parser reports it missing
+		if strings.TrimSpace(cells[len(cells)-1]) == "" {
+			cells = cells[:len(cells)-1]
+		}
+	}
+	for _, cell := range cells {
+		row.Cells = append(row.Cells, strings.TrimSpace(cell))
+	}
+	return row
+}
+
+func arg(tok parser.Token) *Arg {
+	return &Arg{Start: Pos{Line: tok.Line, Column: tok.Column}, Value: tok.Value, Quoted: tok.Quoted}
+}
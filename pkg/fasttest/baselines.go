@@ -0,0 +1,114 @@
+package fasttest
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// BaselineSummary lists what screenshot and snapshot steps did with their
+// baselines during a run, by baseline path
+type BaselineSummary struct {
+	New        []string // Saved because there was no baseline
+	Updated    []string // Overwritten in update mode because they differed
+	Unchanged  []string // Matched
+	Mismatched []string // Differed and were left alone, failing the step
+}
+
+// Empty reports whether no baseline was checked
+func (s BaselineSummary) Empty() bool {
+	return len(s.New)+len(s.Updated)+len(s.Unchanged)+len(s.Mismatched) == 0
+}
+
+// Baselines returns what happened to the baselines checked so far
+func (r *Runner) Baselines() BaselineSummary {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	summary := r.baselines
+	summary.New = append([]string(nil), summary.New...)
+	summary.Updated = append([]string(nil), summary.Updated...)
+	summary.Unchanged = append([]string(nil), summary.Unchanged...)
+	summary.Mismatched = append([]string(nil), summary.Mismatched...)
+	return summary
+}
+
+func (r *Runner) recordBaseline(list *[]string, path string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	*list = append(*list, path)
+}
+
+// artifactPaths are where a failed comparison leaves the actual capture and
+// the diff next to the baseline, e.g. home.actual.png and home.diff.png
+func artifactPaths(path string) (actual, diff string) {
+	ext := filepath.Ext(path)
+	base := strings.TrimSuffix(path, ext)
+	return base + ".actual" + ext, base + ".diff" + ext
+}
+
+// checkBaseline compares data with the baseline at path. A missing baseline
+// is saved. A mismatch, which compare describes, overwrites the baseline when
+// update is set and NoBaselineUpdates is not; otherwise the actual data
+// and the diff are saved next to it and the step fails. Whenever the step
+// passes, the .actual and .diff files of earlier failures are removed.
+func (r *Runner) checkBaseline(kind, path string, data []byte, update bool, compare func(baseline []byte) (mismatch string, diff []byte, err error)) error {
+	actualPath, diffPath := artifactPaths(path)
+	removeStale := func() {
+		os.Remove(actualPath)
+		os.Remove(diffPath)
+	}
+
+	baseline, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			return fmt.Errorf("failed to save %s: %v", kind, err)
+		}
+		removeStale()
+		r.recordBaseline(&r.baselines.New, path)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read existing %s: %v", kind, err)
+	}
+
+	var mismatch string
+	var diff []byte
+	if !bytes.Equal(baseline, data) {
+		mismatch, diff, err = compare(baseline)
+		if err != nil {
+			return fmt.Errorf("failed to compare %ss: %v", kind, err)
+		}
+	}
+	if mismatch == "" {
+		removeStale()
+		r.recordBaseline(&r.baselines.Unchanged, path)
+		return nil
+	}
+
+	if update && !r.config.NoBaselineUpdates {
+		if err := os.WriteFile(path, data, 0644); err != nil {
+			return fmt.Errorf("failed to update %s: %v", kind, err)
+		}
+		removeStale()
+		r.recordBaseline(&r.baselines.Updated, path)
+		return nil
+	}
+
+	r.recordBaseline(&r.baselines.Mismatched, path)
+	if err := os.WriteFile(actualPath, data, 0644); err != nil {
+		return fmt.Errorf("%s %s: %s. Failed to save the actual %s: %v", kind, path, mismatch, kind, err)
+	}
+	saved := actualPath
+	if diff != nil {
+		if err := os.WriteFile(diffPath, diff, 0644); err != nil {
+			return fmt.Errorf("%s %s: %s. Saved %s, failed to save the diff: %v", kind, path, mismatch, actualPath, err)
+		}
+		saved += " and " + diffPath
+	} else {
+		os.Remove(diffPath)
+	}
+	return fmt.Errorf("%s %s: %s. Saved %s; run with -update-%ss to accept it", kind, path, mismatch, saved, kind)
+}
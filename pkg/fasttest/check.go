@@ -0,0 +1,130 @@
+package fasttest
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// CheckStep checks the arguments of actions that take a value with a fixed
+// syntax, like a key, a status or a size. An argument with a ${NAME}
+// reference is skipped, as it can only be checked once the variable is
+// known; the parser checks steps as written and the runner again after
+// expanding their variables.
+//
+// Errors are an *ArgError naming the argument at fault.
+func CheckStep(step Step) error {
+	if arg, err := checkStep(step); err != nil {
+		return &ArgError{Arg: arg, Err: err}
+	}
+	return nil
+}
+
+// ArgError is a step argument that CheckStep rejected
+type ArgError struct {
+	Arg string // The argument as it was checked, e.g. one key combination of press
+	Err error
+}
+
+func (e *ArgError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *ArgError) Unwrap() error {
+	return e.Err
+}
+
+// checkStep does the work of CheckStep, returning the argument at fault
+func checkStep(step Step) (string, error) {
+	target, value := step.Target, step.Value
+	if strings.Contains(target, "${") {
+		target = ""
+	}
+	if strings.Contains(value, "${") {
+		value = ""
+	}
+
+	switch step.Action {
+	case "press":
+		// A blank key would press nothing and pass
+		if strings.TrimSpace(step.Target) == "" {
+			return step.Target, fmt.Errorf("press requires a key, e.g. Enter or Space")
+		}
+		// Each combination is checked on its own, so a variable only skips one
+		for _, combo := range strings.Fields(step.Target) {
+			if strings.Contains(combo, "${") {
+				continue
+			}
+			if _, _, err := ParseKeys(combo); err != nil {
+				return combo, err
+			}
+		}
+	case "key_down", "key_up":
+		if target != "" {
+			if _, err := LookupKey(target); err != nil {
+				return target, err
+			}
+		}
+	case "mouse_down", "mouse_up":
+		if target != "" {
+			if _, err := LookupMouseButton(target); err != nil {
+				return target, err
+			}
+		}
+	case "mouse_move":
+		for _, coord := range []string{target, value} {
+			if coord == "" {
+				continue
+			}
+			if n, err := strconv.ParseFloat(coord, 64); err != nil || n < 0 {
+				return coord, fmt.Errorf("invalid coordinate: %s", coord)
+			}
+		}
+	case "viewport":
+		for _, size := range []string{target, value} {
+			if size == "" {
+				continue
+			}
+			if n, err := strconv.Atoi(size); err != nil || n <= 0 {
+				return size, fmt.Errorf("invalid viewport size: %s", size)
+			}
+		}
+	case "device":
+		if target != "" {
+			if _, err := LookupDevice(target); err != nil {
+				return target, err
+			}
+		}
+	case "select":
+		if value != "" {
+			if _, _, err := ParseOption(value); err != nil {
+				return value, err
+			}
+		}
+	case "assert_request_body":
+		if target != "" {
+			if _, err := ParseJSONPath(target); err != nil {
+				return target, err
+			}
+		}
+	case "assert_response_status":
+		if value != "" {
+			if _, _, err := ParseStatusCheck(value); err != nil {
+				return value, err
+			}
+		}
+	case "assert_download_size":
+		if value != "" {
+			if _, _, err := ParseSizeCheck(value); err != nil {
+				return value, err
+			}
+		}
+	case "ignore_console_error", "assert_console_error":
+		if target != "" {
+			if _, err := ParseErrorPattern(target); err != nil {
+				return target, err
+			}
+		}
+	}
+	return "", nil
+}
@@ -0,0 +1,55 @@
+package fasttest
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/chromedp/chromedp"
+	"github.com/chromedp/chromedp/device"
+)
+
+// Default viewport, matching the window headless Chrome opens, so baselines
+// taken before viewports were configurable keep matching
+const (
+	DefaultViewportWidth  = 800
+	DefaultViewportHeight = 600
+)
+
+// devicesByName indexes chromedp's device presets by lower-cased name
+var devicesByName = func() map[string]device.Info {
+	devices := make(map[string]device.Info)
+	for d := device.Reset + 1; d <= device.MotoG4landscape; d++ {
+		info := d.Device()
+		devices[strings.ToLower(info.Name)] = info
+	}
+	return devices
+}()
+
+// LookupDevice finds a device preset such as "iPhone 13" or "Pixel 5 landscape".
+// Names are matched case-insensitively.
+func LookupDevice(name string) (device.Info, error) {
+	info, ok := devicesByName[strings.ToLower(strings.TrimSpace(name))]
+	if !ok {
+		return device.Info{}, fmt.Errorf("unknown device: %s", name)
+	}
+	return info, nil
+}
+
+// emulateViewport sets a plain desktop viewport. The scale factor is pinned
+// to 1 so screenshots have the same size on every machine, including HiDPI
+// displays in headful mode.
+func emulateViewport(width, height int64) chromedp.Action {
+	return chromedp.EmulateViewport(width, height, chromedp.EmulateScale(1))
+}
+
+// emulation returns the action applying the configured device or viewport
+func (r *Runner) emulation() (chromedp.Action, error) {
+	if r.config.Device != "" {
+		info, err := LookupDevice(r.config.Device)
+		if err != nil {
+			return nil, err
+		}
+		return chromedp.Emulate(info), nil
+	}
+	return emulateViewport(int64(r.config.ViewportWidth), int64(r.config.ViewportHeight)), nil
+}
@@ -0,0 +1,246 @@
+package fasttest
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/chromedp/cdproto/browser"
+	"github.com/chromedp/chromedp"
+)
+
+// download is a file the page downloaded during a test
+type download struct {
+	guid  string
+	name  string // The file name the page suggested
+	path  string // Where the browser saved it
+	state browser.DownloadProgressState
+	// expected is set once an expect_download step has matched it, so the
+	// next expect_download waits for another file
+	expected bool
+}
+
+// downloadState tracks the downloads of a test. Browser events update it
+// while steps wait on it, so it is guarded by mu.
+type downloadState struct {
+	dir       string
+	mu        sync.Mutex
+	changed   chan struct{} // Closed and replaced on every update
+	downloads []*download
+	last      *download // Matched by the latest expect_download
+}
+
+// usesDownloads reports whether a test has download steps. Only those tests
+// have their downloads saved.
+func usesDownloads(test Test) bool {
+	for _, steps := range [][]Step{test.BeforeEach, test.Steps, test.AfterEach} {
+		for _, step := range steps {
+			if strings.HasPrefix(step.Action, "expect_download") || strings.HasPrefix(step.Action, "assert_download") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// setupDownloads saves the test's downloads into a new temporary directory,
+// named by download id so files with the same name do not clash, and tracks
+// their progress. cleanup removes the directory.
+func setupDownloads(ctx context.Context) (*downloadState, func(), error) {
+	dir, err := os.MkdirTemp("", "testit-downloads-")
+	if err != nil {
+		return nil, nil, err
+	}
+	cleanup := func() { os.RemoveAll(dir) }
+	ds := &downloadState{dir: dir, changed: make(chan struct{})}
+
+	chromedp.ListenTarget(ctx, func(ev interface{}) {
+		switch ev := ev.(type) {
+		case *browser.EventDownloadWillBegin:
+			ds.update(func() {
+				ds.downloads = append(ds.downloads, &download{
+					guid:  ev.GUID,
+					name:  ev.SuggestedFilename,
+					path:  filepath.Join(dir, ev.GUID),
+					state: browser.DownloadProgressStateInProgress,
+				})
+			})
+		case *browser.EventDownloadProgress:
+			ds.update(func() {
+				for _, d := range ds.downloads {
+					if d.guid == ev.GUID {
+						d.state = ev.State
+					}
+				}
+			})
+		}
+	})
+
+	behavior := browser.SetDownloadBehavior(browser.SetDownloadBehaviorBehaviorAllowAndName).
+		WithDownloadPath(dir).
+		WithEventsEnabled(true)
+	if err := chromedp.Run(ctx, behavior); err != nil {
+		cleanup()
+		return nil, nil, fmt.Errorf("failed to set up downloads: %v", err)
+	}
+	return ds, cleanup, nil
+}
+
+func (ds *downloadState) update(fn func()) {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	fn()
+	close(ds.changed)
+	ds.changed = make(chan struct{})
+}
+
+// expect waits for a download whose file name matches pattern, which may use
+// * and ?, to finish. Downloads that finished before the step count too.
+func (ds *downloadState) expect(ctx context.Context, pattern string) error {
+	for {
+		ds.mu.Lock()
+		var seen []string
+		for _, d := range ds.downloads {
+			if d.expected {
+				continue
+			}
+			seen = append(seen, fmt.Sprintf("%q (%s)", d.name, d.state))
+			if ok, _ := path.Match(pattern, d.name); !ok && d.name != pattern {
+				continue
+			}
+			switch d.state {
+			case browser.DownloadProgressStateCompleted:
+				d.expected = true
+				ds.last = d
+				ds.mu.Unlock()
+				return nil
+			case browser.DownloadProgressStateCanceled:
+				d.expected = true
+				ds.mu.Unlock()
+				return fmt.Errorf("download of %s was canceled", d.name)
+			}
+		}
+		changed := ds.changed
+		ds.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			if len(seen) == 0 {
+				return fmt.Errorf("no download of %s: %w", pattern, ctx.Err())
+			}
+			return fmt.Errorf("no download of %s finished, downloads: %s: %w", pattern, strings.Join(seen, ", "), ctx.Err())
+		}
+	}
+}
+
+// lastFile returns the contents of the download matched by the latest
+// expect_download
+func (ds *downloadState) lastFile() (*download, []byte, error) {
+	ds.mu.Lock()
+	d := ds.last
+	ds.mu.Unlock()
+	if d == nil {
+		return nil, nil, fmt.Errorf("no download to check; use expect_download first")
+	}
+	data, err := os.ReadFile(d.path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot read download %s: %v", d.name, err)
+	}
+	return d, data, nil
+}
+
+// ParseSizeCheck parses a size assertion: a number of bytes, optionally
+// after one of =, <, <=, > or >=, like ">0" or "<= 1048576"
+func ParseSizeCheck(check string) (op string, size int64, err error) {
+	check = strings.TrimSpace(check)
+	for _, prefix := range []string{"<=", ">=", "=", "<", ">"} {
+		if rest, ok := strings.CutPrefix(check, prefix); ok {
+			op, check = prefix, strings.TrimSpace(rest)
+			break
+		}
+	}
+	if op == "" {
+		op = "="
+	}
+	size, err = strconv.ParseInt(check, 10, 64)
+	if err != nil || size < 0 {
+		return "", 0, fmt.Errorf("invalid size: %s", check)
+	}
+	return op, size, nil
+}
+
+func checkSize(op string, got, want int64) bool {
+	switch op {
+	case "<":
+		return got < want
+	case "<=":
+		return got <= want
+	case ">":
+		return got > want
+	case ">=":
+		return got >= want
+	}
+	return got == want
+}
+
+func (r *Runner) executeDownloadStep(ctx context.Context, step Step, state *testState) error {
+	if state.downloads == nil {
+		return fmt.Errorf("downloads are not being recorded")
+	}
+
+	switch step.Action {
+	case "expect_download":
+		return state.downloads.expect(ctx, step.Target)
+
+	case "assert_download_size":
+		op, want, err := ParseSizeCheck(step.Value)
+		if err != nil {
+			return err
+		}
+		d, data, err := state.downloads.lastFile()
+		if err != nil {
+			return err
+		}
+		if !checkSize(op, int64(len(data)), want) {
+			return fmt.Errorf("expected %s to be %s %d bytes, got %d bytes", d.name, op, want, len(data))
+		}
+		return nil
+
+	case "assert_download_contains":
+		d, data, err := state.downloads.lastFile()
+		if err != nil {
+			return err
+		}
+		if !bytes.Contains(data, []byte(step.Value)) {
+			return fmt.Errorf("expected %s to contain '%s'", d.name, step.Value)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown action: %s", step.Action)
+}
+
+// uploadFile sets the file of an <input type=file>. Relative paths are
+// relative to the test file, like imports and examples.
+func uploadFile(ctx context.Context, step Step) error {
+	file := step.Value
+	if !filepath.IsAbs(file) && step.File != "" {
+		file = filepath.Join(filepath.Dir(step.File), file)
+	}
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(abs); err != nil {
+		return fmt.Errorf("cannot upload %s: %v", step.Value, err)
+	}
+	// File inputs are often hidden behind a styled button, so they only
+	// need to be in the page, not visible
+	return chromedp.Run(ctx, chromedp.SetUploadFiles(step.Target, []string{abs}, chromedp.NodeReady))
+}
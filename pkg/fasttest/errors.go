@@ -3,6 +3,8 @@ package fasttest
 import (
 	"errors"
 	"fmt"
+	"strings"
+	"time"
 )
 
 var (
@@ -19,3 +21,90 @@ type AssertionError struct {
 func (e *AssertionError) Error() string {
 	return fmt.Sprintf("%s: expected '%s', got '%s'", e.Message, e.Expected, e.Actual)
 }
+
+// StepError is the failure of a single step. It keeps the step, with the
+// file and line it came from, so callers can point at the failing line.
+type StepError struct {
+	Index   int // 1-based position of the step in its block
+	Step    Step
+	Elapsed time.Duration
+	// Timeout is set when the step ran out of time: its own step timeout,
+	// or the test timeout when TestTimeout is true
+	Timeout     time.Duration
+	TestTimeout bool
+	Err         error
+}
+
+func (e *StepError) Error() string {
+	msg := fmt.Sprintf("step %d (%s)", e.Index, e.Step)
+	if e.Step.Line > 0 {
+		msg += " at " + location(e.Step.File, e.Step.Line)
+	}
+	if e.Step.CallLine > 0 {
+		msg += ", called from " + location(e.Step.CallFile, e.Step.CallLine)
+	}
+
+	switch {
+	case e.TestTimeout:
+		msg += fmt.Sprintf(" hit the test timeout of %s", e.Timeout)
+	case e.Timeout > 0:
+		msg += fmt.Sprintf(" timed out after %s", e.Timeout)
+	case e.Elapsed > 0:
+		msg += fmt.Sprintf(" failed after %s", e.Elapsed.Round(time.Millisecond))
+	}
+	return fmt.Sprintf("%s: %v", msg, e.Err)
+}
+
+// location describes a line of a file, or just the line when the file is
+// not known
+func location(file string, line int) string {
+	if file != "" {
+		return fmt.Sprintf("%s:%d", file, line)
+	}
+	return fmt.Sprintf("line %d", line)
+}
+
+func (e *StepError) Unwrap() error {
+	return e.Err
+}
+
+// Selector returns the element the step acted on, or "" if its action does
+// not take one
+func (e *StepError) Selector() string {
+	if !TakesSelector(e.Step.Action) {
+		return ""
+	}
+	if e.Step.Action == "assert_attribute" {
+		// Target is "selector|attribute"
+		selector, _, _ := strings.Cut(e.Step.Target, "|")
+		return selector
+	}
+	return e.Step.Target
+}
+
+// selectorActions are the actions whose Target is a CSS selector
+var selectorActions = map[string]bool{
+	"click":                     true,
+	"type":                      true,
+	"clear":                     true,
+	"upload":                    true,
+	"wait_for":                  true,
+	"wait_for_text":             true,
+	"assert_text":               true,
+	"assert_text_contains":      true,
+	"assert_element_exists":     true,
+	"assert_element_not_exists": true,
+	"assert_attribute":          true,
+	"select":                    true,
+	"check":                     true,
+	"uncheck":                   true,
+	"hover":                     true,
+	"double_click":              true,
+	"right_click":               true,
+	"store_text":                true,
+}
+
+// TakesSelector reports whether the Target of an action is a CSS selector
+func TakesSelector(action string) bool {
+	return selectorActions[action]
+}
@@ -0,0 +1,84 @@
+package fasttest
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Filter selects which tests run, by name and by tag
+type Filter struct {
+	Run     *regexp.Regexp // Test names must match, if set
+	Include []string       // Tests need at least one of these tags, if any are given
+	Exclude []string       // Tests with any of these tags are left out
+}
+
+// ParseFilter builds a filter from a name regexp and a tag expression such
+// as "smoke,!slow". Either may be empty.
+func ParseFilter(run, tags string) (*Filter, error) {
+	f := &Filter{}
+	if run != "" {
+		re, err := regexp.Compile(run)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -run pattern: %v", err)
+		}
+		f.Run = re
+	}
+	for _, tag := range strings.Split(tags, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "@")
+		switch {
+		case tag == "":
+		case strings.HasPrefix(tag, "!"):
+			f.Exclude = append(f.Exclude, strings.TrimPrefix(strings.TrimPrefix(tag, "!"), "@"))
+		default:
+			f.Include = append(f.Include, tag)
+		}
+	}
+	return f, nil
+}
+
+// Match reports whether the test passes the filter
+func (f *Filter) Match(test Test) bool {
+	if f == nil {
+		return true
+	}
+	if f.Run != nil && !f.Run.MatchString(test.Name) {
+		return false
+	}
+	for _, tag := range f.Exclude {
+		if test.HasTag(tag) {
+			return false
+		}
+	}
+	if len(f.Include) == 0 {
+		return true
+	}
+	for _, tag := range f.Include {
+		if test.HasTag(tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply returns the suite with only the tests that pass the filter
+func (f *Filter) Apply(suite Suite) Suite {
+	tests := make([]Test, 0, len(suite.Tests))
+	for _, test := range suite.Tests {
+		if f.Match(test) {
+			tests = append(tests, test)
+		}
+	}
+	suite.Tests = tests
+	return suite
+}
+
+// HasTag reports whether the test is annotated with @tag
+func (t Test) HasTag(tag string) bool {
+	for _, tt := range t.Tags {
+		if tt == tag {
+			return true
+		}
+	}
+	return false
+}
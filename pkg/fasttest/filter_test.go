@@ -0,0 +1,58 @@
+package fasttest
+
+import (
+	"testing"
+)
+
+func TestFilter(t *testing.T) {
+	checkout := Test{Name: "Checkout with card", Tags: []string{"smoke", "slow"}}
+	login := Test{Name: "Login", Tags: []string{"smoke"}}
+	search := Test{Name: "Search"}
+
+	tests := []struct {
+		name string
+		run  string
+		tags string
+		want []bool // checkout, login, search
+	}{
+		{name: "no filter", want: []bool{true, true, true}},
+		{name: "run regexp", run: "^Check", want: []bool{true, false, false}},
+		{name: "include tag", tags: "smoke", want: []bool{true, true, false}},
+		{name: "include and exclude", tags: "smoke,!slow", want: []bool{false, true, false}},
+		{name: "exclude only", tags: "!slow", want: []bool{false, true, true}},
+		{name: "tags with @", tags: "@smoke, !@slow", want: []bool{false, true, false}},
+		{name: "run and tags", run: "Login|Search", tags: "smoke", want: []bool{false, true, false}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := ParseFilter(tt.run, tt.tags)
+			if err != nil {
+				t.Fatalf("ParseFilter() error = %v", err)
+			}
+			for i, test := range []Test{checkout, login, search} {
+				if got := f.Match(test); got != tt.want[i] {
+					t.Errorf("Match(%q) = %v, want %v", test.Name, got, tt.want[i])
+				}
+			}
+		})
+	}
+
+	if _, err := ParseFilter("(", ""); err == nil {
+		t.Error("Expected error for invalid -run pattern")
+	}
+}
+
+func TestFilterApply(t *testing.T) {
+	f, _ := ParseFilter("", "smoke")
+	suite := f.Apply(Suite{
+		Name: "suite.test",
+		Tests: []Test{
+			{Name: "a", Tags: []string{"smoke"}},
+			{Name: "b"},
+		},
+	})
+	if len(suite.Tests) != 1 || suite.Tests[0].Name != "a" {
+		t.Errorf("Unexpected tests after filter: %v", suite.Tests)
+	}
+}
@@ -0,0 +1,150 @@
+package fasttest
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/dom"
+	"github.com/chromedp/cdproto/runtime"
+	"github.com/chromedp/chromedp"
+)
+
+// callOnElement calls a JavaScript function with this set to the first
+// visible element matching selector
+func callOnElement(ctx context.Context, selector, function string, res interface{}, args ...interface{}) error {
+	var nodes []*cdp.Node
+	if err := chromedp.Run(ctx, chromedp.Nodes(selector, &nodes, chromedp.NodeVisible)); err != nil {
+		return err
+	}
+	if len(nodes) == 0 {
+		return fmt.Errorf("element not found: %s", selector)
+	}
+	return chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		obj, err := dom.ResolveNode().WithNodeID(nodes[0].NodeID).Do(ctx)
+		if err != nil {
+			return err
+		}
+		defer runtime.ReleaseObject(obj.ObjectID).Do(ctx)
+		return chromedp.CallFunctionOn(function, res, func(p *runtime.CallFunctionOnParams) *runtime.CallFunctionOnParams {
+			return p.WithObjectID(obj.ObjectID)
+		}, args...).Do(ctx)
+	}))
+}
+
+// setChecked clicks a checkbox or radio button only if it is not already in
+// the wanted state, so check on a checked box leaves it checked
+func setChecked(ctx context.Context, selector string, want bool) error {
+	const checkedJS = `function() {
+		if (!('checked' in this)) return null;
+		return this.checked;
+	}`
+	var checked *bool
+	if err := callOnElement(ctx, selector, checkedJS, &checked); err != nil {
+		return err
+	}
+	if checked == nil {
+		return fmt.Errorf("%s is not a checkbox or radio button", selector)
+	}
+	if *checked == want {
+		return nil
+	}
+
+	if err := chromedp.Run(ctx, chromedp.Click(selector, chromedp.NodeVisible)); err != nil {
+		return err
+	}
+	if err := callOnElement(ctx, selector, checkedJS, &checked); err != nil {
+		return err
+	}
+	if checked == nil || *checked != want {
+		verb := "check"
+		if !want {
+			verb = "uncheck"
+		}
+		return fmt.Errorf("clicking %s did not %s it", selector, verb)
+	}
+	return nil
+}
+
+// ParseOption splits the option argument of select into how to match and
+// what to match. "label=Text" matches the visible label, "index=N" the Nth
+// option from 0, and "value=v" only the value. Anything else matches the
+// value, then the label.
+func ParseOption(arg string) (by, want string, err error) {
+	for _, prefix := range []string{"value", "label", "index"} {
+		if rest, ok := strings.CutPrefix(arg, prefix+"="); ok {
+			if prefix == "index" {
+				if n, err := strconv.Atoi(rest); err != nil || n < 0 {
+					return "", "", fmt.Errorf("invalid option index: %s", rest)
+				}
+			}
+			return prefix, rest, nil
+		}
+	}
+	return "", arg, nil
+}
+
+// selectOptionJS picks an option of a <select> and fires input and change,
+// as a user's choice would, so frameworks see it
+const selectOptionJS = `function(by, want) {
+	if (this.tagName !== 'SELECT') return {error: 'not a <select> element'};
+	const options = Array.from(this.options);
+	let i = -1;
+	if (by === 'index') {
+		i = Number(want) < options.length ? Number(want) : -1;
+	} else {
+		if (by !== 'label') i = options.findIndex(o => o.value === want);
+		if (i < 0 && by !== 'value') i = options.findIndex(o => o.label.trim() === want || o.text.trim() === want);
+	}
+	if (i < 0) {
+		return {options: options.map(o => ({value: o.value, label: o.label.trim()}))};
+	}
+	if (options[i].disabled) return {error: 'option ' + JSON.stringify(want) + ' is disabled'};
+	if (this.multiple) {
+		options[i].selected = true;
+	} else {
+		this.selectedIndex = i;
+	}
+	this.dispatchEvent(new Event('input', {bubbles: true}));
+	this.dispatchEvent(new Event('change', {bubbles: true}));
+	return {selected: true};
+}`
+
+func selectOption(ctx context.Context, selector, arg string) error {
+	by, want, err := ParseOption(arg)
+	if err != nil {
+		return err
+	}
+	var res struct {
+		Selected bool   `json:"selected"`
+		Error    string `json:"error"`
+		Options  []struct {
+			Value string `json:"value"`
+			Label string `json:"label"`
+		} `json:"options"`
+	}
+	if err := callOnElement(ctx, selector, selectOptionJS, &res, by, want); err != nil {
+		return err
+	}
+	if res.Error != "" {
+		return fmt.Errorf("%s: %s", selector, res.Error)
+	}
+	if res.Selected {
+		return nil
+	}
+
+	var options []string
+	for _, o := range res.Options {
+		if o.Label == "" || o.Label == o.Value {
+			options = append(options, strconv.Quote(o.Value))
+		} else {
+			options = append(options, fmt.Sprintf("%q (%s)", o.Value, o.Label))
+		}
+	}
+	if len(options) == 0 {
+		return fmt.Errorf("no option %s in %s, which has no options", arg, selector)
+	}
+	return fmt.Errorf("no option %s in %s; options are %s", arg, selector, strings.Join(options, ", "))
+}
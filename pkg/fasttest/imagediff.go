@@ -0,0 +1,298 @@
+package fasttest
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"math"
+	"sync"
+)
+
+// DefaultPixelThreshold is how far apart two pixels may be in perceived
+// color, from 0 to 1, and still match when Config.PixelThreshold is 0
+const DefaultPixelThreshold = 0.1
+
+// imageComparison is the outcome of comparing a screenshot with its baseline
+type imageComparison struct {
+	diff        float64 // Share of pixels that differ, from 0 to 1
+	antialiased int     // Differing pixels put down to anti-aliasing, not counted in diff
+	ssim        float64 // Structural similarity, only computed when Config.ScreenshotMinSSIM is set
+	sizeChanged bool
+	diffImage   []byte // PNG with differences in red and anti-aliasing in yellow
+}
+
+// compareImages compares two PNGs pixel by pixel, in the manner of
+// pixelmatch: pixels match when their YIQ color distance is under the pixel
+// threshold, and differing pixels that look like anti-aliasing in either
+// image, such as font edges rendered slightly differently, are not counted.
+func (r *Runner) compareImages(baseline, current []byte) (imageComparison, error) {
+	baselineImg, err := png.Decode(bytes.NewReader(baseline))
+	if err != nil {
+		return imageComparison{}, err
+	}
+	currentImg, err := png.Decode(bytes.NewReader(current))
+	if err != nil {
+		return imageComparison{}, err
+	}
+	if bytes.Equal(baseline, current) {
+		return imageComparison{ssim: 1}, nil
+	}
+	if baselineImg.Bounds().Size() != currentImg.Bounds().Size() {
+		return imageComparison{diff: 1, sizeChanged: true}, nil
+	}
+
+	a, b := toNRGBA(baselineImg), toNRGBA(currentImg)
+	threshold := r.config.PixelThreshold
+	if threshold <= 0 {
+		threshold = DefaultPixelThreshold
+	}
+	// The largest YIQ distance between two colors is 35215
+	maxDelta := 35215 * threshold * threshold
+
+	bounds := a.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	diffImg := image.NewRGBA(bounds)
+
+	// Rows are split between workers, which only write their own rows
+	numWorkers := 4
+	rowsPerWorker := height / numWorkers
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	differentPixels, antialiasedPixels := 0, 0
+
+	for w := 0; w < numWorkers; w++ {
+		startY := w * rowsPerWorker
+		endY := startY + rowsPerWorker
+		if w == numWorkers-1 {
+			endY = height
+		}
+
+		wg.Add(1)
+		go func(startY, endY int) {
+			defer wg.Done()
+			localDiff, localAA := 0, 0
+			for y := startY; y < endY; y++ {
+				for x := 0; x < width; x++ {
+					delta := colorDelta(a, b, x, y, x, y, false)
+					switch {
+					case math.Abs(delta) <= maxDelta:
+						// Matching pixels are shown faded, for context
+						gray := uint8(255 + (yiqY(pixel(a, x, y))-255)*0.1)
+						diffImg.SetRGBA(x, y, color.RGBA{gray, gray, gray, 255})
+					case antialiased(a, b, x, y) || antialiased(b, a, x, y):
+						localAA++
+						diffImg.SetRGBA(x, y, color.RGBA{255, 255, 0, 255})
+					default:
+						localDiff++
+						diffImg.SetRGBA(x, y, color.RGBA{255, 0, 0, 255})
+					}
+				}
+			}
+			mu.Lock()
+			differentPixels += localDiff
+			antialiasedPixels += localAA
+			mu.Unlock()
+		}(startY, endY)
+	}
+	wg.Wait()
+
+	result := imageComparison{
+		diff:        float64(differentPixels) / float64(width*height),
+		antialiased: antialiasedPixels,
+	}
+	if r.config.ScreenshotMinSSIM > 0 {
+		result.ssim = ssim(a, b)
+	}
+	if differentPixels > 0 || antialiasedPixels > 0 {
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, diffImg); err != nil {
+			return imageComparison{}, err
+		}
+		result.diffImage = buf.Bytes()
+	}
+	return result, nil
+}
+
+// toNRGBA converts an image to non-premultiplied RGBA with bounds starting
+// at 0, 0
+func toNRGBA(img image.Image) *image.NRGBA {
+	bounds := img.Bounds()
+	out := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	draw.Draw(out, out.Bounds(), img, bounds.Min, draw.Src)
+	return out
+}
+
+// pixel returns the red, green and blue of a pixel blended onto white, so
+// transparent pixels compare as they look
+func pixel(img *image.NRGBA, x, y int) (float64, float64, float64) {
+	c := img.NRGBAAt(x, y)
+	r, g, b := float64(c.R), float64(c.G), float64(c.B)
+	if c.A < 255 {
+		alpha := float64(c.A) / 255
+		r = 255 + (r-255)*alpha
+		g = 255 + (g-255)*alpha
+		b = 255 + (b-255)*alpha
+	}
+	return r, g, b
+}
+
+func yiqY(r, g, b float64) float64 { return r*0.29889531 + g*0.58662247 + b*0.11448223 }
+func yiqI(r, g, b float64) float64 { return r*0.59597799 - g*0.27417610 - b*0.32180189 }
+func yiqQ(r, g, b float64) float64 { return r*0.21147017 - g*0.52261711 + b*0.31114694 }
+
+// colorDelta is the perceived distance between two pixels in YIQ space, as
+// measured by Kotsarenko and Ramos. It is negative when the second pixel is
+// brighter. yOnly gives the difference in brightness alone.
+func colorDelta(img1, img2 *image.NRGBA, x1, y1, x2, y2 int, yOnly bool) float64 {
+	r1, g1, b1 := pixel(img1, x1, y1)
+	r2, g2, b2 := pixel(img2, x2, y2)
+	if r1 == r2 && g1 == g2 && b1 == b2 {
+		return 0
+	}
+	yDelta := yiqY(r1, g1, b1) - yiqY(r2, g2, b2)
+	if yOnly {
+		return yDelta
+	}
+	i := yiqI(r1, g1, b1) - yiqI(r2, g2, b2)
+	q := yiqQ(r1, g1, b1) - yiqQ(r2, g2, b2)
+	delta := 0.5053*yDelta*yDelta + 0.299*i*i + 0.1957*q*q
+	if yDelta > 0 {
+		return -delta
+	}
+	return delta
+}
+
+// antialiased reports whether the pixel at x, y of img looks like
+// anti-aliasing: it sits between a darker and a brighter neighbour, and one
+// of those lies in a flat area of both images
+func antialiased(img, other *image.NRGBA, x, y int) bool {
+	bounds := img.Bounds()
+	x0, y0 := max(x-1, 0), max(y-1, 0)
+	x1, y1 := min(x+1, bounds.Dx()-1), min(y+1, bounds.Dy()-1)
+	zeroes := 0
+	if x == x0 || x == x1 || y == y0 || y == y1 {
+		zeroes = 1
+	}
+
+	var minDelta, maxDelta float64
+	var minX, minY, maxX, maxY int
+	for nx := x0; nx <= x1; nx++ {
+		for ny := y0; ny <= y1; ny++ {
+			if nx == x && ny == y {
+				continue
+			}
+			delta := colorDelta(img, img, x, y, nx, ny, true)
+			switch {
+			case delta == 0:
+				zeroes++
+				// More than two equal neighbours means a flat area, not an edge
+				if zeroes > 2 {
+					return false
+				}
+			case delta < minDelta:
+				minDelta, minX, minY = delta, nx, ny
+			case delta > maxDelta:
+				maxDelta, maxX, maxY = delta, nx, ny
+			}
+		}
+	}
+	if minDelta == 0 || maxDelta == 0 {
+		return false
+	}
+	return (hasManySiblings(img, minX, minY) && hasManySiblings(other, minX, minY)) ||
+		(hasManySiblings(img, maxX, maxY) && hasManySiblings(other, maxX, maxY))
+}
+
+// hasManySiblings reports whether more than two neighbours of a pixel have
+// exactly its color
+func hasManySiblings(img *image.NRGBA, x, y int) bool {
+	bounds := img.Bounds()
+	x0, y0 := max(x-1, 0), max(y-1, 0)
+	x1, y1 := min(x+1, bounds.Dx()-1), min(y+1, bounds.Dy()-1)
+	zeroes := 0
+	if x == x0 || x == x1 || y == y0 || y == y1 {
+		zeroes = 1
+	}
+	c := img.NRGBAAt(x, y)
+	for nx := x0; nx <= x1; nx++ {
+		for ny := y0; ny <= y1; ny++ {
+			if nx == x && ny == y {
+				continue
+			}
+			if img.NRGBAAt(nx, ny) == c {
+				zeroes++
+			}
+			if zeroes > 2 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ssimWindow is the side of the square windows SSIM is averaged over
+const ssimWindow = 8
+
+// ssim is the mean structural similarity of the brightness of two images of
+// the same size, over windows of ssimWindow pixels, from -1 to 1 where 1 is
+// identical
+func ssim(a, b *image.NRGBA) float64 {
+	const (
+		c1 = (0.01 * 255) * (0.01 * 255)
+		c2 = (0.03 * 255) * (0.03 * 255)
+	)
+	width, height := a.Bounds().Dx(), a.Bounds().Dy()
+	window := min(ssimWindow, width, height)
+	if window == 0 {
+		return 1
+	}
+
+	var total float64
+	windows := 0
+	for wy := 0; wy+window <= height; wy += window {
+		for wx := 0; wx+window <= width; wx += window {
+			var sumA, sumB, sumAA, sumBB, sumAB float64
+			for y := wy; y < wy+window; y++ {
+				for x := wx; x < wx+window; x++ {
+					la, lb := yiqY(pixel(a, x, y)), yiqY(pixel(b, x, y))
+					sumA += la
+					sumB += lb
+					sumAA += la * la
+					sumBB += lb * lb
+					sumAB += la * lb
+				}
+			}
+			n := float64(window * window)
+			meanA, meanB := sumA/n, sumB/n
+			varA := sumAA/n - meanA*meanA
+			varB := sumBB/n - meanB*meanB
+			cov := sumAB/n - meanA*meanB
+			total += ((2*meanA*meanB + c1) * (2*cov + c2)) /
+				((meanA*meanA + meanB*meanB + c1) * (varA + varB + c2))
+			windows++
+		}
+	}
+	return total / float64(windows)
+}
+
+// screenshotMismatch describes why a screenshot fails against its baseline,
+// or returns "" if it passes
+func (r *Runner) screenshotMismatch(cmp imageComparison) string {
+	if cmp.sizeChanged {
+		return "differs from baseline in size"
+	}
+	if cmp.diff > r.config.ScreenshotThreshold {
+		msg := fmt.Sprintf("differs from baseline by %.2f%% of pixels (threshold: %.2f%%)", cmp.diff*100, r.config.ScreenshotThreshold*100)
+		if cmp.antialiased > 0 {
+			msg += fmt.Sprintf(", not counting %d anti-aliased pixels", cmp.antialiased)
+		}
+		return msg
+	}
+	if minSSIM := r.config.ScreenshotMinSSIM; minSSIM > 0 && cmp.ssim < minSSIM {
+		return fmt.Sprintf("has a structural similarity of %.4f to the baseline (minimum: %.4f)", cmp.ssim, minSSIM)
+	}
+	return ""
+}
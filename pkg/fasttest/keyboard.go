@@ -0,0 +1,258 @@
+package fasttest
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/chromedp/cdproto/input"
+	"github.com/chromedp/chromedp"
+	"github.com/chromedp/chromedp/kb"
+)
+
+// keyboardState is the modifiers held down with key_down, which apply to
+// later key presses, typing and clicks until key_up
+type keyboardState struct {
+	modifiers input.Modifier
+}
+
+var modifierKeys = map[string]input.Modifier{
+	"Alt":     input.ModifierAlt,
+	"Control": input.ModifierCtrl,
+	"Meta":    input.ModifierMeta,
+	"Shift":   input.ModifierShift,
+}
+
+var keyAliases = map[string]string{
+	"ctrl":   "Control",
+	"cmd":    "Meta",
+	"option": "Alt",
+	"esc":    "Escape",
+	"return": "Enter",
+	"del":    "Delete",
+	"up":     "ArrowUp",
+	"down":   "ArrowDown",
+	"left":   "ArrowLeft",
+	"right":  "ArrowRight",
+	"space":  " ",
+	"plus":   "+",
+}
+
+// keysByName indexes the keys of chromedp's keyboard map by their DOM key
+// value, lower-cased, e.g. "enter" or "arrowdown"
+var keysByName = func() map[string]*kb.Key {
+	keys := make(map[string]*kb.Key)
+	runes := make(map[string]rune)
+	for r, key := range kb.Keys {
+		if utf8.RuneCountInString(key.Key) == 1 {
+			continue
+		}
+		name := strings.ToLower(key.Key)
+		// Several runes map to some keys, like \r and \n to Enter; keep
+		// the lowest so the choice does not depend on map order
+		if prev, ok := runes[name]; !ok || r < prev {
+			keys[name] = key
+			runes[name] = r
+		}
+	}
+	return keys
+}()
+
+// LookupKey finds a key by its DOM name, like "Enter", "ArrowDown" or "F5",
+// an alias such as "Ctrl" or "Esc", or a single character. Names are matched
+// case-insensitively.
+func LookupKey(name string) (*kb.Key, error) {
+	if alias, ok := keyAliases[strings.ToLower(name)]; ok {
+		name = alias
+	}
+	if utf8.RuneCountInString(name) == 1 {
+		r, _ := utf8.DecodeRuneInString(name)
+		if key, ok := kb.Keys[r]; ok {
+			return key, nil
+		}
+	}
+	if key, ok := keysByName[strings.ToLower(name)]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key: %s", name)
+}
+
+// ParseKeys parses a key combination like "Control+A" or "Shift+Tab" into the
+// modifiers and the key pressed with them. The key may itself be a modifier,
+// as in "Shift".
+func ParseKeys(combo string) (input.Modifier, *kb.Key, error) {
+	parts := strings.Split(combo, "+")
+	// "Control++" presses the plus key
+	if strings.HasSuffix(combo, "++") {
+		parts = append(parts[:len(parts)-2], "+")
+	}
+	for _, part := range parts {
+		if part == "" {
+			return 0, nil, fmt.Errorf("invalid key combination: %s", combo)
+		}
+	}
+
+	var modifiers input.Modifier
+	for _, part := range parts[:len(parts)-1] {
+		key, err := LookupKey(part)
+		if err != nil {
+			return 0, nil, err
+		}
+		modifier, ok := modifierKeys[key.Key]
+		if !ok {
+			return 0, nil, fmt.Errorf("%s is not a modifier in %s; use Alt, Control, Meta or Shift", part, combo)
+		}
+		modifiers |= modifier
+	}
+
+	key, err := LookupKey(parts[len(parts)-1])
+	if err != nil {
+		return 0, nil, err
+	}
+	// Letters follow Shift, as on a real keyboard: Shift+a is A, and
+	// Control+A is the same shortcut as Control+a
+	if modifiers != 0 && len(key.Key) == 1 {
+		letter := strings.ToLower(key.Key)
+		if modifiers&input.ModifierShift != 0 {
+			letter = strings.ToUpper(letter)
+		}
+		if k, ok := kb.Keys[rune(letter[0])]; ok {
+			key = k
+		}
+	}
+	return modifiers, key, nil
+}
+
+// keyEvent builds a key down or up event. Printable keys carry their text on
+// key down, unless a shortcut modifier is held, so they type and Enter
+// submits forms.
+func keyEvent(typ input.KeyType, key *kb.Key, modifiers input.Modifier) *input.DispatchKeyEventParams {
+	if key.Shift {
+		modifiers |= input.ModifierShift
+	}
+	event := &input.DispatchKeyEventParams{
+		Type:                  typ,
+		Key:                   key.Key,
+		Code:                  key.Code,
+		WindowsVirtualKeyCode: key.Windows,
+		NativeVirtualKeyCode:  key.Native,
+		Modifiers:             modifiers,
+	}
+	if runtime.GOOS == "darwin" {
+		event.NativeVirtualKeyCode = 0
+	}
+	shortcut := modifiers&(input.ModifierCtrl|input.ModifierAlt|input.ModifierMeta) != 0
+	if typ == input.KeyDown && key.Print && !shortcut {
+		event.Text = key.Text
+		event.UnmodifiedText = key.Unmodified
+	} else if typ == input.KeyDown {
+		event.Type = input.KeyRawDown
+	}
+	return event
+}
+
+// pressKeys presses and releases each space-separated key combination in
+// turn, holding its modifiers down around the key
+func pressKeys(ctx context.Context, state *testState, combos string) error {
+	for _, combo := range strings.Fields(combos) {
+		modifiers, key, err := ParseKeys(combo)
+		if err != nil {
+			return err
+		}
+
+		var events []*input.DispatchKeyEventParams
+		held := state.keyboard.modifiers
+		var pressed []*kb.Key
+		for _, name := range []string{"Control", "Alt", "Meta", "Shift"} {
+			modifier := modifierKeys[name]
+			if modifiers&modifier == 0 || held&modifier != 0 {
+				continue
+			}
+			mod, _ := LookupKey(name)
+			held |= modifier
+			events = append(events, keyEvent(input.KeyDown, mod, held))
+			pressed = append(pressed, mod)
+		}
+		events = append(events,
+			keyEvent(input.KeyDown, key, held),
+			keyEvent(input.KeyUp, key, held))
+		for i := len(pressed) - 1; i >= 0; i-- {
+			held &^= modifierKeys[pressed[i].Key]
+			events = append(events, keyEvent(input.KeyUp, pressed[i], held))
+		}
+
+		for _, event := range events {
+			if err := chromedp.Run(ctx, event); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// keyDown and keyUp hold and release a key. Held modifiers apply to the steps
+// that follow.
+func keyDown(ctx context.Context, state *testState, name string) error {
+	key, err := LookupKey(name)
+	if err != nil {
+		return err
+	}
+	state.keyboard.modifiers |= modifierKeys[key.Key]
+	return chromedp.Run(ctx, keyEvent(input.KeyDown, key, state.keyboard.modifiers))
+}
+
+func keyUp(ctx context.Context, state *testState, name string) error {
+	key, err := LookupKey(name)
+	if err != nil {
+		return err
+	}
+	state.keyboard.modifiers &^= modifierKeys[key.Key]
+	return chromedp.Run(ctx, keyEvent(input.KeyUp, key, state.keyboard.modifiers))
+}
+
+// typeFocused types text into whatever element has focus
+func typeFocused(ctx context.Context, state *testState, text string) error {
+	return chromedp.Run(ctx, chromedp.KeyEvent(text, chromedp.KeyModifiers(state.keyboard.modifiers)))
+}
+
+// clearJS empties an input, textarea or contenteditable element through the
+// native value setter, so frameworks that track the value see the change.
+// Custom elements and subclasses may not define value on their own
+// prototype, or may override it, so the setter of the built-in element is
+// used, or else the nearest one up the prototype chain.
+const clearJS = `function() {
+	if (this.isContentEditable) {
+		this.textContent = '';
+	} else if ('value' in this) {
+		let proto = this instanceof HTMLInputElement ? HTMLInputElement.prototype
+			: this instanceof HTMLTextAreaElement ? HTMLTextAreaElement.prototype
+			: Object.getPrototypeOf(this);
+		let desc;
+		while (proto && !(desc = Object.getOwnPropertyDescriptor(proto, 'value'))) {
+			proto = Object.getPrototypeOf(proto);
+		}
+		if (desc && desc.set) {
+			desc.set.call(this, '');
+		} else {
+			this.value = '';
+		}
+	} else {
+		return false;
+	}
+	this.dispatchEvent(new Event('input', {bubbles: true}));
+	this.dispatchEvent(new Event('change', {bubbles: true}));
+	return true;
+}`
+
+func clearElement(ctx context.Context, selector string) error {
+	var cleared bool
+	if err := callOnElement(ctx, selector, clearJS, &cleared); err != nil {
+		return err
+	}
+	if !cleared {
+		return fmt.Errorf("%s is not an input, textarea or editable element", selector)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+package fasttest
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/dom"
+	"github.com/chromedp/cdproto/input"
+	"github.com/chromedp/chromedp"
+)
+
+// mouseState is where the mouse is and which buttons are held, so mouse_down
+// and mouse_up act where the last move left the pointer, and moves while a
+// button is held drag. Modifiers held with key_down apply to mouse events.
+type mouseState struct {
+	x, y    float64
+	buttons int64 // Bit mask, as in MouseEvent.buttons
+}
+
+// MouseButtons are the buttons mouse_down and mouse_up accept
+var MouseButtons = map[string]input.MouseButton{
+	"left":   input.Left,
+	"middle": input.Middle,
+	"right":  input.Right,
+}
+
+// buttonMask is the MouseEvent.buttons bit of each button
+var buttonMask = map[input.MouseButton]int64{
+	input.Left:   1,
+	input.Right:  2,
+	input.Middle: 4,
+}
+
+// LookupMouseButton returns the named button, left when name is empty
+func LookupMouseButton(name string) (input.MouseButton, error) {
+	if name == "" {
+		return input.Left, nil
+	}
+	button, ok := MouseButtons[name]
+	if !ok {
+		return "", fmt.Errorf("unknown mouse button %q, expected left, middle or right", name)
+	}
+	return button, nil
+}
+
+// elementCenter scrolls the first element matching selector into view and
+// returns the center of its content box, in viewport coordinates
+func elementCenter(ctx context.Context, selector string) (float64, float64, error) {
+	var nodes []*cdp.Node
+	if err := chromedp.Run(ctx, chromedp.Nodes(selector, &nodes, chromedp.NodeVisible)); err != nil {
+		return 0, 0, err
+	}
+	if len(nodes) == 0 {
+		return 0, 0, fmt.Errorf("element not found: %s", selector)
+	}
+
+	var box *dom.BoxModel
+	err := chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		if err := dom.ScrollIntoViewIfNeeded().WithNodeID(nodes[0].NodeID).Do(ctx); err != nil {
+			return err
+		}
+		var err error
+		box, err = dom.GetBoxModel().WithNodeID(nodes[0].NodeID).Do(ctx)
+		return err
+	}))
+	if err != nil {
+		return 0, 0, fmt.Errorf("cannot get the position of %s: %v", selector, err)
+	}
+	x, y, ok := quadCenter(box.Content)
+	if !ok {
+		return 0, 0, fmt.Errorf("element has no box: %s", selector)
+	}
+	return x, y, nil
+}
+
+// quadCenter returns the center of a quad of four x, y points
+func quadCenter(quad dom.Quad) (float64, float64, bool) {
+	if len(quad) != 8 {
+		return 0, 0, false
+	}
+	var x, y float64
+	for i := 0; i < 8; i += 2 {
+		x += quad[i]
+		y += quad[i+1]
+	}
+	return x / 4, y / 4, true
+}
+
+// mouseMove moves the pointer, dragging with whatever buttons are held
+func mouseMove(ctx context.Context, state *testState, x, y float64) error {
+	mouse := &state.mouse
+	mouse.x, mouse.y = x, y
+	event := input.DispatchMouseEvent(input.MouseMoved, x, y).
+		WithButtons(mouse.buttons).
+		WithModifiers(state.keyboard.modifiers)
+	return chromedp.Run(ctx, event)
+}
+
+// mouseButton presses or releases a button where the pointer is
+func mouseButton(ctx context.Context, state *testState, typ input.MouseType, button input.MouseButton, clickCount int64) error {
+	mouse := &state.mouse
+	if typ == input.MousePressed {
+		mouse.buttons |= buttonMask[button]
+	} else {
+		mouse.buttons &^= buttonMask[button]
+	}
+	event := input.DispatchMouseEvent(typ, mouse.x, mouse.y).
+		WithButton(button).
+		WithButtons(mouse.buttons).
+		WithClickCount(clickCount).
+		WithModifiers(state.keyboard.modifiers)
+	return chromedp.Run(ctx, event)
+}
+
+// clickElement moves to the center of an element and clicks it count times
+// with button, as a user would, so dblclick and contextmenu fire
+func clickElement(ctx context.Context, state *testState, selector string, button input.MouseButton, count int64) error {
+	x, y, err := elementCenter(ctx, selector)
+	if err != nil {
+		return err
+	}
+	if err := mouseMove(ctx, state, x, y); err != nil {
+		return err
+	}
+	for i := int64(1); i <= count; i++ {
+		if err := mouseButton(ctx, state, input.MousePressed, button, i); err != nil {
+			return err
+		}
+		if err := mouseButton(ctx, state, input.MouseReleased, button, i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,380 @@
+package fasttest
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+// request is a request the page made during a test, with its response once
+// it arrives
+type request struct {
+	id          network.RequestID
+	method      string
+	url         string
+	body        string
+	hasBody     bool // The body may be too long to come with the event
+	bodyFetched bool
+	status      int64 // 0 until the response arrives
+	failed      string
+	// waited is set once a wait_for_request step has matched it, so the
+	// next wait_for_request waits for another request
+	waited bool
+}
+
+func (req *request) done() bool {
+	return req.status != 0 || req.failed != ""
+}
+
+func (req *request) String() string {
+	switch {
+	case req.status != 0:
+		return fmt.Sprintf("%s %s (%d)", req.method, req.url, req.status)
+	case req.failed != "":
+		return fmt.Sprintf("%s %s (%s)", req.method, req.url, req.failed)
+	}
+	return fmt.Sprintf("%s %s (pending)", req.method, req.url)
+}
+
+// networkLog records the requests of a test. Browser events update it while
+// steps wait on it, so it is guarded by mu.
+type networkLog struct {
+	mu       sync.Mutex
+	changed  chan struct{} // Closed and replaced on every update
+	requests []*request
+	byID     map[network.RequestID]*request // The latest request of each id; redirects reuse ids
+	last     *request                       // Matched by the latest wait_for_request
+}
+
+func newNetworkLog() *networkLog {
+	return &networkLog{changed: make(chan struct{}), byID: make(map[network.RequestID]*request)}
+}
+
+// listen records the requests of the browser. It listens on the browser
+// context rather than the test timeout, so after_each can check requests too.
+func (nl *networkLog) listen(browserCtx context.Context) {
+	chromedp.ListenTarget(browserCtx, func(ev interface{}) {
+		switch ev := ev.(type) {
+		case *network.EventRequestWillBeSent:
+			nl.update(func() {
+				if prev, ok := nl.byID[ev.RequestID]; ok && ev.RedirectResponse != nil {
+					prev.status = ev.RedirectResponse.Status
+				}
+				req := &request{
+					id:      ev.RequestID,
+					method:  ev.Request.Method,
+					url:     ev.Request.URL + ev.Request.URLFragment,
+					hasBody: ev.Request.HasPostData,
+				}
+				for _, entry := range ev.Request.PostDataEntries {
+					data, err := base64.StdEncoding.DecodeString(entry.Bytes)
+					if err != nil {
+						continue
+					}
+					req.body += string(data)
+					req.bodyFetched = true
+				}
+				nl.requests = append(nl.requests, req)
+				nl.byID[ev.RequestID] = req
+			})
+		case *network.EventResponseReceived:
+			nl.update(func() {
+				if req, ok := nl.byID[ev.RequestID]; ok {
+					req.status = ev.Response.Status
+				}
+			})
+		case *network.EventLoadingFailed:
+			nl.update(func() {
+				if req, ok := nl.byID[ev.RequestID]; ok && req.status == 0 {
+					req.failed = ev.ErrorText
+				}
+			})
+		}
+	})
+}
+
+func (nl *networkLog) update(fn func()) {
+	nl.mu.Lock()
+	defer nl.mu.Unlock()
+	fn()
+	close(nl.changed)
+	nl.changed = make(chan struct{})
+}
+
+// wait calls find with the log locked until it returns a request, or the
+// context ends. The error then lists the requests made so far.
+func (nl *networkLog) wait(ctx context.Context, what string, find func() *request) (*request, error) {
+	for {
+		nl.mu.Lock()
+		if req := find(); req != nil {
+			nl.mu.Unlock()
+			return req, nil
+		}
+		var seen []string
+		for _, req := range nl.requests {
+			seen = append(seen, req.String())
+		}
+		changed := nl.changed
+		nl.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			if len(seen) == 0 {
+				return nil, fmt.Errorf("no %s, no requests were made: %w", what, ctx.Err())
+			}
+			// The latest requests are the ones the step was likely after
+			if len(seen) > 10 {
+				seen = seen[len(seen)-10:]
+			}
+			return nil, fmt.Errorf("no %s, latest requests: %s: %w", what, strings.Join(seen, ", "), ctx.Err())
+		}
+	}
+}
+
+// waitForRequest waits for a request matching target that no earlier
+// wait_for_request matched. Requests made before the step count too, as the
+// step usually follows the click that sends it.
+func (nl *networkLog) waitForRequest(ctx context.Context, target string) error {
+	route := requestMatcher(target)
+	_, err := nl.wait(ctx, "request to "+target, func() *request {
+		for _, req := range nl.requests {
+			if !req.waited && route.Matches(req.method, req.url) {
+				req.waited = true
+				nl.last = req
+				return req
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+// requestBody returns the body of the request matched by the latest
+// wait_for_request, asking the browser for it if the event left it out
+func (nl *networkLog) requestBody(ctx context.Context) (*request, string, error) {
+	nl.mu.Lock()
+	req := nl.last
+	nl.mu.Unlock()
+	if req == nil {
+		return nil, "", fmt.Errorf("no request to check; use wait_for_request first")
+	}
+
+	nl.mu.Lock()
+	body, fetched := req.body, req.bodyFetched || !req.hasBody
+	nl.mu.Unlock()
+	if fetched {
+		return req, body, nil
+	}
+	err := chromedp.Run(ctx, chromedp.ActionFunc(func(ctx context.Context) error {
+		var err error
+		body, err = network.GetRequestPostData(req.id).Do(ctx)
+		return err
+	}))
+	if err != nil {
+		return nil, "", fmt.Errorf("cannot get the body of %s %s: %v", req.method, req.url, err)
+	}
+	nl.mu.Lock()
+	req.body, req.bodyFetched = body, true
+	nl.mu.Unlock()
+	return req, body, nil
+}
+
+// ParseStatusCheck parses an expected response status: a code like 201, or
+// a class like 2xx
+func ParseStatusCheck(status string) (int64, bool, error) {
+	if len(status) == 3 && strings.HasSuffix(strings.ToLower(status), "xx") && status[0] >= '1' && status[0] <= '5' {
+		return int64(status[0]-'0') * 100, true, nil
+	}
+	code, err := strconv.ParseInt(status, 10, 64)
+	if err != nil || code < 100 || code > 599 {
+		return 0, false, fmt.Errorf("invalid status: %s", status)
+	}
+	return code, false, nil
+}
+
+// ParseJSONPath parses a path into a JSON value, like $.items[0].name. The
+// leading $ is optional, and keys with dots or spaces can be written as
+// ["a key"].
+func ParseJSONPath(path string) ([]interface{}, error) {
+	rest := strings.TrimPrefix(strings.TrimSpace(path), "$")
+	var parts []interface{}
+	for rest != "" {
+		switch {
+		case rest[0] == '.':
+			rest = rest[1:]
+			end := strings.IndexAny(rest, ".[")
+			if end < 0 {
+				end = len(rest)
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("invalid JSON path %s: empty key", path)
+			}
+			parts = append(parts, rest[:end])
+			rest = rest[end:]
+		case rest[0] == '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("invalid JSON path %s: missing ]", path)
+			}
+			inner := rest[1:end]
+			if key, err := strconv.Unquote(inner); err == nil && strings.HasPrefix(inner, `"`) {
+				parts = append(parts, key)
+			} else if index, err := strconv.Atoi(inner); err == nil && index >= 0 {
+				parts = append(parts, index)
+			} else {
+				return nil, fmt.Errorf("invalid JSON path %s: bad index [%s]", path, inner)
+			}
+			rest = rest[end+1:]
+		case len(parts) == 0 && path != "" && path[0] != '$':
+			// A path without $ starts with a key, as in items[0].name
+			rest = "." + rest
+		default:
+			return nil, fmt.Errorf("invalid JSON path %s", path)
+		}
+	}
+	return parts, nil
+}
+
+// lookupJSON follows a parsed path into a decoded JSON value
+func lookupJSON(value interface{}, path []interface{}) (interface{}, error) {
+	for i, part := range path {
+		switch part := part.(type) {
+		case string:
+			obj, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s is not an object", formatJSONPath(path[:i]))
+			}
+			if value, ok = obj[part]; !ok {
+				return nil, fmt.Errorf("%s has no key %q", formatJSONPath(path[:i]), part)
+			}
+		case int:
+			arr, ok := value.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s is not an array", formatJSONPath(path[:i]))
+			}
+			if part >= len(arr) {
+				return nil, fmt.Errorf("%s has %d items, no [%d]", formatJSONPath(path[:i]), len(arr), part)
+			}
+			value = arr[part]
+		}
+	}
+	return value, nil
+}
+
+func formatJSONPath(path []interface{}) string {
+	var sb strings.Builder
+	sb.WriteString("$")
+	for _, part := range path {
+		switch part := part.(type) {
+		case string:
+			sb.WriteString("." + part)
+		case int:
+			fmt.Fprintf(&sb, "[%d]", part)
+		}
+	}
+	return sb.String()
+}
+
+// matchJSON checks the value at path in a JSON body against expected, which
+// is compared as JSON when it parses as JSON and as text otherwise, so both
+// 2 and "Milk" and Milk work
+func matchJSON(body, path, expected string) error {
+	parts, err := ParseJSONPath(path)
+	if err != nil {
+		return err
+	}
+	var doc interface{}
+	if err := json.Unmarshal([]byte(body), &doc); err != nil {
+		if len(parts) == 0 {
+			if body == expected {
+				return nil
+			}
+			return fmt.Errorf("expected request body '%s', got '%s'", expected, body)
+		}
+		return fmt.Errorf("request body is not JSON: %s", body)
+	}
+	got, err := lookupJSON(doc, parts)
+	if err != nil {
+		return fmt.Errorf("request body: %v", err)
+	}
+
+	var want interface{}
+	if err := json.Unmarshal([]byte(expected), &want); err == nil && reflect.DeepEqual(got, want) {
+		return nil
+	}
+	if s, ok := got.(string); ok && s == expected {
+		return nil
+	}
+	gotJSON, _ := json.Marshal(got)
+	return fmt.Errorf("expected %s to be %s, got %s", formatJSONPath(parts), expected, gotJSON)
+}
+
+// responseStatus waits for the response to the latest request matching
+// target and checks its status
+func (nl *networkLog) responseStatus(ctx context.Context, target, status string) error {
+	want, class, err := ParseStatusCheck(status)
+	if err != nil {
+		return err
+	}
+	route := requestMatcher(target)
+	req, err := nl.wait(ctx, "response from "+target, func() *request {
+		for i := len(nl.requests) - 1; i >= 0; i-- {
+			req := nl.requests[i]
+			if route.Matches(req.method, req.url) {
+				if req.done() {
+					return req
+				}
+				return nil
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	nl.mu.Lock()
+	got, failed := req.status, req.failed
+	nl.mu.Unlock()
+	if got == 0 {
+		return fmt.Errorf("expected %s %s to get a %s response, it failed: %s", req.method, req.url, status, failed)
+	}
+	if got == want || (class && got/100*100 == want) {
+		return nil
+	}
+	return fmt.Errorf("expected %s %s to get a %s response, got %d", req.method, req.url, status, got)
+}
+
+func (r *Runner) executeNetworkStep(ctx context.Context, step Step, state *testState) error {
+	if state.network == nil {
+		return fmt.Errorf("requests are not being recorded")
+	}
+
+	switch step.Action {
+	case "wait_for_request":
+		return state.network.waitForRequest(ctx, step.Target)
+
+	case "assert_request_body":
+		req, body, err := state.network.requestBody(ctx)
+		if err != nil {
+			return err
+		}
+		if err := matchJSON(body, step.Target, step.Value); err != nil {
+			return fmt.Errorf("%s %s: %v", req.method, req.url, err)
+		}
+		return nil
+
+	case "assert_response_status":
+		return state.network.responseStatus(ctx, step.Target, step.Value)
+	}
+	return fmt.Errorf("unknown action: %s", step.Action)
+}
@@ -0,0 +1,363 @@
+package fasttest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/log"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/cdproto/runtime"
+	"github.com/chromedp/chromedp"
+)
+
+// Types of ConsoleError
+const (
+	ErrorConsole   = "error"     // console.error
+	ErrorWarning   = "warning"   // console.warn
+	ErrorException = "exception" // Uncaught exception
+	ErrorRejection = "rejection" // Unhandled promise rejection
+	ErrorHTTP      = "http"      // Response with a 4xx or 5xx status
+	ErrorNetwork   = "network"   // Request that got no response, like a DNS or CORS failure
+)
+
+// ErrorTypes are all the types of ConsoleError, in the order they are listed
+var ErrorTypes = []string{ErrorConsole, ErrorWarning, ErrorException, ErrorRejection, ErrorHTTP, ErrorNetwork}
+
+// DefaultFailOn are the error types that fail a test when Config.FailOn is
+// empty. Warnings and failed requests are recorded but often come from
+// third-party code, so they only fail tests that ask for it.
+var DefaultFailOn = []string{ErrorConsole, ErrorException, ErrorRejection}
+
+// ParseErrorTypes checks a list of error types, as given to Config.FailOn
+func ParseErrorTypes(types []string) ([]string, error) {
+	var parsed []string
+	for _, typ := range types {
+		typ = strings.TrimSpace(typ)
+		if typ == "" {
+			continue
+		}
+		if !slices.Contains(ErrorTypes, typ) {
+			return nil, fmt.Errorf("unknown error type %q, expected one of %s", typ, strings.Join(ErrorTypes, ", "))
+		}
+		parsed = append(parsed, typ)
+	}
+	return parsed, nil
+}
+
+// ParseErrorPattern compiles a pattern for ignoring or expecting page
+// errors. It is a regular expression searched for in the error's message
+// and URL, so "ResizeObserver loop" and "widget\.example\.com" both work.
+func ParseErrorPattern(pattern string) (*regexp.Regexp, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid error pattern %s: %v", pattern, err)
+	}
+	return re, nil
+}
+
+func matchesError(re *regexp.Regexp, e ConsoleError) bool {
+	return re.MatchString(e.Message) || (e.URL != "" && re.MatchString(e.URL))
+}
+
+// pageErrors collects the errors of a test's page. Browser events add to it
+// from their own goroutine while steps wait on it, so it is guarded by mu.
+type pageErrors struct {
+	mu      sync.Mutex
+	changed chan struct{} // Closed and replaced on every update
+	errors  []ConsoleError
+	// ignore holds the consoleErrorIgnore patterns and those added by
+	// ignore_console_error steps. They apply to the whole test, including
+	// errors recorded before the step.
+	ignore []*regexp.Regexp
+}
+
+func newPageErrors(ignore []*regexp.Regexp) *pageErrors {
+	return &pageErrors{changed: make(chan struct{}), ignore: ignore}
+}
+
+// listen records the page's errors that filter, when set, does not reject
+func (pe *pageErrors) listen(ctx context.Context, filter func(ConsoleError) bool) {
+	add := func(e ConsoleError) {
+		e.Timestamp = time.Now()
+		if filter == nil || !filter(e) {
+			pe.add(e)
+		}
+	}
+
+	chromedp.ListenTarget(ctx, func(ev interface{}) {
+		switch ev := ev.(type) {
+		case *runtime.EventConsoleAPICalled:
+			var typ string
+			switch ev.Type {
+			case runtime.APITypeError:
+				typ = ErrorConsole
+			case runtime.APITypeWarning:
+				typ = ErrorWarning
+			default:
+				return
+			}
+			e := ConsoleError{Type: typ, Message: formatArgs(ev.Args), Stack: formatStack(ev.StackTrace)}
+			e.URL = stackURL(ev.StackTrace)
+			add(e)
+
+		case *runtime.EventExceptionThrown:
+			add(exceptionError(ev.ExceptionDetails))
+
+		case *network.EventResponseReceived:
+			resp := ev.Response
+			if resp.Status < 400 {
+				return
+			}
+			text := resp.StatusText
+			if text == "" {
+				text = http.StatusText(int(resp.Status))
+			}
+			add(ConsoleError{
+				Type:      ErrorHTTP,
+				Message:   strings.TrimSpace(fmt.Sprintf("%d %s", resp.Status, text)),
+				URL:       resp.URL,
+				requestID: ev.RequestID,
+			})
+
+		case *log.EventEntryAdded:
+			entry := ev.Entry
+			if entry.Source != log.SourceNetwork || entry.Level != log.LevelError {
+				return
+			}
+			add(ConsoleError{
+				Type:      ErrorNetwork,
+				Message:   entry.Text,
+				URL:       entry.URL,
+				Stack:     formatStack(entry.StackTrace),
+				requestID: entry.NetworkRequestID,
+			})
+		}
+	})
+}
+
+// add records an error. Chrome also logs "Failed to load resource" for
+// responses with an error status, which would count them twice, so a
+// network log entry for a request with an HTTP error is dropped, whichever
+// event comes first.
+func (pe *pageErrors) add(e ConsoleError) {
+	pe.mu.Lock()
+	defer pe.mu.Unlock()
+	defer pe.notify()
+	if e.requestID != "" {
+		for i, prev := range pe.errors {
+			if prev.requestID != e.requestID {
+				continue
+			}
+			if e.Type == ErrorHTTP && prev.Type == ErrorNetwork {
+				pe.errors[i] = e
+				return
+			}
+			if e.Type == ErrorNetwork && prev.Type == ErrorHTTP {
+				return
+			}
+		}
+	}
+	pe.errors = append(pe.errors, e)
+}
+
+func (pe *pageErrors) notify() {
+	close(pe.changed)
+	pe.changed = make(chan struct{})
+}
+
+func (pe *pageErrors) list() []ConsoleError {
+	pe.mu.Lock()
+	defer pe.mu.Unlock()
+	return append([]ConsoleError{}, pe.errors...)
+}
+
+// exceptionError describes an uncaught exception. Chrome reports unhandled
+// promise rejections as exceptions too, with "Uncaught (in promise)".
+func exceptionError(details *runtime.ExceptionDetails) ConsoleError {
+	e := ConsoleError{Type: ErrorException, Message: details.Text, URL: details.URL}
+	if strings.HasPrefix(details.Text, "Uncaught (in promise)") {
+		e.Type = ErrorRejection
+	}
+	if exc := details.Exception; exc != nil {
+		// An Error's description is its message followed by its stack
+		desc := exc.Description
+		if desc == "" {
+			desc = formatArgs([]*runtime.RemoteObject{exc})
+		}
+		message, stack, _ := strings.Cut(desc, "\n")
+		e.Message = strings.TrimSpace(details.Text + " " + message)
+		e.Stack = stack
+	}
+	if st := formatStack(details.StackTrace); st != "" {
+		e.Stack = st
+	}
+	if e.URL == "" {
+		e.URL = stackURL(details.StackTrace)
+	}
+	return e
+}
+
+// formatArgs joins the arguments of a console call as the console shows
+// them: strings without quotes, other values as JSON or their description
+func formatArgs(args []*runtime.RemoteObject) string {
+	parts := make([]string, 0, len(args))
+	for _, arg := range args {
+		switch {
+		case arg.Value != nil:
+			var s string
+			if err := json.Unmarshal(arg.Value, &s); err == nil {
+				parts = append(parts, s)
+			} else {
+				parts = append(parts, string(arg.Value))
+			}
+		case arg.UnserializableValue != "":
+			parts = append(parts, string(arg.UnserializableValue))
+		case arg.Description != "":
+			parts = append(parts, arg.Description)
+		default:
+			parts = append(parts, string(arg.Type))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// formatStack writes a stack trace like a browser does, one
+// "at function (url:line:column)" frame per line, with 1-based positions
+func formatStack(st *runtime.StackTrace) string {
+	if st == nil {
+		return ""
+	}
+	var lines []string
+	for _, frame := range st.CallFrames {
+		name := frame.FunctionName
+		if name == "" {
+			name = "<anonymous>"
+		}
+		lines = append(lines, fmt.Sprintf("    at %s (%s:%d:%d)", name, frame.URL, frame.LineNumber+1, frame.ColumnNumber+1))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// stackURL is the source of the innermost frame that has one
+func stackURL(st *runtime.StackTrace) string {
+	if st == nil {
+		return ""
+	}
+	for _, frame := range st.CallFrames {
+		if frame.URL != "" {
+			return frame.URL
+		}
+	}
+	return ""
+}
+
+// failing returns the errors whose type is in failOn, or DefaultFailOn when
+// it is empty, leaving out those ignored or expected by a step
+func (pe *pageErrors) failing(failOn []string) []ConsoleError {
+	if len(failOn) == 0 {
+		failOn = DefaultFailOn
+	}
+	pe.mu.Lock()
+	defer pe.mu.Unlock()
+	var failing []ConsoleError
+	for _, e := range pe.errors {
+		if e.expected || !slices.Contains(failOn, e.Type) {
+			continue
+		}
+		ignored := false
+		for _, re := range pe.ignore {
+			ignored = ignored || matchesError(re, e)
+		}
+		if !ignored {
+			failing = append(failing, e)
+		}
+	}
+	return failing
+}
+
+// expect waits for an error matching re that no earlier assert_console_error
+// matched, and marks it expected so it does not fail the test. Errors of any
+// type count, whether or not they would fail the test.
+func (pe *pageErrors) expect(ctx context.Context, re *regexp.Regexp) error {
+	for {
+		pe.mu.Lock()
+		var seen []string
+		for i := range pe.errors {
+			e := &pe.errors[i]
+			if e.expected {
+				continue
+			}
+			if matchesError(re, *e) {
+				e.expected = true
+				pe.mu.Unlock()
+				return nil
+			}
+			seen = append(seen, e.String())
+		}
+		changed := pe.changed
+		pe.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			if len(seen) == 0 {
+				return fmt.Errorf("no page error matching %s, the page reported none: %w", re, ctx.Err())
+			}
+			return fmt.Errorf("no page error matching %s, page errors: %s: %w", re, strings.Join(seen, "; "), ctx.Err())
+		}
+	}
+}
+
+func (r *Runner) executeErrorStep(ctx context.Context, step Step, state *testState) error {
+	if state.errors == nil {
+		return fmt.Errorf("page errors are not being recorded")
+	}
+
+	switch step.Action {
+	case "ignore_console_error":
+		re, err := ParseErrorPattern(step.Target)
+		if err != nil {
+			return err
+		}
+		state.errors.mu.Lock()
+		state.errors.ignore = append(state.errors.ignore, re)
+		state.errors.mu.Unlock()
+		return nil
+
+	case "assert_console_error":
+		re, err := ParseErrorPattern(step.Target)
+		if err != nil {
+			return err
+		}
+		return state.errors.expect(ctx, re)
+
+	case "assert_no_console_errors":
+		failing := state.errors.failing(r.config.FailOn)
+		if len(failing) == 0 {
+			return nil
+		}
+		descs := make([]string, len(failing))
+		for i, e := range failing {
+			descs[i] = e.String()
+		}
+		return fmt.Errorf("expected no page errors, got %d: %s", len(failing), strings.Join(descs, "; "))
+	}
+	return fmt.Errorf("unknown action: %s", step.Action)
+}
+
+// String describes the error on one line, like "exception: Uncaught
+// TypeError: x is undefined (https://example.com/app.js)"
+func (e ConsoleError) String() string {
+	s := e.Type + ": " + e.Message
+	if e.URL != "" {
+		s += " (" + e.URL + ")"
+	}
+	return s
+}
@@ -0,0 +1,398 @@
+package fasttest
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/fetch"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+)
+
+// Route intercepts the requests of a test whose URL matches Pattern. It
+// blocks them, answers them with a mock response, or holds them for Delay.
+type Route struct {
+	// Method limits the route to one HTTP method; empty matches any
+	Method string
+	// Pattern is a glob where * matches any text, or a regular expression
+	// after "regex:", like regex:/api/users/\d+$. A glob starting with / is
+	// matched against the URL path, with or without the query, any other
+	// against the whole URL, with or without the scheme. Regular expressions
+	// may match any part of the URL.
+	Pattern string
+	Block   bool
+	// Delay holds matching requests before they are sent or mocked. Every
+	// matching route's delay applies, the longest wins.
+	Delay    time.Duration
+	Response *MockResponse
+
+	match func(string) bool
+}
+
+// MockResponse answers a request without it reaching the server
+type MockResponse struct {
+	Status      int
+	Body        []byte
+	ContentType string // Guessed from the body when empty
+	Headers     map[string]string
+}
+
+// httpMethods are the words mock, block and delay read as a method
+var httpMethods = map[string]bool{
+	"GET": true, "HEAD": true, "POST": true, "PUT": true, "PATCH": true, "DELETE": true, "OPTIONS": true,
+}
+
+// ParseRoute reads the arguments of a mock, block or delay step into a step.
+// They take an optional method and a pattern, then:
+//
+//	mock [METHOD] pattern [with file PATH | with body TEXT] [status CODE] [type CONTENT-TYPE]
+//	block [METHOD] pattern
+//	delay [METHOD] pattern DURATION
+//
+// The step's Target holds the method and pattern. For mock, Value holds the
+// response as "status CODE [type TYPE] [file PATH | body TEXT]"; for delay,
+// the duration.
+func ParseRoute(action string, args []string) (Step, error) {
+	step := Step{Action: action}
+	target, args, err := ParseRequestPattern(action, args)
+	if err != nil {
+		return step, err
+	}
+	step.Target = target
+
+	switch action {
+	case "block":
+		if len(args) > 0 {
+			return step, fmt.Errorf("too many arguments, expected block [METHOD] pattern")
+		}
+	case "delay":
+		if len(args) != 1 {
+			return step, fmt.Errorf("delay requires a URL pattern and a duration")
+		}
+		if d, err := time.ParseDuration(args[0]); err != nil || d < 0 {
+			return step, fmt.Errorf("invalid delay: %s", args[0])
+		}
+		step.Value = args[0]
+	case "mock":
+		status, contentType, source := "200", "", ""
+		for len(args) > 0 {
+			switch {
+			case args[0] == "status" && len(args) > 1:
+				if n, err := strconv.Atoi(args[1]); err != nil || n < 100 || n > 599 {
+					return step, fmt.Errorf("invalid status: %s", args[1])
+				}
+				status = args[1]
+			case args[0] == "type" && len(args) > 1:
+				// Spaces would end it in the step's Value
+				contentType = strings.ReplaceAll(args[1], " ", "")
+			case args[0] == "with" && len(args) > 2 && (args[1] == "file" || args[1] == "body"):
+				if source != "" {
+					return step, fmt.Errorf("mock can only have one response body")
+				}
+				source = args[1] + " " + args[2]
+				args = args[1:]
+			default:
+				return step, fmt.Errorf("unexpected %q, expected with file PATH, with body TEXT, status CODE or type CONTENT-TYPE", args[0])
+			}
+			args = args[2:]
+		}
+		step.Value = "status " + status
+		if contentType != "" {
+			step.Value += " type " + contentType
+		}
+		if source != "" {
+			step.Value += " " + source
+		}
+	default:
+		return step, fmt.Errorf("unknown action: %s", action)
+	}
+	return step, nil
+}
+
+// ParseRequestPattern reads an optional HTTP method and a URL pattern from
+// the start of args into a step target like "GET /api/*", and returns the
+// arguments after them
+func ParseRequestPattern(action string, args []string) (string, []string, error) {
+	target := ""
+	if len(args) > 0 && httpMethods[args[0]] {
+		target = args[0] + " "
+		args = args[1:]
+	}
+	if len(args) == 0 || args[0] == "" {
+		return "", nil, fmt.Errorf("%s requires a URL pattern", action)
+	}
+	if _, err := compilePattern(args[0]); err != nil {
+		return "", nil, err
+	}
+	return target + args[0], args[1:], nil
+}
+
+// requestMatcher is the route of a step target made by ParseRequestPattern,
+// matching requests without acting on them
+func requestMatcher(target string) Route {
+	route := Route{Pattern: target}
+	if method, pattern, ok := strings.Cut(target, " "); ok && httpMethods[method] {
+		route.Method, route.Pattern = method, pattern
+	}
+	return route
+}
+
+// routeFromStep builds the route of a mock, block or delay step made by
+// ParseRoute. Mock files are read now, relative to the test file.
+func routeFromStep(step Step) (Route, error) {
+	route := requestMatcher(step.Target)
+	switch step.Action {
+	case "block":
+		route.Block = true
+	case "delay":
+		d, err := time.ParseDuration(step.Value)
+		if err != nil {
+			return route, fmt.Errorf("invalid delay: %s", step.Value)
+		}
+		route.Delay = d
+	case "mock":
+		resp := &MockResponse{Status: http.StatusOK}
+		rest := step.Value
+		for rest != "" {
+			var word, arg string
+			word, rest, _ = strings.Cut(rest, " ")
+			if word == "body" || word == "file" {
+				arg, rest = rest, ""
+			} else {
+				arg, rest, _ = strings.Cut(rest, " ")
+			}
+			switch word {
+			case "status":
+				n, err := strconv.Atoi(arg)
+				if err != nil {
+					return route, fmt.Errorf("invalid status: %s", arg)
+				}
+				resp.Status = n
+			case "type":
+				resp.ContentType = arg
+			case "body":
+				resp.Body = []byte(arg)
+			case "file":
+				path := arg
+				if !filepath.IsAbs(path) && step.File != "" {
+					path = filepath.Join(filepath.Dir(step.File), path)
+				}
+				data, err := os.ReadFile(path)
+				if err != nil {
+					return route, fmt.Errorf("cannot read mock response: %v", err)
+				}
+				resp.Body = data
+				if resp.ContentType == "" {
+					resp.ContentType = mime.TypeByExtension(filepath.Ext(path))
+				}
+			}
+		}
+		route.Response = resp
+	}
+	return route, nil
+}
+
+// compilePattern turns a route pattern into a URL matcher
+func compilePattern(pattern string) (func(string) bool, error) {
+	if expr, ok := strings.CutPrefix(pattern, "regex:"); ok {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid URL pattern %s: %v", pattern, err)
+		}
+		return re.MatchString, nil
+	}
+
+	parts := strings.Split(pattern, "*")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	re := regexp.MustCompile("^" + strings.Join(parts, ".*") + "$")
+	if strings.HasPrefix(pattern, "/") {
+		return func(rawURL string) bool {
+			u, err := url.Parse(rawURL)
+			if err != nil {
+				return false
+			}
+			return re.MatchString(u.Path) || re.MatchString(u.RequestURI())
+		}, nil
+	}
+	return func(rawURL string) bool {
+		if re.MatchString(rawURL) {
+			return true
+		}
+		_, rest, ok := strings.Cut(rawURL, "://")
+		return ok && re.MatchString(rest)
+	}, nil
+}
+
+// Matches reports whether the route applies to a request
+func (r *Route) Matches(method, rawURL string) bool {
+	if r.match == nil {
+		match, err := compilePattern(r.Pattern)
+		if err != nil {
+			return false
+		}
+		r.match = match
+	}
+	if r.Method != "" && !strings.EqualFold(r.Method, method) {
+		return false
+	}
+	return r.match(rawURL)
+}
+
+// interceptor pauses the requests of a test's browser with the Fetch domain
+// and applies its routes. Fetch is only enabled once there is a route, so
+// tests without any are not slowed down.
+type interceptor struct {
+	browserCtx context.Context
+	mu         sync.Mutex
+	routes     []*Route // Newest first, so a test can override config routes
+	enabled    bool
+}
+
+func newInterceptor(browserCtx context.Context) *interceptor {
+	return &interceptor{browserCtx: browserCtx}
+}
+
+// add installs a route, enabling interception on the first one
+func (ic *interceptor) add(ctx context.Context, route Route) error {
+	if _, err := compilePattern(route.Pattern); err != nil {
+		return err
+	}
+	ic.mu.Lock()
+	ic.routes = append([]*Route{&route}, ic.routes...)
+	enabled := ic.enabled
+	ic.enabled = true
+	ic.mu.Unlock()
+	if enabled {
+		return nil
+	}
+
+	// The listener lives as long as the browser context, not the test
+	// timeout, so requests made by after_each are not left paused
+	chromedp.ListenTarget(ic.browserCtx, func(ev interface{}) {
+		if ev, ok := ev.(*fetch.EventRequestPaused); ok {
+			go ic.handle(ev)
+		}
+	})
+	return chromedp.Run(ctx, fetch.Enable().WithPatterns([]*fetch.RequestPattern{{URLPattern: "*"}}))
+}
+
+// route finds what to do with a request: the longest delay of the matching
+// routes, and the newest matching route that blocks or mocks
+func (ic *interceptor) route(method, rawURL string) (time.Duration, *Route) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	var delay time.Duration
+	var found *Route
+	for _, route := range ic.routes {
+		if !route.Matches(method, rawURL) {
+			continue
+		}
+		if route.Delay > delay {
+			delay = route.Delay
+		}
+		if found == nil && (route.Block || route.Response != nil) {
+			found = route
+		}
+	}
+	return delay, found
+}
+
+func (ic *interceptor) handle(ev *fetch.EventRequestPaused) {
+	ctx := cdp.WithExecutor(ic.browserCtx, chromedp.FromContext(ic.browserCtx).Target)
+	req := ev.Request
+	rawURL := req.URL + req.URLFragment
+
+	delay, route := ic.route(req.Method, rawURL)
+	// A cross-origin mock needs its preflight answered too, or the browser
+	// never sends the mocked request
+	if route == nil && req.Method == http.MethodOptions {
+		if _, mock := ic.route(headerValue(req.Headers, "Access-Control-Request-Method"), rawURL); mock != nil && mock.Response != nil {
+			fetch.FulfillRequest(ev.RequestID, http.StatusNoContent).
+				WithResponseHeaders(corsHeaders(req.Headers, true)).
+				Do(ctx)
+			return
+		}
+	}
+
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+	}
+
+	switch {
+	case route != nil && route.Block:
+		fetch.FailRequest(ev.RequestID, network.ErrorReasonBlockedByClient).Do(ctx)
+	case route != nil:
+		resp := route.Response
+		headers := []*fetch.HeaderEntry{{Name: "Content-Type", Value: contentType(resp)}}
+		for name, value := range resp.Headers {
+			headers = append(headers, &fetch.HeaderEntry{Name: name, Value: value})
+		}
+		if _, ok := resp.Headers["Access-Control-Allow-Origin"]; !ok {
+			headers = append(headers, corsHeaders(req.Headers, false)...)
+		}
+		fetch.FulfillRequest(ev.RequestID, int64(resp.Status)).
+			WithResponseHeaders(headers).
+			WithBody(base64.StdEncoding.EncodeToString(resp.Body)).
+			Do(ctx)
+	default:
+		fetch.ContinueRequest(ev.RequestID).Do(ctx)
+	}
+}
+
+func contentType(resp *MockResponse) string {
+	if resp.ContentType != "" {
+		return resp.ContentType
+	}
+	body := strings.TrimSpace(string(resp.Body))
+	if strings.HasPrefix(body, "{") || strings.HasPrefix(body, "[") {
+		return "application/json"
+	}
+	return http.DetectContentType(resp.Body)
+}
+
+// corsHeaders lets the page read a mocked response from another origin
+func corsHeaders(reqHeaders network.Headers, preflight bool) []*fetch.HeaderEntry {
+	origin := headerValue(reqHeaders, "Origin")
+	if origin == "" {
+		return nil
+	}
+	headers := []*fetch.HeaderEntry{
+		{Name: "Access-Control-Allow-Origin", Value: origin},
+		{Name: "Access-Control-Allow-Credentials", Value: "true"},
+	}
+	if preflight {
+		headers = append(headers,
+			&fetch.HeaderEntry{Name: "Access-Control-Allow-Methods", Value: headerValue(reqHeaders, "Access-Control-Request-Method")},
+			&fetch.HeaderEntry{Name: "Access-Control-Allow-Headers", Value: headerValue(reqHeaders, "Access-Control-Request-Headers")})
+	}
+	return headers
+}
+
+func headerValue(headers network.Headers, name string) string {
+	for key, value := range headers {
+		if strings.EqualFold(key, name) {
+			if s, ok := value.(string); ok {
+				return s
+			}
+		}
+	}
+	return ""
+}
@@ -1,75 +1,160 @@
 package fasttest
 
 import (
-	"bytes"
 	"context"
+	"errors"
 	"fmt"
-	"image"
-	"image/color"
-	"image/png"
 	"os"
 	"path/filepath"
+	"regexp"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/chromedp/cdproto/cdp"
+	"github.com/chromedp/cdproto/input"
+	"github.com/chromedp/cdproto/network"
 	"github.com/chromedp/cdproto/runtime"
 	"github.com/chromedp/chromedp"
 )
 
 type Runner struct {
-	allocCtx          context.Context
-	allocCancel       context.CancelFunc
-	browserCtx        context.Context
-	browserCancel     context.CancelFunc
+	workers           []*worker
 	config            *Config
-	tests             []Test
+	suites            []Suite
 	results           []TestResult
 	mu                sync.Mutex
-	consoleErrors     []ConsoleError
 	screenshotCounter map[string]int
 	snapshotCounter   map[string]int
-	failureCount      int
-	testsRun          int
+	baselines         BaselineSummary
+}
+
+// worker owns its own Chrome allocator, so restarting the browser after
+// repeated timeouts only affects the tests running on that worker.
+type worker struct {
+	allocCtx     context.Context
+	allocCancel  context.CancelFunc
+	failureCount int
+	testsRun     int
 }
 
 type Config struct {
 	Headless            bool
 	Timeout             time.Duration
 	FailOnConsoleError  bool
+	FailOn              []string // ConsoleError types that fail a test with FailOnConsoleError; empty means DefaultFailOn
+	ConsoleErrorIgnore  []string // Patterns of page errors that never fail a test, see ParseErrorPattern
 	ErrorFilter         func(error ConsoleError) bool
 	ScreenshotDir       string
 	UpdateScreenshots   bool
-	ScreenshotThreshold float64
+	ScreenshotThreshold float64 // Share of pixels, from 0 to 1, that may differ from the baseline
+	PixelThreshold      float64 // Perceived color distance, from 0 to 1, under which two pixels match; 0 means DefaultPixelThreshold
+	ScreenshotMinSSIM   float64 // When set, screenshots also fail if their structural similarity to the baseline is lower
 	SnapshotDir         string
 	UpdateSnapshots     bool
+	NoBaselineUpdates   bool // For CI: only save missing baselines, whatever UpdateScreenshots and UpdateSnapshots say
+	Workers             int // Number of tests run concurrently, each in its own browser
+	StepTimeout         time.Duration            // Default limit for a single step, 0 means only the test timeout applies
+	ActionTimeouts      map[string]time.Duration // Per-action overrides of StepTimeout, keyed by action name
+	ViewportWidth       int
+	ViewportHeight      int
+	Device              string            // Device preset to emulate, e.g. "iPhone 13". Overrides the viewport
+	Vars                map[string]string // Variables for ${NAME} interpolation, checked before the environment
+	Routes              []Route           // Requests to block, mock or delay in every test, before the test's own routes
 }
 
 type Test struct {
-	Name  string
-	Steps []Step
+	Name       string
+	Steps      []Step
+	Setup      []Step // The file's set steps, run before BeforeEach so hooks see its variables
+	BeforeEach []Step // Run in the test's browser before Steps
+	AfterEach  []Step // Run in the test's browser after Steps, even when they fail
+	Tags       []string
+	Skip       bool // Reported as skipped without running
+	Only       bool // When any queued test has Only set, all others are skipped
+}
+
+// Suite groups the tests of one file with the hooks that run once around
+// them. before_all and after_all run in a browser of their own, so they suit
+// setup that lives outside the page, like resetting a backend through a URL.
+type Suite struct {
+	Name      string
+	Setup     []Step // The file's set steps, run before BeforeAll and AfterAll
+	BeforeAll []Step
+	AfterAll  []Step
+	Tests     []Test
 }
 
 type Step struct {
 	Action string
 	Target string
 	Value  string
+	// Where the step was written. Set by the parser, empty for steps built
+	// in Go.
+	File string
+	Line int
+	Text string
+	// Where the procedure the step comes from was called, in the test or
+	// hook, for steps of a procedure body. File and Line then point into the
+	// definition.
+	CallFile string
+	CallLine int
+}
+
+// String describes the step the way it would be written in a test file
+func (s Step) String() string {
+	str := s.Action
+	if s.Target != "" {
+		str += fmt.Sprintf(" %q", s.Target)
+	}
+	if s.Value != "" {
+		str += fmt.Sprintf(" %q", s.Value)
+	}
+	return str
 }
 
 type TestResult struct {
-	Name     string
-	Passed   bool
-	Error    error
-	Duration time.Duration
-	Errors   []ConsoleError
+	Name           string
+	Passed         bool
+	Error          error
+	AfterEachError error // Failure in after_each, kept apart from the test's own Error
+	Hook           string // "before_all" or "after_all" when the result is for a suite hook
+	Skipped        bool   // The test did not run; Passed is false but it is not a failure
+	Duration       time.Duration
+	Errors         []ConsoleError
+}
+
+// Failed reports whether the test ran and did not pass
+func (r TestResult) Failed() bool {
+	return !r.Passed && !r.Skipped
 }
 
+// testState holds what a single test run accumulates while its steps execute
+type testState struct {
+	name     string
+	vars     map[string]string // Set by set and store_text steps
+	mouse    mouseState
+	keyboard keyboardState
+	// downloads is nil unless the test has download steps
+	downloads *downloadState
+	routes    *interceptor
+	network   *networkLog
+	errors    *pageErrors
+}
+
+// ConsoleError is an error the page reported during a test: a console call,
+// an uncaught exception or a failed request
 type ConsoleError struct {
 	Message   string
-	Type      string
+	Type      string // One of ErrorTypes
 	Timestamp time.Time
-	URL       string
+	URL       string // The script that reported it, or the failed request
+	Stack     string // Empty when the browser gave no stack trace
+
+	requestID network.RequestID
+	expected  bool // Matched by an assert_console_error step
 }
 
 func NewRunner(config *Config) *Runner {
@@ -82,6 +167,9 @@ func NewRunner(config *Config) *Runner {
 			ScreenshotThreshold: 0.0,
 			SnapshotDir:         "__snapshots__",
 			UpdateSnapshots:     false,
+			Workers:             1,
+			ViewportWidth:       DefaultViewportWidth,
+			ViewportHeight:      DefaultViewportHeight,
 		}
 	}
 	if config.ScreenshotDir == "" {
@@ -90,6 +178,15 @@ func NewRunner(config *Config) *Runner {
 	if config.SnapshotDir == "" {
 		config.SnapshotDir = "__snapshots__"
 	}
+	if config.Workers < 1 {
+		config.Workers = 1
+	}
+	if config.ViewportWidth <= 0 {
+		config.ViewportWidth = DefaultViewportWidth
+	}
+	if config.ViewportHeight <= 0 {
+		config.ViewportHeight = DefaultViewportHeight
+	}
 	return &Runner{
 		config:            config,
 		screenshotCounter: make(map[string]int),
@@ -98,6 +195,37 @@ func NewRunner(config *Config) *Runner {
 }
 
 func (r *Runner) Start() error {
+	r.workers = make([]*worker, r.config.Workers)
+	for i := range r.workers {
+		r.workers[i] = &worker{}
+		r.startWorker(r.workers[i])
+	}
+
+	// Verify every worker's Chrome is working, in parallel. If any is not,
+	// all of them are shut down rather than left running.
+	errs := make([]error, len(r.workers))
+	var wg sync.WaitGroup
+	for i, w := range r.workers {
+		wg.Add(1)
+		go func(i int, w *worker) {
+			defer wg.Done()
+			errs[i] = r.healthCheck(w)
+		}(i, w)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			r.Stop()
+			r.workers = nil
+			return fmt.Errorf("worker %d: %v", i+1, err)
+		}
+	}
+
+	return nil
+}
+
+// startWorker creates a fresh Chrome allocator for the worker
+func (r *Runner) startWorker(w *worker) {
 	opts := append(chromedp.DefaultExecAllocatorOptions[:],
 		chromedp.Flag("headless", r.config.Headless),
 		chromedp.Flag("disable-gpu", r.config.Headless),
@@ -123,29 +251,24 @@ func (r *Runner) Start() error {
 		chromedp.Flag("password-store", "basic"),
 		chromedp.Flag("use-mock-keychain", true),
 		chromedp.Flag("log-level", "3"), // Suppress verbose logs
+		// Keep rendering identical across machines for screenshot baselines
+		chromedp.WindowSize(r.config.ViewportWidth, r.config.ViewportHeight),
+		chromedp.Flag("force-device-scale-factor", "1"),
+		chromedp.Flag("font-render-hinting", "none"),
 	)
 
 	// Create allocator with suppressed debug output
-	allocCtx, cancel := chromedp.NewExecAllocator(context.Background(), opts...)
-	r.allocCtx = allocCtx
-	r.allocCancel = cancel
-	
-	// Verify Chrome is working by running a health check
-	if err := r.healthCheck(); err != nil {
-		return fmt.Errorf("Chrome health check failed: %v", err)
-	}
-
-	return nil
+	w.allocCtx, w.allocCancel = chromedp.NewExecAllocator(context.Background(), opts...)
 }
 
 // healthCheck verifies Chrome is responsive
-func (r *Runner) healthCheck() error {
-	if r.allocCtx == nil {
+func (r *Runner) healthCheck(w *worker) error {
+	if w.allocCtx == nil {
 		return fmt.Errorf("Chrome not initialized")
 	}
 	
 	// Create a test context to verify Chrome is working
-	ctx, cancel := chromedp.NewContext(r.allocCtx)
+	ctx, cancel := chromedp.NewContext(w.allocCtx)
 	defer cancel()
 	
 	// Apply a short timeout for health check
@@ -174,13 +297,27 @@ func (r *Runner) cleanBrowserState(ctx context.Context) error {
 }
 
 func (r *Runner) Stop() error {
-	if r.allocCancel != nil {
+	var wg sync.WaitGroup
+	for _, w := range r.workers {
+		wg.Add(1)
+		go func(w *worker) {
+			defer wg.Done()
+			r.stopWorker(w)
+		}(w)
+	}
+	wg.Wait()
+	return nil
+}
+
+// stopWorker shuts down the worker's Chrome process
+func (r *Runner) stopWorker(w *worker) {
+	if w.allocCancel != nil {
 		// Create a channel to signal when cleanup is done
 		done := make(chan struct{})
 		
 		// Run cancellation in a goroutine
 		go func() {
-			r.allocCancel()
+			w.allocCancel()
 			close(done)
 		}()
 		
@@ -196,97 +333,288 @@ func (r *Runner) Stop() error {
 		// Give Chrome a moment to fully terminate
 		time.Sleep(100 * time.Millisecond)
 	}
-	return nil
 }
 
-// restartChrome stops and restarts the Chrome process
-func (r *Runner) restartChrome() error {
+// restartChrome stops and restarts the worker's Chrome process
+func (r *Runner) restartChrome(w *worker) error {
 	// Stop existing Chrome
-	if err := r.Stop(); err != nil {
-		return fmt.Errorf("failed to stop Chrome: %v", err)
-	}
+	r.stopWorker(w)
 	
 	// Clear all state
-	r.allocCtx = nil
-	r.allocCancel = nil
+	w.allocCtx = nil
+	w.allocCancel = nil
 	
 	// Wait a bit to ensure Chrome is fully terminated
 	time.Sleep(1 * time.Second)
 	
 	// Start Chrome again
-	if err := r.Start(); err != nil {
+	r.startWorker(w)
+	if err := r.healthCheck(w); err != nil {
 		return fmt.Errorf("failed to restart Chrome: %v", err)
 	}
 	
 	// Reset failure count after restart
-	r.failureCount = 0
+	w.failureCount = 0
 	
 	return nil
 }
 
 func (r *Runner) AddTest(test Test) {
-	r.tests = append(r.tests, test)
+	r.suites = append(r.suites, Suite{Tests: []Test{test}})
+}
+
+// AddSuite queues the suite's tests along with its before_all and after_all hooks
+func (r *Runner) AddSuite(suite Suite) {
+	r.suites = append(r.suites, suite)
 }
 
 func (r *Runner) Run() []TestResult {
-	r.results = make([]TestResult, 0, len(r.tests))
-
-	// Run tests sequentially with Chrome restart when needed
-	for i, test := range r.tests {
-		// Check if we need to restart Chrome
-		// Restart after 2 consecutive timeouts, or every 10 tests to prevent context degradation
-		if r.failureCount >= 2 || (r.testsRun > 0 && r.testsRun % 10 == 0) {
-			if err := r.restartChrome(); err != nil {
-				// If restart fails, record error for all remaining tests
-				for j := i; j < len(r.tests); j++ {
-					r.results = append(r.results, TestResult{
-						Name:     r.tests[j].Name,
-						Passed:   false,
-						Error:    fmt.Errorf("Chrome restart failed: %v", err),
-						Duration: 0,
-					})
-				}
-				return r.results
+	return r.run(nil)
+}
+
+// job is a unit of work for a worker: a test, or a suite's before_all or
+// after_all hook
+type job struct {
+	test  Test
+	hook  string
+	skip  bool
+	suite *suiteState
+}
+
+// suiteState lets workers coordinate the hooks of a suite. Jobs are queued
+// before_all first and after_all last, so whatever a job waits on has always
+// been picked up by a worker already.
+type suiteState struct {
+	beforeAllDone chan struct{} // Closed once before_all has finished
+	beforeAllErr  error
+	testsDone     sync.WaitGroup // after_all waits for the suite's tests
+}
+
+// jobs flattens the queued suites into jobs in run order
+func (r *Runner) jobs() []job {
+	only := false
+	for _, suite := range r.suites {
+		for _, test := range suite.Tests {
+			only = only || test.Only
+		}
+	}
+
+	var jobs []job
+	for _, suite := range r.suites {
+		state := &suiteState{beforeAllDone: make(chan struct{})}
+		var tests []job
+		runnable := 0
+		for _, test := range suite.Tests {
+			skip := test.Skip || (only && !test.Only)
+			if !skip {
+				runnable++
 			}
+			tests = append(tests, job{test: test, skip: skip, suite: state})
 		}
-		
-		// Run test with retry on timeout
-		result := r.runTestWithRetry(test)
-		r.results = append(r.results, result)
-		r.testsRun++
-		
-		// Small delay between tests to prevent resource exhaustion
-		if i < len(r.tests)-1 {
-			time.Sleep(200 * time.Millisecond)
+		// Hooks are pointless when none of the suite's tests will run
+		if runnable == 0 {
+			suite.BeforeAll, suite.AfterAll = nil, nil
 		}
-		
-		// Track consecutive failures
-		if !result.Passed && strings.Contains(result.Error.Error(), "context deadline exceeded") {
-			r.failureCount++
-		} else if result.Passed {
-			r.failureCount = 0 // Reset on success
+
+		if len(suite.BeforeAll) > 0 {
+			jobs = append(jobs, job{
+				test:  Test{Name: hookName("before_all", suite.Name), Setup: suite.Setup, Steps: suite.BeforeAll},
+				hook:  "before_all",
+				suite: state,
+			})
+		} else {
+			close(state.beforeAllDone)
+		}
+		state.testsDone.Add(len(tests))
+		jobs = append(jobs, tests...)
+		if len(suite.AfterAll) > 0 {
+			jobs = append(jobs, job{
+				test:  Test{Name: hookName("after_all", suite.Name), Setup: suite.Setup, Steps: suite.AfterAll},
+				hook:  "after_all",
+				suite: state,
+			})
+		}
+	}
+	return jobs
+}
+
+func hookName(hook, suite string) string {
+	if suite == "" {
+		return hook
+	}
+	return fmt.Sprintf("%s (%s)", hook, suite)
+}
+
+// runJob runs a test or hook, waiting for before_all first and holding
+// after_all back until every test of the suite is done
+func (r *Runner) runJob(w *worker, j job) TestResult {
+	switch j.hook {
+	case "before_all":
+		result := r.runTestWithRetry(w, j.test)
+		result.Hook = j.hook
+		if !result.Passed {
+			j.suite.beforeAllErr = result.Error
+		}
+		close(j.suite.beforeAllDone)
+		return result
+	case "after_all":
+		j.suite.testsDone.Wait()
+		result := r.runTestWithRetry(w, j.test)
+		result.Hook = j.hook
+		return result
+	}
+
+	defer j.suite.testsDone.Done()
+	if j.skip {
+		return TestResult{
+			Name:    j.test.Name,
+			Skipped: true,
+		}
+	}
+	<-j.suite.beforeAllDone
+	if err := j.suite.beforeAllErr; err != nil {
+		return TestResult{
+			Name:   j.test.Name,
+			Passed: false,
+			Error:  fmt.Errorf("before_all failed: %v", err),
+		}
+	}
+	return r.runTestWithRetry(w, j.test)
+}
+
+// abandon releases whoever waits on a job that will never run
+func (j job) abandon(err error) {
+	switch j.hook {
+	case "before_all":
+		j.suite.beforeAllErr = err
+		close(j.suite.beforeAllDone)
+	case "after_all":
+	default:
+		j.suite.testsDone.Done()
+	}
+}
+
+// run spreads the queued tests across the workers and collects the results
+// in the order the tests were added. If emit is non-nil it is called with
+// each result, also in order, as soon as that result and every result before
+// it are available.
+func (r *Runner) run(emit func(TestResult)) []TestResult {
+	all := r.jobs()
+	results := make([]TestResult, len(all))
+	ready := make([]chan struct{}, len(all))
+	jobs := make(chan int, len(all))
+	for i := range all {
+		ready[i] = make(chan struct{})
+		jobs <- i
+	}
+	close(jobs)
+
+	// fail records err for a job that will not run
+	fail := func(i int, err error) {
+		all[i].abandon(err)
+		if all[i].skip {
+			results[i] = TestResult{Name: all[i].test.Name, Skipped: true}
+		} else {
+			results[i] = TestResult{
+				Name:     all[i].test.Name,
+				Passed:   false,
+				Error:    err,
+				Hook:     all[i].hook,
+				Duration: 0,
+			}
+		}
+		close(ready[i])
+	}
+
+	// failRemaining records err for every job nobody will pick up anymore
+	failRemaining := func(err error) {
+		for i := range jobs {
+			fail(i, err)
+		}
+	}
+
+	if len(r.workers) == 0 {
+		failRemaining(fmt.Errorf("browser not started"))
+	}
+
+	alive := int32(len(r.workers))
+	for _, w := range r.workers {
+		go func(w *worker) {
+			for i := range jobs {
+				// Check if we need to restart Chrome
+				// Restart after 2 consecutive timeouts, or every 10 tests to prevent context degradation
+				needsRestart := w.failureCount >= 2 || (w.testsRun > 0 && w.testsRun%10 == 0)
+				if needsRestart && !all[i].skip {
+					if err := r.restartChrome(w); err != nil {
+						// This worker is gone. Other workers keep draining the
+						// queue; the last one to go fails whatever is left.
+						err = fmt.Errorf("Chrome restart failed: %v", err)
+						fail(i, err)
+						if atomic.AddInt32(&alive, -1) == 0 {
+							failRemaining(err)
+						}
+						return
+					}
+				}
+
+				// Run test with retry on timeout
+				result := r.runJob(w, all[i])
+				results[i] = result
+				close(ready[i])
+				if result.Skipped {
+					continue
+				}
+				w.testsRun++
+
+				// Track consecutive failures
+				if !result.Passed && isTimeout(result.Error) {
+					w.failureCount++
+				} else if result.Passed {
+					w.failureCount = 0 // Reset on success
+				}
+			}
+		}(w)
+	}
+
+	r.results = make([]TestResult, 0, len(all))
+	for i := range all {
+		<-ready[i]
+		r.results = append(r.results, results[i])
+		if emit != nil {
+			emit(results[i])
 		}
 	}
 
 	return r.results
 }
 
+// isTimeout reports whether err comes from a deadline or a cancelled browser.
+// A step that ran out of its own step timeout is not one: the browser is
+// fine, the page just never got there, and a retry would only wait again.
+func isTimeout(err error) bool {
+	var stepErr *StepError
+	if errors.As(err, &stepErr) && stepErr.Timeout > 0 && !stepErr.TestTimeout {
+		return false
+	}
+	return errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled)
+}
+
 // runTestWithRetry runs a test with retry logic for timeout errors
-func (r *Runner) runTestWithRetry(test Test) TestResult {
+func (r *Runner) runTestWithRetry(w *worker, test Test) TestResult {
 	maxRetries := 2
 	
 	for attempt := 1; attempt <= maxRetries; attempt++ {
-		result := r.runTest(test)
+		result := r.runTest(w, test)
 		
 		// If test passed or failed for non-timeout reason, return
-		if result.Passed || (result.Error != nil && !strings.Contains(result.Error.Error(), "context deadline exceeded") && !strings.Contains(result.Error.Error(), "context canceled")) {
+		if result.Passed || !isTimeout(result.Error) {
 			return result
 		}
 		
 		// If this was a timeout/cancel and not the last attempt, restart Chrome and retry
 		if attempt < maxRetries {
 			// Always restart Chrome on timeout
-			if err := r.restartChrome(); err != nil {
+			if err := r.restartChrome(w); err != nil {
 				// If restart failed, return the original error
 				return result
 			}
@@ -295,10 +623,10 @@ func (r *Runner) runTestWithRetry(test Test) TestResult {
 	}
 	
 	// If we're here, all retries failed
-	return r.runTest(test)
+	return r.runTest(w, test)
 }
 
-func (r *Runner) runTest(test Test) TestResult {
+func (r *Runner) runTest(w *worker, test Test) TestResult {
 	start := time.Now()
 	result := TestResult{
 		Name:   test.Name,
@@ -307,31 +635,28 @@ func (r *Runner) runTest(test Test) TestResult {
 	}
 
 	// Check if browser has been started
-	if r.allocCtx == nil {
+	if w.allocCtx == nil {
 		result.Passed = false
 		result.Error = fmt.Errorf("browser not started")
 		return result
 	}
 
 	// Create a new browser context for this test
-	ctx, cancel := chromedp.NewContext(r.allocCtx)
+	browserCtx, cancel := chromedp.NewContext(w.allocCtx)
 	defer cancel()
 	
-	// Apply timeout from config
-	ctx, cancel = context.WithTimeout(ctx, r.config.Timeout)
-	defer cancel()
-	
-	// Run the context to ensure it's properly initialized
-	if err := chromedp.Run(ctx); err != nil {
+	// Run the context to ensure it's properly initialized. The first Run
+	// allocates the browser, so it must not carry the test timeout or the
+	// browser would be gone before after_each gets to run.
+	if err := chromedp.Run(browserCtx); err != nil {
 		result.Passed = false
 		result.Error = fmt.Errorf("failed to initialize Chrome context: %v", err)
 		return result
 	}
-	
-	// Clear any previous console errors
-	r.mu.Lock()
-	r.consoleErrors = []ConsoleError{}
-	r.mu.Unlock()
+
+	// Apply timeout from config
+	ctx, cancel := context.WithTimeout(browserCtx, r.config.Timeout)
+	defer cancel()
 	
 	// Initialize browser with about:blank
 	err := chromedp.Run(ctx, chromedp.Navigate("about:blank"))
@@ -342,47 +667,90 @@ func (r *Runner) runTest(test Test) TestResult {
 	}
 
 
-	// Set up console listener
-	chromedp.ListenTarget(ctx, func(ev interface{}) {
-		switch ev := ev.(type) {
-		case *runtime.EventConsoleAPICalled:
-			if ev.Type == runtime.APITypeError {
-				var message string
-				if len(ev.Args) > 0 && ev.Args[0].Value != nil {
-					message = string(ev.Args[0].Value)
-				}
+	// Apply the configured viewport or device before any step runs
+	emulate, err := r.emulation()
+	if err != nil {
+		result.Passed = false
+		result.Error = err
+		return result
+	}
+	if err := chromedp.Run(ctx, emulate); err != nil {
+		result.Passed = false
+		result.Error = fmt.Errorf("failed to set up emulation: %v", err)
+		return result
+	}
 
-				consoleErr := ConsoleError{
-					Message:   message,
-					Type:      string(ev.Type),
-					Timestamp: time.Now(),
-				}
+	state := &testState{
+		name: test.Name,
+		vars: make(map[string]string),
+	}
 
-				// Skip URL retrieval to avoid potential deadlocks
-				consoleErr.URL = ""
+	// Errors are recorded for as long as the browser lives, so after_each
+	// is covered too
+	var ignore []*regexp.Regexp
+	for _, pattern := range r.config.ConsoleErrorIgnore {
+		re, err := ParseErrorPattern(pattern)
+		if err != nil {
+			result.Passed = false
+			result.Error = err
+			return result
+		}
+		ignore = append(ignore, re)
+	}
+	state.errors = newPageErrors(ignore)
+	state.errors.listen(browserCtx, r.config.ErrorFilter)
 
-				if r.config.ErrorFilter == nil || !r.config.ErrorFilter(consoleErr) {
-					r.mu.Lock()
-					result.Errors = append(result.Errors, consoleErr)
-					r.mu.Unlock()
-				}
-			}
+	state.network = newNetworkLog()
+	state.network.listen(browserCtx)
+
+	state.routes = newInterceptor(browserCtx)
+	for _, route := range r.config.Routes {
+		if err := state.routes.add(ctx, route); err != nil {
+			result.Passed = false
+			result.Error = fmt.Errorf("failed to set up route %s: %v", route.Pattern, err)
+			return result
 		}
-	})
+	}
 
-	// Run steps
-	for _, step := range test.Steps {
-		if err := r.executeStep(ctx, step, test.Name); err != nil {
+	if usesDownloads(test) {
+		downloads, cleanup, err := setupDownloads(ctx)
+		if err != nil {
 			result.Passed = false
 			result.Error = err
-			break
+			return result
 		}
+		defer cleanup()
+		state.downloads = downloads
 	}
 
-	if r.config.FailOnConsoleError && len(result.Errors) > 0 {
+	// Run steps, starting with the file's variables and before_each
+	if err := r.runSteps(ctx, test.Setup, state); err != nil {
+		result.Passed = false
+		result.Error = err
+	} else if err := r.runSteps(ctx, test.BeforeEach, state); err != nil {
+		result.Passed = false
+		result.Error = fmt.Errorf("before_each: %w", err)
+	} else if err := r.runSteps(ctx, test.Steps, state); err != nil {
+		result.Passed = false
+		result.Error = err
+	}
+
+	// after_each always runs, with a deadline of its own in case the test
+	// used up its timeout
+	if len(test.AfterEach) > 0 {
+		afterCtx, afterCancel := context.WithTimeout(browserCtx, r.config.Timeout)
+		defer afterCancel()
+		if err := r.runSteps(afterCtx, test.AfterEach, state); err != nil {
+			result.Passed = false
+			result.AfterEachError = fmt.Errorf("after_each: %w", err)
+		}
+	}
+
+	result.Errors = state.errors.list()
+	if failing := state.errors.failing(r.config.FailOn); r.config.FailOnConsoleError && len(failing) > 0 {
 		result.Passed = false
 		if result.Error == nil {
-			result.Error = fmt.Errorf("console errors detected: %d errors", len(result.Errors))
+			result.Error = fmt.Errorf("console errors detected: %d errors, first: %s", len(failing), failing[0])
 		}
 	}
 
@@ -391,7 +759,64 @@ func (r *Runner) runTest(test Test) TestResult {
 	return result
 }
 
-func (r *Runner) executeStep(ctx context.Context, step Step, testName string) error {
+// runSteps runs steps in order and stops at the first failure
+func (r *Runner) runSteps(ctx context.Context, steps []Step, state *testState) error {
+	for i, step := range steps {
+		if err := r.runStep(ctx, i, step, state); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stepTimeout returns how long a step may take, or 0 if it is only bounded
+// by the test timeout
+func (r *Runner) stepTimeout(action string) time.Duration {
+	if timeout, ok := r.config.ActionTimeouts[action]; ok && timeout > 0 {
+		return timeout
+	}
+	return r.config.StepTimeout
+}
+
+// runStep executes a step in its own context, so a hung action fails after
+// its own timeout with an error naming the step instead of silently eating
+// the rest of the test deadline
+func (r *Runner) runStep(ctx context.Context, index int, step Step, state *testState) error {
+	step, err := r.interpolateStep(step, state)
+	if err == nil {
+		err = CheckStep(step)
+	}
+	if err != nil {
+		return &StepError{Index: index + 1, Step: step, Err: err}
+	}
+
+	stepCtx := ctx
+	timeout := r.stepTimeout(step.Action)
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		stepCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	err = r.executeStep(stepCtx, step, state)
+	if err == nil {
+		return nil
+	}
+
+	stepErr := &StepError{Index: index + 1, Step: step, Elapsed: time.Since(start), Err: err}
+	if stepCtx.Err() == context.DeadlineExceeded {
+		if ctx.Err() == nil {
+			stepErr.Timeout = timeout
+		} else {
+			stepErr.Timeout = r.config.Timeout
+			stepErr.TestTimeout = true
+		}
+	}
+	return stepErr
+}
+
+func (r *Runner) executeStep(ctx context.Context, step Step, state *testState) error {
 	// Check if context is already cancelled before executing step
 	select {
 	case <-ctx.Done():
@@ -409,6 +834,43 @@ func (r *Runner) executeStep(ctx context.Context, step Step, testName string) er
 	case "type":
 		return chromedp.Run(ctx, chromedp.SendKeys(step.Target, step.Value, chromedp.NodeVisible))
 
+	case "clear":
+		return clearElement(ctx, step.Target)
+
+	case "press":
+		return pressKeys(ctx, state, step.Target)
+
+	case "key_down":
+		return keyDown(ctx, state, step.Target)
+
+	case "key_up":
+		return keyUp(ctx, state, step.Target)
+
+	case "type_focused":
+		return typeFocused(ctx, state, step.Value)
+
+	case "mock", "block", "delay":
+		if state.routes == nil {
+			return fmt.Errorf("request interception is not available")
+		}
+		route, err := routeFromStep(step)
+		if err != nil {
+			return err
+		}
+		return state.routes.add(ctx, route)
+
+	case "upload":
+		return uploadFile(ctx, step)
+
+	case "expect_download", "assert_download_size", "assert_download_contains":
+		return r.executeDownloadStep(ctx, step, state)
+
+	case "wait_for_request", "assert_request_body", "assert_response_status":
+		return r.executeNetworkStep(ctx, step, state)
+
+	case "ignore_console_error", "assert_console_error", "assert_no_console_errors":
+		return r.executeErrorStep(ctx, step, state)
+
 	case "wait_for":
 		// Use a more robust wait with polling
 		return chromedp.Run(ctx, 
@@ -505,10 +967,10 @@ func (r *Runner) executeStep(ctx context.Context, step Step, testName string) er
 			return fmt.Errorf("context cancelled before screenshot: %v", ctx.Err())
 		default:
 		}
-		return r.takeScreenshot(ctx, step.Target, testName)
+		return r.takeScreenshot(ctx, step.Target, state.name)
 
 	case "snapshot":
-		return r.takeSnapshot(ctx, step.Target, testName)
+		return r.takeSnapshot(ctx, step.Target, state.name)
 
 	case "wait_for_text":
 		// First wait for element to be visible
@@ -556,31 +1018,80 @@ func (r *Runner) executeStep(ctx context.Context, step Step, testName string) er
 		return fmt.Errorf("timeout waiting for URL to contain '%s'", step.Target)
 
 	case "select":
-		// First click to open dropdown
-		if err := chromedp.Run(ctx, chromedp.Click(step.Target, chromedp.NodeVisible)); err != nil {
-			return err
-		}
-		// Then select the option
-		return chromedp.Run(ctx, chromedp.SetValue(step.Target, step.Value))
+		return selectOption(ctx, step.Target, step.Value)
 
 	case "check":
-		// Click checkbox to check it
-		return chromedp.Run(ctx, chromedp.Click(step.Target, chromedp.NodeVisible))
+		return setChecked(ctx, step.Target, true)
 
 	case "uncheck":
-		// Click checkbox to uncheck it
-		return chromedp.Run(ctx, chromedp.Click(step.Target, chromedp.NodeVisible))
+		return setChecked(ctx, step.Target, false)
 
 	case "hover":
-		// Move mouse over element
-		var nodes []*cdp.Node
-		if err := chromedp.Run(ctx, chromedp.Nodes(step.Target, &nodes)); err != nil {
+		// Move the mouse over the element without pressing a button, so
+		// menus open but nothing is clicked
+		x, y, err := elementCenter(ctx, step.Target)
+		if err != nil {
 			return err
 		}
-		if len(nodes) == 0 {
-			return fmt.Errorf("element not found: %s", step.Target)
+		return mouseMove(ctx, state, x, y)
+
+	case "mouse_move":
+		x, err := strconv.ParseFloat(step.Target, 64)
+		if err != nil {
+			return fmt.Errorf("invalid x coordinate: %s", step.Target)
+		}
+		y, err := strconv.ParseFloat(step.Value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid y coordinate: %s", step.Value)
+		}
+		return mouseMove(ctx, state, x, y)
+
+	case "mouse_down", "mouse_up":
+		button, err := LookupMouseButton(step.Target)
+		if err != nil {
+			return err
+		}
+		typ := input.MousePressed
+		if step.Action == "mouse_up" {
+			typ = input.MouseReleased
+		}
+		return mouseButton(ctx, state, typ, button, 1)
+
+	case "double_click":
+		return clickElement(ctx, state, step.Target, input.Left, 2)
+
+	case "right_click":
+		return clickElement(ctx, state, step.Target, input.Right, 1)
+
+	case "set":
+		r.setVar(state, step.Target, step.Value)
+		return nil
+
+	case "store_text":
+		var text string
+		if err := chromedp.Run(ctx, chromedp.Text(step.Target, &text, chromedp.NodeVisible)); err != nil {
+			return err
+		}
+		r.setVar(state, step.Value, strings.TrimSpace(text))
+		return nil
+
+	case "viewport":
+		width, err := strconv.ParseInt(step.Target, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid viewport width: %s", step.Target)
+		}
+		height, err := strconv.ParseInt(step.Value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid viewport height: %s", step.Value)
 		}
-		return chromedp.Run(ctx, chromedp.MouseClickNode(nodes[0]))
+		return chromedp.Run(ctx, emulateViewport(width, height))
+
+	case "device":
+		info, err := LookupDevice(step.Target)
+		if err != nil {
+			return err
+		}
+		return chromedp.Run(ctx, chromedp.Emulate(info))
 
 	case "assert_text_visible":
 		// First wait for the body element to be ready
@@ -663,155 +1174,13 @@ func (r *Runner) takeScreenshot(ctx context.Context, filename string, testName s
 	}
 
 	path := filepath.Join(r.config.ScreenshotDir, filename)
-
-	// Check if screenshot already exists
-	if _, err := os.Stat(path); err == nil {
-		// Screenshot exists, load and compare
-		baselineData, err := os.ReadFile(path)
+	return r.checkBaseline("screenshot", path, screenshot, r.config.UpdateScreenshots, func(baseline []byte) (string, []byte, error) {
+		cmp, err := r.compareImages(baseline, screenshot)
 		if err != nil {
-			return fmt.Errorf("failed to read existing screenshot: %v", err)
-		}
-
-		// Compare screenshots
-		diff, diffImage, err := r.compareImages(baselineData, screenshot)
-		if err != nil {
-			return fmt.Errorf("failed to compare screenshots: %v", err)
-		}
-
-		if diff > r.config.ScreenshotThreshold {
-			// Save the actual screenshot for reference
-			actualPath := strings.TrimSuffix(path, ".png") + ".actual.png"
-			os.WriteFile(actualPath, screenshot, 0644)
-
-			// Save diff image showing the differences
-			if diffImage != nil {
-				diffPath := strings.TrimSuffix(path, ".png") + ".diff.png"
-				os.WriteFile(diffPath, diffImage, 0644)
-			}
-
-			return fmt.Errorf("screenshot differs from baseline by %.2f%% (threshold: %.2f%%). Delete the old screenshot at %s to save the new one", diff*100, r.config.ScreenshotThreshold*100, path)
-		}
-
-		// Screenshots match, no need to save
-		return nil
-	}
-
-	// Screenshot doesn't exist, save it
-	err = os.WriteFile(path, screenshot, 0644)
-	if err != nil {
-		return fmt.Errorf("failed to save screenshot: %v", err)
-	}
-
-	return nil
-}
-
-func (r *Runner) compareImages(baseline, current []byte) (float64, []byte, error) {
-	baselineImg, err := png.Decode(bytes.NewReader(baseline))
-	if err != nil {
-		return 0, nil, err
-	}
-
-	currentImg, err := png.Decode(bytes.NewReader(current))
-	if err != nil {
-		return 0, nil, err
-	}
-
-	// Quick check for exact match first
-	if bytes.Equal(baseline, current) {
-		return 0, nil, nil
-	}
-
-	bounds := baselineImg.Bounds()
-	if bounds != currentImg.Bounds() {
-		return 1.0, nil, nil // 100% different if sizes don't match
-	}
-
-	totalPixels := bounds.Dx() * bounds.Dy()
-	differentPixels := 0
-
-	// Create diff image only if needed
-	var diffImg *image.RGBA
-	var needsDiff bool
-
-	// Sample comparison first - check every 10th pixel for quick estimation
-	sampleStep := 10
-	for y := bounds.Min.Y; y < bounds.Max.Y; y += sampleStep {
-		for x := bounds.Min.X; x < bounds.Max.X; x += sampleStep {
-			c1 := baselineImg.At(x, y)
-			c2 := currentImg.At(x, y)
-			if !colorsEqual(c1, c2) {
-				needsDiff = true
-				break
-			}
+			return "", nil, err
 		}
-		if needsDiff {
-			break
-		}
-	}
-
-	// Only do full comparison if sample shows differences
-	if !needsDiff {
-		return 0, nil, nil
-	}
-
-	diffImg = image.NewRGBA(bounds)
-
-	// Parallel processing for large images
-	numWorkers := 4
-	rowsPerWorker := bounds.Dy() / numWorkers
-	var wg sync.WaitGroup
-	var mu sync.Mutex
-
-	for w := 0; w < numWorkers; w++ {
-		wg.Add(1)
-		startY := bounds.Min.Y + w*rowsPerWorker
-		endY := startY + rowsPerWorker
-		if w == numWorkers-1 {
-			endY = bounds.Max.Y
-		}
-
-		go func(startY, endY int) {
-			defer wg.Done()
-			localDiff := 0
-
-			for y := startY; y < endY; y++ {
-				for x := bounds.Min.X; x < bounds.Max.X; x++ {
-					c1 := baselineImg.At(x, y)
-					c2 := currentImg.At(x, y)
-					if !colorsEqual(c1, c2) {
-						localDiff++
-						// Highlight differences in red
-						diffImg.Set(x, y, color.RGBA{255, 0, 0, 255})
-					} else {
-						// Show matching pixels as grayscale from baseline
-						r1, g1, b1, _ := c1.RGBA()
-						gray := uint8((r1 + g1 + b1) / 3 / 256)
-						diffImg.Set(x, y, color.RGBA{gray, gray, gray, 128})
-					}
-				}
-			}
-
-			mu.Lock()
-			differentPixels += localDiff
-			mu.Unlock()
-		}(startY, endY)
-	}
-
-	wg.Wait()
-
-	// Encode diff image
-	var diffBuf bytes.Buffer
-	if err := png.Encode(&diffBuf, diffImg); err != nil {
-		return 0, nil, err
-	}
-
-	return float64(differentPixels) / float64(totalPixels), diffBuf.Bytes(), nil
-}
-
-func colorsEqual(c1, c2 color.Color) bool {
-	r1, g1, b1, a1 := c1.RGBA()
-	r2, g2, b2, a2 := c2.RGBA()
-	return r1 == r2 && g1 == g2 && b1 == b2 && a1 == a2
+		return r.screenshotMismatch(cmp), cmp.diffImage, nil
+	})
 }
 
 func (r *Runner) takeSnapshot(ctx context.Context, filename string, testName string) error {
@@ -850,40 +1219,12 @@ func (r *Runner) takeSnapshot(ctx context.Context, filename string, testName str
 	}
 
 	path := filepath.Join(r.config.SnapshotDir, filename)
-
-	// Check if snapshot already exists
-	if _, err := os.Stat(path); err == nil {
-		// Snapshot exists, load and compare
-		baselineData, err := os.ReadFile(path)
-		if err != nil {
-			return fmt.Errorf("failed to read existing snapshot: %v", err)
-		}
-
-		// Compare snapshots
-		if !r.compareSnapshots(string(baselineData), html) {
-			// Save the actual snapshot for reference
-			actualPath := strings.TrimSuffix(path, ".html") + ".actual.html"
-			os.WriteFile(actualPath, []byte(html), 0644)
-
-			// Generate and save diff
-			diffHTML := r.generateHTMLDiff(string(baselineData), html)
-			diffPath := strings.TrimSuffix(path, ".html") + ".diff.html"
-			os.WriteFile(diffPath, []byte(diffHTML), 0644)
-
-			return fmt.Errorf("snapshot differs from baseline. Delete the old snapshot at %s to save the new one", path)
+	return r.checkBaseline("snapshot", path, []byte(html), r.config.UpdateSnapshots, func(baseline []byte) (string, []byte, error) {
+		if r.compareSnapshots(string(baseline), html) {
+			return "", nil, nil
 		}
-
-		// Snapshots match, no need to save
-		return nil
-	}
-
-	// Snapshot doesn't exist, save it
-	err = os.WriteFile(path, []byte(html), 0644)
-	if err != nil {
-		return fmt.Errorf("failed to save snapshot: %v", err)
-	}
-
-	return nil
+		return "differs from baseline", []byte(r.generateHTMLDiff(string(baseline), html)), nil
+	})
 }
 
 func (r *Runner) compareSnapshots(baseline, current string) bool {
@@ -949,51 +1290,8 @@ func escapeHTML(s string) string {
 }
 
 func (r *Runner) RunWithProgress(resultsChan chan<- TestResult, wg *sync.WaitGroup) []TestResult {
-	r.results = make([]TestResult, 0, len(r.tests))
-
-	// Run tests sequentially with Chrome restart when needed
-	for i, test := range r.tests {
-		// Check if we need to restart Chrome
-		// Restart after 2 consecutive timeouts, or every 10 tests to prevent context degradation
-		if r.failureCount >= 2 || (r.testsRun > 0 && r.testsRun % 10 == 0) {
-			if err := r.restartChrome(); err != nil {
-				// If restart fails, record error for all remaining tests
-				for j := i; j < len(r.tests); j++ {
-					result := TestResult{
-						Name:     r.tests[j].Name,
-						Passed:   false,
-						Error:    fmt.Errorf("Chrome restart failed: %v", err),
-						Duration: 0,
-					}
-					r.results = append(r.results, result)
-					wg.Add(1)
-					resultsChan <- result
-				}
-				return r.results
-			}
-		}
-		
-		// Run test with retry on timeout
-		result := r.runTestWithRetry(test)
-		r.results = append(r.results, result)
-		r.testsRun++
-		
-		// Send result to channel
+	return r.run(func(result TestResult) {
 		wg.Add(1)
 		resultsChan <- result
-		
-		// Small delay between tests to prevent resource exhaustion
-		if i < len(r.tests)-1 {
-			time.Sleep(200 * time.Millisecond)
-		}
-		
-		// Track consecutive failures
-		if !result.Passed && strings.Contains(result.Error.Error(), "context deadline exceeded") {
-			r.failureCount++
-		} else if result.Passed {
-			r.failureCount = 0 // Reset on success
-		}
-	}
-
-	return r.results
+	})
 }
@@ -1,12 +1,24 @@
 package fasttest
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"image"
+	"image/color"
+	"image/png"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/chromedp/cdproto/dom"
+	"github.com/chromedp/cdproto/input"
+	"github.com/chromedp/cdproto/runtime"
 )
 
 func TestNewRunner(t *testing.T) {
@@ -24,6 +36,9 @@ func TestNewRunner(t *testing.T) {
 	if runner.config.ScreenshotDir != "__screenshots__" {
 		t.Error("Expected default screenshot dir to be __screenshots__")
 	}
+	if runner.config.ViewportWidth != DefaultViewportWidth || runner.config.ViewportHeight != DefaultViewportHeight {
+		t.Error("Expected default viewport to be set")
+	}
 
 	// Test with custom config
 	customConfig := &Config{
@@ -40,6 +55,213 @@ func TestNewRunner(t *testing.T) {
 	}
 }
 
+func TestRunWithoutStartKeepsOrder(t *testing.T) {
+	runner := NewRunner(&Config{Workers: 3})
+	names := []string{"first", "second", "third", "fourth"}
+	for _, name := range names {
+		runner.AddTest(Test{Name: name})
+	}
+
+	resultsChan := make(chan TestResult, len(names))
+	var wg sync.WaitGroup
+	results := runner.RunWithProgress(resultsChan, &wg)
+	close(resultsChan)
+
+	if len(results) != len(names) {
+		t.Fatalf("Expected %d results, got %d", len(names), len(results))
+	}
+	i := 0
+	for result := range resultsChan {
+		if result.Name != names[i] {
+			t.Errorf("Result %d streamed as %q, want %q", i, result.Name, names[i])
+		}
+		if result.Passed || result.Error == nil {
+			t.Errorf("Expected %q to fail when the browser is not started", result.Name)
+		}
+		i++
+	}
+	if i != len(names) {
+		t.Errorf("Expected %d streamed results, got %d", len(names), i)
+	}
+}
+
+func TestRunSuiteWithoutStart(t *testing.T) {
+	runner := NewRunner(&Config{Workers: 2})
+	runner.AddSuite(Suite{
+		Name:      "suite.test",
+		BeforeAll: []Step{{Action: "navigate", Target: "http://localhost/reset"}},
+		AfterAll:  []Step{{Action: "navigate", Target: "http://localhost/cleanup"}},
+		Tests:     []Test{{Name: "one"}, {Name: "two"}},
+	})
+	runner.AddTest(Test{Name: "standalone"})
+
+	done := make(chan []TestResult)
+	go func() {
+		done <- runner.Run()
+	}()
+
+	var results []TestResult
+	select {
+	case results = <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run() did not return, hooks are deadlocked")
+	}
+
+	want := []struct {
+		name string
+		hook string
+	}{
+		{name: "before_all (suite.test)", hook: "before_all"},
+		{name: "one"},
+		{name: "two"},
+		{name: "after_all (suite.test)", hook: "after_all"},
+		{name: "standalone"},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("Expected %d results, got %d", len(want), len(results))
+	}
+	for i, w := range want {
+		if results[i].Name != w.name || results[i].Hook != w.hook {
+			t.Errorf("Result %d = %q (hook %q), want %q (hook %q)", i, results[i].Name, results[i].Hook, w.name, w.hook)
+		}
+		if results[i].Passed {
+			t.Errorf("Expected %q to fail when the browser is not started", results[i].Name)
+		}
+	}
+}
+
+func TestHooksSeeFileVars(t *testing.T) {
+	runner := NewRunner(nil)
+	runner.AddSuite(Suite{
+		Name:      "vars.test",
+		Setup:     []Step{{Action: "set", Target: "KEY", Value: "NoSuchKey"}},
+		BeforeAll: []Step{{Action: "press", Target: "${KEY}"}},
+		Tests:     []Test{{Name: "one"}},
+	})
+
+	jobs := runner.jobs()
+	if len(jobs) != 2 || jobs[0].hook != "before_all" {
+		t.Fatalf("Expected a before_all job and a test, got %v", jobs)
+	}
+	hook := jobs[0].test
+	if len(hook.Setup) != 1 {
+		t.Fatalf("before_all Setup = %v, want the file's set steps", hook.Setup)
+	}
+
+	// The variable resolves, so the step gets as far as checking its key
+	state := &testState{name: hook.Name, vars: make(map[string]string)}
+	if err := runner.runSteps(context.Background(), hook.Setup, state); err != nil {
+		t.Fatalf("runSteps(Setup) error = %v", err)
+	}
+	err := runner.runSteps(context.Background(), hook.Steps, state)
+	if err == nil || !strings.Contains(err.Error(), "unknown key: NoSuchKey") {
+		t.Errorf("runSteps(before_all) error = %v, want the expanded key to be checked", err)
+	}
+}
+
+func TestSkipAndOnly(t *testing.T) {
+	runner := NewRunner(nil)
+	runner.AddSuite(Suite{
+		Name:      "only.test",
+		BeforeAll: []Step{{Action: "navigate", Target: "http://localhost/reset"}},
+		Tests: []Test{
+			{Name: "focused", Only: true},
+			{Name: "other"},
+			{Name: "skipped", Skip: true, Only: true},
+		},
+	})
+	runner.AddSuite(Suite{
+		Name:      "rest.test",
+		BeforeAll: []Step{{Action: "navigate", Target: "http://localhost/reset"}},
+		Tests:     []Test{{Name: "unfocused"}},
+	})
+
+	results := runner.Run()
+	want := []struct {
+		name    string
+		skipped bool
+	}{
+		{name: "before_all (only.test)"},
+		{name: "focused"},
+		{name: "other", skipped: true},
+		{name: "skipped", skipped: true},
+		// rest.test has nothing to run, so its before_all is dropped
+		{name: "unfocused", skipped: true},
+	}
+	if len(results) != len(want) {
+		t.Fatalf("Expected %d results, got %d: %v", len(want), len(results), results)
+	}
+	for i, w := range want {
+		if results[i].Name != w.name || results[i].Skipped != w.skipped {
+			t.Errorf("Result %d = %q (skipped %v), want %q (skipped %v)", i, results[i].Name, results[i].Skipped, w.name, w.skipped)
+		}
+		if results[i].Skipped && results[i].Failed() {
+			t.Errorf("Skipped test %q should not count as failed", results[i].Name)
+		}
+	}
+}
+
+func TestStepTimeout(t *testing.T) {
+	runner := NewRunner(&Config{
+		Timeout:     45 * time.Second,
+		StepTimeout: 15 * time.Second,
+		ActionTimeouts: map[string]time.Duration{
+			"navigate": 20 * time.Second,
+			"click":    10 * time.Second,
+		},
+	})
+
+	tests := []struct {
+		action string
+		want   time.Duration
+	}{
+		{action: "navigate", want: 20 * time.Second},
+		{action: "click", want: 10 * time.Second},
+		{action: "type", want: 15 * time.Second},
+	}
+	for _, tt := range tests {
+		if got := runner.stepTimeout(tt.action); got != tt.want {
+			t.Errorf("stepTimeout(%q) = %s, want %s", tt.action, got, tt.want)
+		}
+	}
+
+	// Without a step timeout only the test timeout applies
+	runner = NewRunner(&Config{Timeout: 45 * time.Second})
+	if got := runner.stepTimeout("click"); got != 0 {
+		t.Errorf("stepTimeout without config = %s, want 0", got)
+	}
+}
+
+func TestStepString(t *testing.T) {
+	tests := []struct {
+		step Step
+		want string
+	}{
+		{step: Step{Action: "screenshot"}, want: "screenshot"},
+		{step: Step{Action: "click", Target: "#submit"}, want: `click "#submit"`},
+		{step: Step{Action: "type", Target: "#name", Value: "John Doe"}, want: `type "#name" "John Doe"`},
+	}
+	for _, tt := range tests {
+		if got := tt.step.String(); got != tt.want {
+			t.Errorf("Step.String() = %s, want %s", got, tt.want)
+		}
+	}
+}
+
+func TestLookupDevice(t *testing.T) {
+	info, err := LookupDevice("iphone 13")
+	if err != nil {
+		t.Fatalf("LookupDevice() error = %v", err)
+	}
+	if info.Name != "iPhone 13" || !info.Mobile || !info.Touch || info.Scale != 3 {
+		t.Errorf("Unexpected iPhone 13 preset: %+v", info)
+	}
+
+	if _, err := LookupDevice("Nokia 3310"); err == nil {
+		t.Error("Expected error for unknown device")
+	}
+}
+
 func TestScreenshotNaming(t *testing.T) {
 	runner := NewRunner(nil)
 	runner.screenshotCounter = make(map[string]int)
@@ -121,22 +343,124 @@ func TestCompareImages(t *testing.T) {
 	img2 := []byte{137, 80, 78, 71, 13, 10, 26, 10, 0, 0, 0, 13, 73, 72, 68, 82, 0, 0, 0, 1, 0, 0, 0, 1, 8, 2, 0, 0, 0, 144, 119, 83, 222, 0, 0, 0, 12, 73, 68, 65, 84, 8, 215, 99, 248, 255, 255, 63, 0, 5, 254, 2, 254, 220, 204, 89, 231, 0, 0, 0, 0, 73, 69, 78, 68, 174, 66, 96, 130}
 
 	// Test same images
-	diff, _, err := runner.compareImages(img1, img2)
+	cmp, err := runner.compareImages(img1, img2)
 	if err != nil {
 		t.Fatalf("compareImages() error = %v", err)
 	}
-	if diff != 0 {
-		t.Errorf("Expected 0 difference for identical images, got %f", diff)
+	if cmp.diff != 0 {
+		t.Errorf("Expected 0 difference for identical images, got %f", cmp.diff)
 	}
 
 	// Test invalid image data
 	invalidImg := []byte("not a png")
-	_, _, err = runner.compareImages(img1, invalidImg)
+	_, err = runner.compareImages(img1, invalidImg)
 	if err == nil {
 		t.Error("Expected error for invalid image data")
 	}
 }
 
+// testImage draws a 20x20 white PNG with a black vertical line at x=10,
+// after which draw may change it
+func testImage(t *testing.T, draw func(img *image.NRGBA)) []byte {
+	t.Helper()
+	img := image.NewNRGBA(image.Rect(0, 0, 20, 20))
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 20; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{255, 255, 255, 255})
+		}
+		img.SetNRGBA(10, y, color.NRGBA{0, 0, 0, 255})
+	}
+	if draw != nil {
+		draw(img)
+	}
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestComparePerceptual(t *testing.T) {
+	runner := NewRunner(&Config{ScreenshotMinSSIM: 0.5})
+	baseline := testImage(t, nil)
+
+	tests := []struct {
+		name        string
+		draw        func(img *image.NRGBA)
+		diff        float64
+		antialiased bool
+	}{
+		{
+			// Sampling every 10th pixel used to miss this entirely
+			name: "single changed pixel",
+			draw: func(img *image.NRGBA) { img.SetNRGBA(5, 5, color.NRGBA{255, 0, 0, 255}) },
+			diff: 1.0 / 400,
+		},
+		{
+			name: "barely visible color shift",
+			draw: func(img *image.NRGBA) {
+				for y := 0; y < 20; y++ {
+					img.SetNRGBA(3, y, color.NRGBA{250, 250, 252, 255})
+				}
+			},
+		},
+		{
+			name: "anti-aliased edge",
+			draw: func(img *image.NRGBA) {
+				for y := 0; y < 20; y++ {
+					img.SetNRGBA(9, y, color.NRGBA{128, 128, 128, 255})
+				}
+			},
+			antialiased: true,
+		},
+		{
+			name: "moved line",
+			draw: func(img *image.NRGBA) {
+				for y := 0; y < 20; y++ {
+					img.SetNRGBA(10, y, color.NRGBA{255, 255, 255, 255})
+					img.SetNRGBA(15, y, color.NRGBA{0, 0, 0, 255})
+				}
+			},
+			diff: 40.0 / 400,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmp, err := runner.compareImages(baseline, testImage(t, tt.draw))
+			if err != nil {
+				t.Fatalf("compareImages() error = %v", err)
+			}
+			if cmp.diff != tt.diff {
+				t.Errorf("diff = %f, want %f", cmp.diff, tt.diff)
+			}
+			if (cmp.antialiased > 0) != tt.antialiased {
+				t.Errorf("antialiased = %d, want any: %v", cmp.antialiased, tt.antialiased)
+			}
+			if cmp.ssim <= 0 || cmp.ssim >= 1 {
+				t.Errorf("ssim = %f, want between 0 and 1", cmp.ssim)
+			}
+			if (tt.diff > 0) != (cmp.diffImage != nil && runner.screenshotMismatch(cmp) != "") {
+				t.Errorf("screenshotMismatch() = %q with diff %f", runner.screenshotMismatch(cmp), cmp.diff)
+			}
+		})
+	}
+
+	cmp, err := runner.compareImages(baseline, testImage(t, func(img *image.NRGBA) {
+		for y := 0; y < 20; y++ {
+			for x := 0; x < 20; x++ {
+				img.SetNRGBA(x, y, color.NRGBA{uint8(x * 12), uint8(y * 12), 0, 255})
+			}
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	runner.config.ScreenshotThreshold = 1
+	if cmp.ssim >= 0.5 || !strings.Contains(runner.screenshotMismatch(cmp), "structural similarity") {
+		t.Errorf("Expected a low SSIM to fail, got %f: %q", cmp.ssim, runner.screenshotMismatch(cmp))
+	}
+}
+
 func TestTestResult(t *testing.T) {
 	result := TestResult{
 		Name:     "Test 1",
@@ -209,3 +533,511 @@ func TestAssertScreenshotPaths(t *testing.T) {
 		t.Errorf("Expected file at path %s", expectedPath)
 	}
 }
+
+func TestStepError(t *testing.T) {
+	step := Step{Action: "click", Target: "#submit", File: "login.test", Line: 12, Text: "click #submit"}
+	cause := fmt.Errorf("context deadline exceeded")
+
+	tests := []struct {
+		err  *StepError
+		want string
+	}{
+		{
+			err:  &StepError{Index: 3, Step: step, Elapsed: 1500 * time.Millisecond, Err: cause},
+			want: `step 3 (click "#submit") at login.test:12 failed after 1.5s: context deadline exceeded`,
+		},
+		{
+			err:  &StepError{Index: 3, Step: step, Timeout: 10 * time.Second, Err: cause},
+			want: `step 3 (click "#submit") at login.test:12 timed out after 10s: context deadline exceeded`,
+		},
+		{
+			err:  &StepError{Index: 3, Step: step, Timeout: 45 * time.Second, TestTimeout: true, Err: cause},
+			want: `step 3 (click "#submit") at login.test:12 hit the test timeout of 45s: context deadline exceeded`,
+		},
+		{
+			err:  &StepError{Index: 2, Step: Step{Action: "type", Target: "#user", Value: "admin", File: "auth.testit", Line: 3, CallFile: "login.test", CallLine: 8}, Err: cause},
+			want: `step 2 (type "#user" "admin") at auth.testit:3, called from login.test:8: context deadline exceeded`,
+		},
+		{
+			err:  &StepError{Index: 1, Step: Step{Action: "navigate", Target: "/", Line: 2}, Err: cause},
+			want: `step 1 (navigate "/") at line 2: context deadline exceeded`,
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.err.Error(); got != tt.want {
+			t.Errorf("Error() = %q, want %q", got, tt.want)
+		}
+	}
+
+	// The step error survives the hook prefix added by runTest
+	var stepErr *StepError
+	wrapped := fmt.Errorf("before_each: %w", tests[0].err)
+	if !errors.As(wrapped, &stepErr) || stepErr.Selector() != "#submit" || !errors.Is(wrapped, cause) {
+		t.Errorf("Expected to unwrap StepError from %v", wrapped)
+	}
+
+	selectors := []struct {
+		step Step
+		want string
+	}{
+		{step: Step{Action: "click", Target: "#submit"}, want: "#submit"},
+		{step: Step{Action: "assert_attribute", Target: "input#email|type", Value: "email"}, want: "input#email"},
+		{step: Step{Action: "navigate", Target: "https://example.com"}, want: ""},
+		{step: Step{Action: "press", Target: "Enter"}, want: ""},
+		{step: Step{Action: "set", Target: "user", Value: "admin"}, want: ""},
+	}
+	for _, tt := range selectors {
+		if got := (&StepError{Step: tt.step, Err: cause}).Selector(); got != tt.want {
+			t.Errorf("Selector() of %v = %q, want %q", tt.step, got, tt.want)
+		}
+	}
+}
+
+func TestIsTimeout(t *testing.T) {
+	step := Step{Action: "wait_for", Target: "#never"}
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "assertion", err: &StepError{Step: step, Err: errors.New("text mismatch")}, want: false},
+		{name: "step timeout", err: &StepError{Step: step, Timeout: 5 * time.Second, Err: context.DeadlineExceeded}, want: false},
+		{name: "test timeout", err: &StepError{Step: step, Timeout: 45 * time.Second, TestTimeout: true, Err: context.DeadlineExceeded}, want: true},
+		{name: "cancelled browser", err: fmt.Errorf("before_each: %w", &StepError{Step: step, Err: context.Canceled}), want: true},
+		{name: "message only", err: errors.New("context deadline exceeded"), want: false},
+	}
+	for _, tt := range tests {
+		if got := isTimeout(tt.err); got != tt.want {
+			t.Errorf("isTimeout(%s) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestQuadCenter(t *testing.T) {
+	x, y, ok := quadCenter(dom.Quad{10, 20, 110, 20, 110, 70, 10, 70})
+	if !ok || x != 60 || y != 45 {
+		t.Errorf("quadCenter() = %v, %v, %v, want 60, 45, true", x, y, ok)
+	}
+	if _, _, ok := quadCenter(nil); ok {
+		t.Error("Expected no center for an empty quad")
+	}
+
+	if button, err := LookupMouseButton(""); err != nil || button != input.Left {
+		t.Errorf("LookupMouseButton(\"\") = %v, %v", button, err)
+	}
+	if _, err := LookupMouseButton("back"); err == nil {
+		t.Error("Expected an error for an unsupported button")
+	}
+}
+
+func TestParseOption(t *testing.T) {
+	tests := []struct {
+		arg, by, want string
+		wantErr       bool
+	}{
+		{arg: "us", by: "", want: "us"},
+		{arg: "United States", by: "", want: "United States"},
+		{arg: "label=United States", by: "label", want: "United States"},
+		{arg: "value=label=x", by: "value", want: "label=x"},
+		{arg: "index=0", by: "index", want: "0"},
+		{arg: "index=-1", wantErr: true},
+		{arg: "index=first", wantErr: true},
+	}
+	for _, tt := range tests {
+		by, want, err := ParseOption(tt.arg)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseOption(%q) error = %v, wantErr %v", tt.arg, err, tt.wantErr)
+			continue
+		}
+		if by != tt.by || want != tt.want {
+			t.Errorf("ParseOption(%q) = %q, %q, want %q, %q", tt.arg, by, want, tt.by, tt.want)
+		}
+	}
+}
+
+func TestParseKeys(t *testing.T) {
+	tests := []struct {
+		combo     string
+		modifiers input.Modifier
+		key       string
+		wantErr   bool
+	}{
+		{combo: "Enter", key: "Enter"},
+		{combo: "enter", key: "Enter"},
+		{combo: "Esc", key: "Escape"},
+		{combo: "Control+A", modifiers: input.ModifierCtrl, key: "a"},
+		{combo: "Ctrl+Shift+a", modifiers: input.ModifierCtrl | input.ModifierShift, key: "A"},
+		{combo: "Shift+Tab", modifiers: input.ModifierShift, key: "Tab"},
+		{combo: "Meta++", modifiers: input.ModifierMeta, key: "+"},
+		{combo: "Shift", key: "Shift"},
+		{combo: "ArrowDown", key: "ArrowDown"},
+		{combo: "A+B", wantErr: true},
+		{combo: "Control+", wantErr: true},
+		{combo: "Hyperdrive", wantErr: true},
+	}
+	for _, tt := range tests {
+		modifiers, key, err := ParseKeys(tt.combo)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseKeys(%q) error = %v, wantErr %v", tt.combo, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (modifiers != tt.modifiers || key.Key != tt.key) {
+			t.Errorf("ParseKeys(%q) = %v, %q, want %v, %q", tt.combo, modifiers, key.Key, tt.modifiers, tt.key)
+		}
+	}
+
+	// Printable keys type on key down, but not as part of a shortcut
+	_, enter, _ := ParseKeys("Enter")
+	if event := keyEvent(input.KeyDown, enter, 0); event.Type != input.KeyDown || event.Text != "\r" {
+		t.Errorf("Enter key down = %+v", event)
+	}
+	modifiers, a, _ := ParseKeys("Control+A")
+	if event := keyEvent(input.KeyDown, a, modifiers); event.Type != input.KeyRawDown || event.Text != "" {
+		t.Errorf("Control+A key down = %+v", event)
+	}
+}
+
+func TestDownloads(t *testing.T) {
+	if !usesDownloads(Test{Steps: []Step{{Action: "click"}, {Action: "expect_download", Target: "a.csv"}}}) {
+		t.Error("Expected a test with expect_download to use downloads")
+	}
+	if usesDownloads(Test{Steps: []Step{{Action: "click"}}}) {
+		t.Error("Expected a test without download steps not to use downloads")
+	}
+
+	dir := t.TempDir()
+	ds := &downloadState{dir: dir, changed: make(chan struct{})}
+	if err := os.WriteFile(filepath.Join(dir, "guid-1"), []byte("id,name\n1,Ada\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The download starts before the step and finishes while it waits
+	ds.update(func() {
+		ds.downloads = append(ds.downloads, &download{guid: "guid-1", name: "report-2024.csv", path: filepath.Join(dir, "guid-1"), state: "inProgress"})
+	})
+	go ds.update(func() { ds.downloads[0].state = "completed" })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := ds.expect(ctx, "report-*.csv"); err != nil {
+		t.Fatalf("expect() error = %v", err)
+	}
+	d, data, err := ds.lastFile()
+	if err != nil || d.name != "report-2024.csv" || string(data) != "id,name\n1,Ada\n" {
+		t.Errorf("lastFile() = %v, %q, %v", d, data, err)
+	}
+
+	// A matched download is not matched again
+	short, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := ds.expect(short, "report-*.csv"); err == nil || !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected a timeout waiting for a second download, got %v", err)
+	}
+
+	tests := []struct {
+		check   string
+		size    int64
+		pass    bool
+		wantErr bool
+	}{
+		{check: "14", size: 14, pass: true},
+		{check: ">0", size: 14, pass: true},
+		{check: "<= 10", size: 14, pass: false},
+		{check: ">=14", size: 14, pass: true},
+		{check: "big", wantErr: true},
+		{check: "<-1", wantErr: true},
+	}
+	for _, tt := range tests {
+		op, want, err := ParseSizeCheck(tt.check)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseSizeCheck(%q) error = %v, wantErr %v", tt.check, err, tt.wantErr)
+			continue
+		}
+		if err == nil && checkSize(op, tt.size, want) != tt.pass {
+			t.Errorf("checkSize(%q, %d) = %v, want %v", tt.check, tt.size, !tt.pass, tt.pass)
+		}
+	}
+}
+
+func TestRoutes(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "user.json"), []byte(`{"name": "Ada"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	step, err := ParseRoute("mock", []string{"GET", "/api/user", "with", "file", "user.json", "status", "201"})
+	if err != nil {
+		t.Fatalf("ParseRoute() error = %v", err)
+	}
+	step.File = filepath.Join(dir, "user.test")
+	route, err := routeFromStep(step)
+	if err != nil {
+		t.Fatalf("routeFromStep() error = %v", err)
+	}
+	if route.Method != "GET" || route.Pattern != "/api/user" || route.Response.Status != 201 ||
+		string(route.Response.Body) != `{"name": "Ada"}` || route.Response.ContentType != "application/json" {
+		t.Errorf("routeFromStep() = %+v, %+v", route, route.Response)
+	}
+
+	for _, args := range [][]string{{"/api", "status", "600"}, {"/api", "with", "body", "a", "with", "body", "b"}, {"regex:("}, {"GET"}} {
+		if _, err := ParseRoute("mock", args); err == nil {
+			t.Errorf("ParseRoute(mock, %q) expected an error", args)
+		}
+	}
+
+	matches := []struct {
+		pattern string
+		method  string
+		url     string
+		want    bool
+	}{
+		{pattern: "/api/*", url: "http://localhost:8080/api/users?page=2", want: true},
+		{pattern: "/api/users", url: "http://localhost:8080/api/users?page=2", want: true},
+		{pattern: "/api/users?page=*", url: "http://localhost:8080/api/users?page=2", want: true},
+		{pattern: "/api/*", url: "http://localhost:8080/static/api/app.js", want: false},
+		{pattern: "*.analytics.com/*", url: "https://www.analytics.com/collect", want: true},
+		{pattern: "https://cdn.example.com/*", url: "http://cdn.example.com/a.js", want: false},
+		{pattern: `regex:/users/\d+$`, url: "http://localhost/api/users/42", want: true},
+		{pattern: `regex:/users/\d+$`, url: "http://localhost/api/users/me", want: false},
+		{pattern: "/api/*", method: "POST", url: "http://localhost/api/login", want: false},
+	}
+	for _, tt := range matches {
+		route := Route{Method: "GET", Pattern: tt.pattern}
+		method := tt.method
+		if method == "" {
+			method = "get"
+		}
+		if got := route.Matches(method, tt.url); got != tt.want {
+			t.Errorf("Route{%s}.Matches(%s, %s) = %v, want %v", tt.pattern, method, tt.url, got, tt.want)
+		}
+	}
+
+	// The newest mock wins and delays add up to the longest
+	ic := newInterceptor(context.Background())
+	ic.routes = []*Route{
+		{Pattern: "/api/user", Response: &MockResponse{Status: 500}},
+		{Pattern: "/api/*", Delay: time.Second},
+		{Pattern: "/api/*", Response: &MockResponse{Status: 200}},
+		{Pattern: "/api/*", Delay: 2 * time.Second},
+	}
+	delay, found := ic.route("GET", "http://localhost/api/user")
+	if delay != 2*time.Second || found == nil || found.Response.Status != 500 {
+		t.Errorf("route() = %v, %+v", delay, found)
+	}
+	if _, found := ic.route("GET", "http://localhost/home"); found != nil {
+		t.Errorf("route() for an unmatched URL = %+v, want nil", found)
+	}
+}
+
+func TestNetworkLog(t *testing.T) {
+	nl := newNetworkLog()
+	nl.update(func() {
+		nl.requests = append(nl.requests,
+			&request{method: "GET", url: "http://localhost/api/items", status: 200},
+			&request{method: "POST", url: "http://localhost/api/items", body: `{"name": "Milk", "items": [{"qty": 2}]}`, bodyFetched: true})
+	})
+	go nl.update(func() { nl.requests[1].status = 201 })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := nl.waitForRequest(ctx, "POST /api/items"); err != nil {
+		t.Fatalf("waitForRequest() error = %v", err)
+	}
+	if err := nl.responseStatus(ctx, "POST /api/items", "201"); err != nil {
+		t.Errorf("responseStatus(201) error = %v", err)
+	}
+	if err := nl.responseStatus(ctx, "/api/items", "2xx"); err != nil {
+		t.Errorf("responseStatus(2xx) error = %v", err)
+	}
+	if err := nl.responseStatus(ctx, "GET /api/items", "201"); err == nil || !strings.Contains(err.Error(), "got 200") {
+		t.Errorf("Expected a status mismatch, got %v", err)
+	}
+
+	// A matched request is not matched again
+	short, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := nl.waitForRequest(short, "POST /api/items"); err == nil || !strings.Contains(err.Error(), "latest requests: GET") {
+		t.Errorf("Expected a timeout listing the requests, got %v", err)
+	}
+
+	_, body, err := nl.requestBody(ctx)
+	if err != nil {
+		t.Fatalf("requestBody() error = %v", err)
+	}
+	tests := []struct {
+		path     string
+		expected string
+		wantErr  bool
+	}{
+		{path: "$.name", expected: "Milk"},
+		{path: "$.name", expected: `"Milk"`},
+		{path: "items[0].qty", expected: "2"},
+		{path: `$["items"][0]`, expected: `{"qty": 2}`},
+		{path: "$", expected: `{"items": [{"qty": 2}], "name": "Milk"}`},
+		{path: "$.name", expected: "Bread", wantErr: true},
+		{path: "$.items[1].qty", expected: "2", wantErr: true},
+		{path: "$.name.first", expected: "Milk", wantErr: true},
+		{path: "$.items[x]", expected: "2", wantErr: true},
+	}
+	for _, tt := range tests {
+		if err := matchJSON(body, tt.path, tt.expected); (err != nil) != tt.wantErr {
+			t.Errorf("matchJSON(%s, %s) error = %v, wantErr %v", tt.path, tt.expected, err, tt.wantErr)
+		}
+	}
+}
+
+func TestPageErrors(t *testing.T) {
+	stack := &runtime.StackTrace{CallFrames: []*runtime.CallFrame{
+		{FunctionName: "", URL: ""},
+		{FunctionName: "save", URL: "http://localhost/app.js", LineNumber: 9, ColumnNumber: 4},
+	}}
+	e := exceptionError(&runtime.ExceptionDetails{
+		Text:       "Uncaught (in promise)",
+		StackTrace: stack,
+		Exception:  &runtime.RemoteObject{Type: "object", Description: "TypeError: items is undefined\n    at save (app.js:10:5)"},
+	})
+	if e.Type != ErrorRejection || e.Message != "Uncaught (in promise) TypeError: items is undefined" || e.URL != "http://localhost/app.js" {
+		t.Errorf("exceptionError() = %+v", e)
+	}
+	if want := "    at <anonymous> (:1:1)\n    at save (http://localhost/app.js:10:5)"; e.Stack != want {
+		t.Errorf("exceptionError() stack = %q, want %q", e.Stack, want)
+	}
+	if e := exceptionError(&runtime.ExceptionDetails{Text: "Uncaught", Exception: &runtime.RemoteObject{Type: "string", Value: []byte(`"boom"`)}}); e.Type != ErrorException || e.Message != "Uncaught boom" {
+		t.Errorf("exceptionError() for a thrown string = %+v", e)
+	}
+
+	args := []*runtime.RemoteObject{
+		{Type: "string", Value: []byte(`"Failed:"`)},
+		{Type: "number", Value: []byte(`42`)},
+		{Type: "object", Description: "Error: bad"},
+		{Type: "number", UnserializableValue: "NaN"},
+	}
+	if got := formatArgs(args); got != "Failed: 42 Error: bad NaN" {
+		t.Errorf("formatArgs() = %q", got)
+	}
+
+	// Chrome's network log entry for an error response is not counted twice
+	pe := newPageErrors(nil)
+	pe.add(ConsoleError{Type: ErrorNetwork, Message: "Failed to load resource", requestID: "1"})
+	pe.add(ConsoleError{Type: ErrorHTTP, Message: "404 Not Found", requestID: "1"})
+	pe.add(ConsoleError{Type: ErrorHTTP, Message: "500 Internal Server Error", requestID: "2"})
+	pe.add(ConsoleError{Type: ErrorNetwork, Message: "Failed to load resource", requestID: "2"})
+	pe.add(ConsoleError{Type: ErrorNetwork, Message: "net::ERR_NAME_NOT_RESOLVED", requestID: "3"})
+	pe.add(ConsoleError{Type: ErrorWarning, Message: "deprecated"})
+	got := pe.list()
+	if len(got) != 4 || got[0].Message != "404 Not Found" || got[2].Type != ErrorNetwork {
+		t.Errorf("list() = %+v", got)
+	}
+
+	if failing := pe.failing(nil); len(failing) != 0 {
+		t.Errorf("Expected HTTP errors and warnings not to fail by default, got %v", failing)
+	}
+	if failing := pe.failing([]string{ErrorHTTP}); len(failing) != 2 {
+		t.Errorf("Expected the two HTTP errors to fail, got %v", failing)
+	}
+
+	// Ignored errors do not fail, and expected ones are only matched once
+	ignore, err := ParseErrorPattern(`favicon\.ico`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pe = newPageErrors([]*regexp.Regexp{ignore})
+	pe.add(ConsoleError{Type: ErrorHTTP, Message: "404 Not Found", URL: "http://localhost/favicon.ico"})
+	pe.add(ConsoleError{Type: ErrorConsole, Message: "Widget failed to load"})
+	go pe.add(ConsoleError{Type: ErrorException, Message: "Uncaught TypeError: x is undefined"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := pe.expect(ctx, regexp.MustCompile("TypeError")); err != nil {
+		t.Fatalf("expect() error = %v", err)
+	}
+	failing := pe.failing([]string{ErrorConsole, ErrorException, ErrorHTTP})
+	if len(failing) != 1 || failing[0].Message != "Widget failed to load" {
+		t.Errorf("failing() = %v", failing)
+	}
+	short, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := pe.expect(short, regexp.MustCompile("TypeError")); err == nil || !strings.Contains(err.Error(), "Widget failed to load") {
+		t.Errorf("Expected a timeout listing the page errors, got %v", err)
+	}
+	if _, err := ParseErrorPattern("widget(.js"); err == nil {
+		t.Error("Expected an error for an invalid pattern")
+	}
+
+	if types, err := ParseErrorTypes([]string{"exception", " http", ""}); err != nil || len(types) != 2 || types[1] != "http" {
+		t.Errorf("ParseErrorTypes() = %v, %v", types, err)
+	}
+	if _, err := ParseErrorTypes([]string{"warnings"}); err == nil {
+		t.Error("Expected an error for an unknown error type")
+	}
+}
+
+func TestCheckBaseline(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "home.html")
+	actualPath, diffPath := artifactPaths(path)
+	if actualPath != filepath.Join(dir, "home.actual.html") || diffPath != filepath.Join(dir, "home.diff.html") {
+		t.Errorf("artifactPaths() = %s, %s", actualPath, diffPath)
+	}
+	compare := func(current string) func([]byte) (string, []byte, error) {
+		return func(baseline []byte) (string, []byte, error) {
+			if strings.EqualFold(string(baseline), current) {
+				return "", nil, nil
+			}
+			return "differs from baseline", []byte("diff"), nil
+		}
+	}
+	check := func(r *Runner, data string, update bool) error {
+		return r.checkBaseline("snapshot", path, []byte(data), update, compare(data))
+	}
+	read := func(path string) string {
+		data, _ := os.ReadFile(path)
+		return string(data)
+	}
+
+	r := NewRunner(nil)
+	if err := check(r, "<p>one</p>", false); err != nil {
+		t.Fatalf("Saving a missing baseline: %v", err)
+	}
+	if err := check(r, "<P>ONE</P>", false); err != nil {
+		t.Errorf("Expected a match, got %v", err)
+	}
+	err := check(r, "<p>two</p>", false)
+	if err == nil || !strings.Contains(err.Error(), "-update-snapshots") {
+		t.Errorf("Expected a mismatch naming the update flag, got %v", err)
+	}
+	if read(path) != "<p>one</p>" || read(actualPath) != "<p>two</p>" || read(diffPath) != "diff" {
+		t.Errorf("Expected the baseline kept and the actual and diff saved")
+	}
+
+	// CI mode never updates
+	r.config.NoBaselineUpdates = true
+	if err := check(r, "<p>two</p>", true); err == nil || read(path) != "<p>one</p>" {
+		t.Errorf("Expected no update with NoBaselineUpdates, got %v", err)
+	}
+
+	r.config.NoBaselineUpdates = false
+	if err := check(r, "<p>two</p>", true); err != nil || read(path) != "<p>two</p>" {
+		t.Errorf("Expected the baseline updated, got %v", err)
+	}
+	for _, stale := range []string{actualPath, diffPath} {
+		if _, err := os.Stat(stale); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed", stale)
+		}
+	}
+
+	got := r.Baselines()
+	if len(got.New) != 1 || len(got.Unchanged) != 1 || len(got.Mismatched) != 2 || len(got.Updated) != 1 || got.Empty() {
+		t.Errorf("Baselines() = %+v", got)
+	}
+
+	// A directory in the way of the actual file makes saving it fail
+	if err := os.Mkdir(actualPath, 0755); err != nil {
+		t.Fatal(err)
+	}
+	err = check(r, "<p>three</p>", false)
+	if err == nil || !strings.Contains(err.Error(), "Failed to save the actual snapshot") {
+		t.Errorf("Expected the failure to save the actual snapshot, got %v", err)
+	}
+}
@@ -0,0 +1,97 @@
+package fasttest
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ExpandVars replaces every ${NAME} reference in s using lookup. References
+// lookup does not know are left as they are and their names are returned, so
+// callers can decide whether that is an error. Write $${ for a literal ${.
+func ExpandVars(s string, lookup func(name string) (string, bool)) (string, []string) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+
+	var b strings.Builder
+	var missing []string
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			b.WriteString(s)
+			break
+		}
+		// $${ escapes the reference
+		if i > 0 && s[i-1] == '$' {
+			b.WriteString(s[:i-1])
+			b.WriteString("${")
+			s = s[i+2:]
+			continue
+		}
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			b.WriteString(s)
+			break
+		}
+		name := s[i+2 : i+end]
+		b.WriteString(s[:i])
+		if value, ok := lookup(name); ok {
+			b.WriteString(value)
+		} else {
+			b.WriteString(s[i : i+end+1])
+			missing = append(missing, name)
+		}
+		s = s[i+end+1:]
+	}
+	return b.String(), missing
+}
+
+// IsVarName reports whether name can be used in a ${NAME} reference
+func IsVarName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		switch {
+		case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case c >= '0' && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// lookupVar resolves a variable from the test's own variables, then the
+// configured vars, then the process environment. The test's variables need
+// no lock, as only its own steps use them and they run one at a time.
+func (r *Runner) lookupVar(state *testState, name string) (string, bool) {
+	if value, ok := state.vars[name]; ok {
+		return value, true
+	}
+	if value, ok := r.config.Vars[name]; ok {
+		return value, true
+	}
+	return os.LookupEnv(name)
+}
+
+// setVar stores a variable for the rest of the test
+func (r *Runner) setVar(state *testState, name, value string) {
+	state.vars[name] = value
+}
+
+// interpolateStep expands variables in the step's Target and Value
+func (r *Runner) interpolateStep(step Step, state *testState) (Step, error) {
+	lookup := func(name string) (string, bool) {
+		return r.lookupVar(state, name)
+	}
+	var missing, m []string
+	step.Target, missing = ExpandVars(step.Target, lookup)
+	step.Value, m = ExpandVars(step.Value, lookup)
+	missing = append(missing, m...)
+	if len(missing) > 0 {
+		return step, fmt.Errorf("undefined variable: %s", missing[0])
+	}
+	return step, nil
+}
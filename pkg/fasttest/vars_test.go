@@ -0,0 +1,133 @@
+package fasttest
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestExpandVars(t *testing.T) {
+	vars := map[string]string{
+		"HOST": "http://localhost:8080",
+		"user": "admin",
+		"":     "empty",
+	}
+	lookup := func(name string) (string, bool) {
+		value, ok := vars[name]
+		return value, ok
+	}
+
+	tests := []struct {
+		input       string
+		want        string
+		wantMissing []string
+	}{
+		{input: "plain text", want: "plain text"},
+		{input: "${HOST}/login", want: "http://localhost:8080/login"},
+		{input: "${user}@${HOST}", want: "admin@http://localhost:8080"},
+		{input: "${missing}/x", want: "${missing}/x", wantMissing: []string{"missing"}},
+		{input: "literal $${user}", want: "literal ${user}"},
+		{input: "unterminated ${user", want: "unterminated ${user"},
+		{input: "$5 and $user", want: "$5 and $user"},
+	}
+
+	for _, tt := range tests {
+		got, missing := ExpandVars(tt.input, lookup)
+		if got != tt.want {
+			t.Errorf("ExpandVars(%q) = %q, want %q", tt.input, got, tt.want)
+		}
+		if len(missing) != len(tt.wantMissing) {
+			t.Errorf("ExpandVars(%q) missing = %v, want %v", tt.input, missing, tt.wantMissing)
+		}
+	}
+}
+
+func TestInterpolateStep(t *testing.T) {
+	t.Setenv("TESTIT_PASSWORD", "from-env")
+	runner := NewRunner(&Config{
+		Vars: map[string]string{
+			"HOST": "http://config",
+			"user": "config-user",
+		},
+	})
+	state := &testState{name: "Vars", vars: map[string]string{"user": "test-user"}}
+
+	step, err := runner.interpolateStep(Step{Action: "type", Target: "${HOST}/#${user}", Value: "${TESTIT_PASSWORD}"}, state)
+	if err != nil {
+		t.Fatalf("interpolateStep() error = %v", err)
+	}
+	if step.Target != "http://config/#test-user" {
+		t.Errorf("Target = %q, test vars should win over config vars", step.Target)
+	}
+	if step.Value != "from-env" {
+		t.Errorf("Value = %q, want value from environment", step.Value)
+	}
+
+	if _, err := runner.interpolateStep(Step{Action: "navigate", Target: "${NOPE_NOT_SET}"}, state); err == nil {
+		t.Error("Expected error for undefined variable")
+	}
+}
+
+func TestRunStepChecksExpandedArgs(t *testing.T) {
+	runner := NewRunner(&Config{})
+	state := &testState{name: "Vars", vars: map[string]string{"key": "NoSuchKey", "blank": " ", "width": "-1"}}
+
+	tests := []struct {
+		step Step
+		want string
+	}{
+		{Step{Action: "press", Target: "${key}"}, "unknown key: NoSuchKey"},
+		{Step{Action: "press", Target: "${blank}"}, "press requires a key"},
+		{Step{Action: "viewport", Target: "${width}", Value: "800"}, "invalid viewport size: -1"},
+	}
+	for _, tt := range tests {
+		// The check fails before the step would need a browser
+		err := runner.runStep(context.Background(), 0, tt.step, state)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("runStep(%v) error = %v, want %q", tt.step, err, tt.want)
+		}
+	}
+}
+
+func TestCheckStep(t *testing.T) {
+	for _, step := range []Step{
+		{Action: "press", Target: "${key} Enter"},
+		{Action: "viewport", Target: "${w}", Value: "800"},
+		{Action: "assert_response_status", Target: "/api", Value: "${status}"},
+		{Action: "assert_download_size", Value: "${size}"},
+		{Action: "select", Target: "#s", Value: "${option}"},
+		{Action: "mouse_move", Target: "${x}", Value: "${y}"},
+		{Action: "device", Target: "${device}"},
+	} {
+		if err := CheckStep(step); err != nil {
+			t.Errorf("CheckStep(%v) error = %v, variables should be left for later", step, err)
+		}
+	}
+	for _, step := range []Step{
+		{Action: "press", Target: "${key} NoSuchKey"},
+		{Action: "press", Target: " "},
+		{Action: "viewport", Target: "${w}", Value: "tall"},
+		{Action: "assert_response_status", Target: "/api", Value: "600"},
+		{Action: "assert_download_size", Value: "big"},
+		{Action: "select", Target: "#s", Value: "index=-1"},
+		{Action: "mouse_move", Target: "-5", Value: "${y}"},
+		{Action: "device", Target: "Nokia 3310"},
+	} {
+		if err := CheckStep(step); err == nil {
+			t.Errorf("CheckStep(%v) = nil, want an error", step)
+		}
+	}
+}
+
+func TestIsVarName(t *testing.T) {
+	for _, name := range []string{"user", "HOST", "_private", "order_id2"} {
+		if !IsVarName(name) {
+			t.Errorf("IsVarName(%q) = false, want true", name)
+		}
+	}
+	for _, name := range []string{"", "1st", "my-var", "a b", "${x}"} {
+		if IsVarName(name) {
+			t.Errorf("IsVarName(%q) = true, want false", name)
+		}
+	}
+}
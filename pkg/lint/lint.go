@@ -0,0 +1,152 @@
+// Package lint finds mistakes in .test files that the parser accepts but
+// that fail or misbehave at run time.
+package lint
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/kidandcat/testit/pkg/dsl/ast"
+	"github.com/kidandcat/testit/pkg/parser"
+)
+
+type Severity string
+
+const (
+	Error   Severity = "error"
+	Warning Severity = "warning"
+)
+
+// Diagnostic is a problem found in a file
+type Diagnostic struct {
+	File     string   `json:"file"`
+	Line     int      `json:"line"`
+	Column   int      `json:"column"`
+	Rule     string   `json:"rule"`
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+}
+
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%s:%d:%d: %s: %s (%s)", d.File, d.Line, d.Column, d.Severity, d.Message, d.Rule)
+}
+
+// Reporter records a diagnostic for a rule at a position in file
+type Reporter func(file *ast.File, pos ast.Pos, format string, args ...interface{})
+
+// Rule is a check. It sees every linted file at once, so it can look for
+// problems across files, like tests with the same name.
+type Rule struct {
+	Name     string
+	Doc      string
+	Severity Severity
+	Check    func(files []*ast.File, report Reporter)
+}
+
+// SyntaxRule is the name diagnostics from the parser are reported under
+const SyntaxRule = "syntax"
+
+var rules []*Rule
+
+// Register adds a rule to those run by default
+func Register(rule *Rule) {
+	rules = append(rules, rule)
+}
+
+// Rules returns the registered rules
+func Rules() []*Rule {
+	return append([]*Rule(nil), rules...)
+}
+
+// Files lints the named files. Files with syntax errors are reported under
+// SyntaxRule and left out of the other rules.
+func Files(paths []string, rules []*Rule) ([]Diagnostic, error) {
+	var diags []Diagnostic
+	var files []*ast.File
+
+	// The parser catches what the tree does not, like unknown actions and
+	// calls to undefined procedures. It is shared across files, as when
+	// running them, so a file imported by several is parsed once.
+	p := parser.New()
+
+	for _, path := range paths {
+		src, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		file, err := ast.Parse(path, src)
+		if err == nil {
+			_, err = p.ParseFile(path)
+		}
+		if err != nil {
+			diags = append(diags, syntaxDiagnostics(path, err)...)
+			continue
+		}
+		files = append(files, file)
+	}
+
+	diags = append(diags, Run(files, rules)...)
+	return sortDiagnostics(diags), nil
+}
+
+// Run runs rules over parsed files
+func Run(files []*ast.File, rules []*Rule) []Diagnostic {
+	var diags []Diagnostic
+	for _, rule := range rules {
+		rule := rule
+		rule.Check(files, func(file *ast.File, pos ast.Pos, format string, args ...interface{}) {
+			diags = append(diags, Diagnostic{
+				File:     file.Name,
+				Line:     pos.Line,
+				Column:   pos.Column,
+				Rule:     rule.Name,
+				Severity: rule.Severity,
+				Message:  fmt.Sprintf(format, args...),
+			})
+		})
+	}
+	return sortDiagnostics(diags)
+}
+
+func syntaxDiagnostics(path string, err error) []Diagnostic {
+	var list parser.ErrorList
+	if !errors.As(err, &list) {
+		return []Diagnostic{{File: path, Line: 1, Column: 1, Rule: SyntaxRule, Severity: Error, Message: err.Error()}}
+	}
+
+	diags := make([]Diagnostic, 0, len(list))
+	for _, e := range list {
+		d := Diagnostic{File: e.File, Line: e.Line, Column: e.Column, Rule: SyntaxRule, Severity: Error, Message: e.Msg}
+		if d.File == "" {
+			d.File = path
+		}
+		diags = append(diags, d)
+	}
+	return diags
+}
+
+// sortDiagnostics orders diagnostics by position and drops duplicates, such
+// as errors in a library reported through every file that imports it
+func sortDiagnostics(diags []Diagnostic) []Diagnostic {
+	sort.SliceStable(diags, func(i, j int) bool {
+		a, b := diags[i], diags[j]
+		if a.File != b.File {
+			return a.File < b.File
+		}
+		if a.Line != b.Line {
+			return a.Line < b.Line
+		}
+		return a.Column < b.Column
+	})
+
+	var unique []Diagnostic
+	for i, d := range diags {
+		if i > 0 && d == diags[i-1] {
+			continue
+		}
+		unique = append(unique, d)
+	}
+	return unique
+}
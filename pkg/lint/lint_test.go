@@ -0,0 +1,121 @@
+package lint
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kidandcat/testit/pkg/dsl/ast"
+)
+
+func parse(t *testing.T, name, src string) *ast.File {
+	t.Helper()
+	file, err := ast.Parse(name, []byte(src))
+	if err != nil {
+		t.Fatalf("Parse(%s) error = %v", name, err)
+	}
+	return file
+}
+
+func TestRules(t *testing.T) {
+	a := parse(t, "a.test", `navigate https://example.com
+set BASE_URL http://localhost
+
+define login(user)
+  type #user ${user}
+end
+
+define unused
+  click #a
+end
+
+test "Login"
+  call login admin
+  navigate ${BASE_URL}
+  # comments do not count
+  screenshot
+
+test "Login"
+  navigate ${BASE_URL}
+  wait_for h1
+  screenshot`)
+	b := parse(t, "b.test", `test "Check out"
+  click #buy
+
+test "Check_out"
+  click #buy`)
+
+	diags := Run([]*ast.File{a, b}, Rules())
+	var got []string
+	for _, d := range diags {
+		got = append(got, d.String())
+	}
+	want := []string{
+		`a.test:1:1: error: navigate is outside of any test and is never run (step-outside-test)`,
+		`a.test:8:8: warning: procedure unused is never called (unused-procedure)`,
+		`a.test:16:3: warning: screenshot right after navigate; add a wait_for so the page has rendered (screenshot-after-navigate)`,
+		`a.test:18:1: error: duplicate test name "Login", also used at a.test:12:1 (duplicate-test-name)`,
+		`b.test:4:1: error: test "Check_out" has the same screenshot name as "Check out" at b.test:1:1 (duplicate-test-name)`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Got:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestProcedureCalledFromAnotherFile(t *testing.T) {
+	lib := parse(t, "lib.testit", "define login(user)\n  type #user ${user}\nend")
+	main := parse(t, "main.test", "import \"lib.testit\"\n\ntest \"Login\"\n  call login admin")
+	if diags := Run([]*ast.File{lib, main}, []*Rule{UnusedProcedure}); len(diags) != 0 {
+		t.Errorf("Expected no diagnostics, got %v", diags)
+	}
+}
+
+func TestProcedureCallsAreScopedToImports(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "common.testit"), []byte("define reset\n  navigate /reset\nend\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "lib.testit"), []byte("import \"common.testit\"\n"), 0644)
+	a := parse(t, filepath.Join(dir, "a.test"), "define login\n  click #a\nend\n\ntest \"A\"\n  call login")
+	b := parse(t, filepath.Join(dir, "b.test"), "import \"lib.testit\"\n\ndefine login\n  click #b\nend\n\ntest \"B\"\n  call reset")
+	common := parse(t, filepath.Join(dir, "common.testit"), "define reset\n  navigate /reset\nend\n")
+
+	// b.test's login is not called just because a.test calls its own, while
+	// reset is reached through an import of an import that is not linted
+	got := Run([]*ast.File{a, b, common}, []*Rule{UnusedProcedure})
+	if len(got) != 1 || got[0].File != b.Name || got[0].Line != 3 {
+		t.Errorf("Expected only b.test's login to be unused, got %v", got)
+	}
+}
+
+func TestFiles(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.test")
+	bad := filepath.Join(dir, "bad.test")
+	os.WriteFile(good, []byte("test \"Good\"\n  click #a\n"), 0644)
+	// The unused procedure is not reported, as the file has syntax errors
+	os.WriteFile(bad, []byte("test \"Bad\"\n  clik #a\n  call missing\n\ndefine unused\n  click #b\nend\n"), 0644)
+
+	diags, err := Files([]string{good, bad}, Rules())
+	if err != nil {
+		t.Fatalf("Files() error = %v", err)
+	}
+	if len(diags) != 2 {
+		t.Fatalf("Expected 2 diagnostics, got %v", diags)
+	}
+	if diags[0].Rule != SyntaxRule || diags[0].Line != 2 || diags[0].Column != 3 || diags[0].Message != "unknown action: clik" {
+		t.Errorf("Unexpected diagnostic %+v", diags[0])
+	}
+	if diags[1].Line != 3 || !strings.Contains(diags[1].Message, "undefined procedure") {
+		t.Errorf("Unexpected diagnostic %+v", diags[1])
+	}
+
+	data, err := json.Marshal(diags[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `{"file":"` + bad + `","line":2,"column":3,"rule":"syntax","severity":"error","message":"unknown action: clik"}`
+	if string(data) != want {
+		t.Errorf("JSON = %s, want %s", data, want)
+	}
+}
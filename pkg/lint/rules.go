@@ -0,0 +1,213 @@
+package lint
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kidandcat/testit/pkg/dsl/ast"
+)
+
+func init() {
+	Register(DuplicateTestName)
+	Register(StepOutsideTest)
+	Register(ScreenshotAfterNavigate)
+	Register(UnusedProcedure)
+}
+
+// DuplicateTestName finds tests that share a name, across all files.
+// Screenshots and snapshots without a name are stored under the test name,
+// so such tests overwrite each other's baselines.
+var DuplicateTestName = &Rule{
+	Name:     "duplicate-test-name",
+	Doc:      "Tests must have unique names; unnamed screenshots are stored by test name",
+	Severity: Error,
+	Check: func(files []*ast.File, report Reporter) {
+		type seen struct {
+			file *ast.File
+			test *ast.Test
+		}
+		first := make(map[string]seen)
+		for _, file := range files {
+			for _, test := range tests(file) {
+				key := screenshotName(test.Name)
+				prev, ok := first[key]
+				if !ok {
+					first[key] = seen{file, test}
+					continue
+				}
+				where := prev.file.Name + ":" + prev.test.Start.String()
+				if prev.test.Name == test.Name {
+					report(file, test.Start, "duplicate test name %q, also used at %s", test.Name, where)
+				} else {
+					report(file, test.Start, "test %q has the same screenshot name as %q at %s", test.Name, prev.test.Name, where)
+				}
+			}
+		}
+	},
+}
+
+// StepOutsideTest finds steps that are not inside a test, hook or procedure.
+// The parser only keeps set there, and drops everything else.
+var StepOutsideTest = &Rule{
+	Name:     "step-outside-test",
+	Doc:      "Steps before the first test or hook are never run",
+	Severity: Error,
+	Check: func(files []*ast.File, report Reporter) {
+		for _, file := range files {
+			for _, stmt := range file.Stmts {
+				if step, ok := stmt.(*ast.Step); ok && step.Action != "set" {
+					report(file, step.Start, "%s is outside of any test and is never run", step.Action)
+				}
+			}
+		}
+	},
+}
+
+// ScreenshotAfterNavigate finds screenshots taken straight after navigate.
+// navigate returns once the document has loaded, which can be before the page
+// has rendered, so the screenshot differs from run to run.
+var ScreenshotAfterNavigate = &Rule{
+	Name:     "screenshot-after-navigate",
+	Doc:      "Wait for an element between navigate and screenshot",
+	Severity: Warning,
+	Check: func(files []*ast.File, report Reporter) {
+		for _, file := range files {
+			for _, body := range bodies(file) {
+				var prev *ast.Step
+				for _, node := range body {
+					step, ok := node.(*ast.Step)
+					if !ok {
+						continue
+					}
+					if step.Action == "screenshot" && prev != nil && prev.Action == "navigate" {
+						report(file, step.Start, "screenshot right after navigate; add a wait_for so the page has rendered")
+					}
+					prev = step
+				}
+			}
+		}
+	},
+}
+
+// UnusedProcedure finds procedures that no linted file calls. A call is
+// resolved as the parser does, against its own file and the files it
+// imports, so a procedure is not kept alive by a namesake elsewhere.
+var UnusedProcedure = &Rule{
+	Name:     "unused-procedure",
+	Doc:      "Procedures should be called from at least one test, hook or procedure",
+	Severity: Warning,
+	Check: func(files []*ast.File, report Reporter) {
+		loaded := make(map[string]*ast.File)
+		for _, file := range files {
+			loaded[fileKey(file.Name)] = file
+		}
+
+		called := make(map[*ast.Define]bool)
+		for _, file := range files {
+			scope := procedures(file, loaded)
+			for _, body := range bodies(file) {
+				for _, node := range body {
+					if step, ok := node.(*ast.Step); ok && step.Action == "call" && len(step.Args) > 0 {
+						if def := scope[step.Args[0].Value]; def != nil {
+							called[def] = true
+						}
+					}
+				}
+			}
+		}
+
+		for _, file := range files {
+			for _, stmt := range file.Stmts {
+				if def, ok := stmt.(*ast.Define); ok && !called[def] {
+					report(file, def.NamePos, "procedure %s is never called", def.Name)
+				}
+			}
+		}
+	},
+}
+
+// procedures returns the procedures a file can call: its own and those of
+// the files it imports, however deep. Imports that are not linted are read
+// from disk and kept in loaded; ones that cannot be read were already
+// reported by the parser and are skipped.
+func procedures(file *ast.File, loaded map[string]*ast.File) map[string]*ast.Define {
+	scope := make(map[string]*ast.Define)
+	seen := make(map[string]bool)
+	var visit func(file *ast.File)
+	visit = func(file *ast.File) {
+		key := fileKey(file.Name)
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+		for _, stmt := range file.Stmts {
+			switch n := stmt.(type) {
+			case *ast.Define:
+				if scope[n.Name] == nil {
+					scope[n.Name] = n
+				}
+			case *ast.Import:
+				if n.Path == nil || n.Path.Value == "" {
+					continue
+				}
+				path := n.Path.Value
+				if !filepath.IsAbs(path) && file.Name != "" {
+					path = filepath.Join(filepath.Dir(file.Name), path)
+				}
+				imported, ok := loaded[fileKey(path)]
+				if !ok {
+					if src, err := os.ReadFile(path); err == nil {
+						imported, _ = ast.Parse(path, src)
+					}
+					loaded[fileKey(path)] = imported
+				}
+				if imported != nil {
+					visit(imported)
+				}
+			}
+		}
+	}
+	visit(file)
+	return scope
+}
+
+// fileKey identifies a file however its path was written
+func fileKey(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		return abs
+	}
+	return filepath.Clean(path)
+}
+
+func tests(file *ast.File) []*ast.Test {
+	var tests []*ast.Test
+	for _, stmt := range file.Stmts {
+		if test, ok := stmt.(*ast.Test); ok {
+			tests = append(tests, test)
+		}
+	}
+	return tests
+}
+
+// bodies returns the statements of every block in the file
+func bodies(file *ast.File) [][]ast.Node {
+	var bodies [][]ast.Node
+	for _, stmt := range file.Stmts {
+		switch n := stmt.(type) {
+		case *ast.Test:
+			bodies = append(bodies, n.Body)
+		case *ast.Hook:
+			bodies = append(bodies, n.Body)
+		case *ast.Define:
+			bodies = append(bodies, n.Body)
+		}
+	}
+	return bodies
+}
+
+// screenshotName is the file name the runner gives a test's unnamed
+// screenshots, without the extension
+func screenshotName(testName string) string {
+	return strings.NewReplacer(" ", "_", "/", "_", "\\", "_").Replace(testName)
+}
@@ -0,0 +1,443 @@
+package lsp
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/kidandcat/testit/pkg/dsl/ast"
+	"github.com/kidandcat/testit/pkg/lint"
+	"github.com/kidandcat/testit/pkg/parser"
+)
+
+// keywords are the words that start a line but are not step actions
+var keywords = []struct {
+	name    string
+	snippet string
+	doc     string
+}{
+	{"test", `test "${1:name}"`, "Start a test. Tags follow the name: `test \"name\" @smoke`"},
+	{"skip", `skip test "${1:name}"`, "Skip the test that follows"},
+	{"only", `only test "${1:name}"`, "Run only this test and others marked only"},
+	{"define", "define ${1:name}(${2})\n  $0\nend", "Define a procedure: `define name(param, ...)` up to `end`"},
+	{"end", "end", "End a procedure"},
+	{"call", "call ${1:name}", "Run a procedure's steps: `call name arg ...`"},
+	{"import", `import "${1:path}"`, "Load procedures, variables and hooks from another file, relative to this one"},
+	{"include", `include "${1:path}"`, "Same as import"},
+	{"before_all", "before_all", "Steps run once per file, before the first test"},
+	{"before_each", "before_each", "Steps run before every test in the file"},
+	{"after_each", "after_each", "Steps run after every test in the file, even when it fails"},
+	{"after_all", "after_all", "Steps run once per file, after the last test"},
+	{"examples:", "examples:", "Run the test once per table row, or per row of a CSV or JSON file: `examples: \"users.csv\"`"},
+}
+
+// diagnostics checks the document with the tree parser, the step parser and
+// the lint rules that need only this file
+func (d *document) diagnostics() []Diagnostic {
+	diags := []Diagnostic{}
+	if d.err != nil {
+		return append(diags, d.parseErrors(d.err)...)
+	}
+
+	// Imports are read from disk, relative to the document
+	if _, err := parser.New().ParseSource(d.path, []byte(d.text)); err != nil {
+		diags = append(diags, d.parseErrors(err)...)
+	}
+
+	var rules []*lint.Rule
+	for _, rule := range lint.Rules() {
+		// Whether a procedure is used depends on every file in the project
+		if rule != lint.UnusedProcedure {
+			rules = append(rules, rule)
+		}
+	}
+	for _, diag := range lint.Run([]*ast.File{d.file}, rules) {
+		severity := SeverityError
+		if diag.Severity == lint.Warning {
+			severity = SeverityWarning
+		}
+		diags = append(diags, Diagnostic{
+			Range:    d.wordRange(diag.Line, diag.Column),
+			Severity: severity,
+			Code:     diag.Rule,
+			Source:   "testit",
+			Message:  diag.Message,
+		})
+	}
+	return diags
+}
+
+func (d *document) parseErrors(err error) []Diagnostic {
+	var list parser.ErrorList
+	if !errors.As(err, &list) {
+		list = parser.ErrorList{{Line: 1, Msg: err.Error()}}
+	}
+
+	var diags []Diagnostic
+	for _, e := range list {
+		// Errors in imported files are reported on the import line too
+		if e.File != "" && e.File != d.path {
+			continue
+		}
+		diags = append(diags, Diagnostic{
+			Range:    d.wordRange(e.Line, e.Column),
+			Severity: SeverityError,
+			Code:     lint.SyntaxRule,
+			Source:   "testit",
+			Message:  e.Msg,
+		})
+	}
+	return diags
+}
+
+// completion offers actions and keywords for the first word of a line, and
+// procedure names after call
+func (s *Server) completion(d *document, pos Position) []CompletionItem {
+	items := []CompletionItem{}
+	if pos.Line >= len(d.lines) {
+		return items
+	}
+	line := d.lines[pos.Line]
+	prefix := strings.TrimLeft(line[:d.byteColumn(pos)], " \t")
+	words := strings.Fields(prefix)
+
+	switch {
+	case len(words) == 0 || len(words) == 1 && !strings.HasSuffix(prefix, " "):
+		for _, spec := range parser.Actions() {
+			items = append(items, CompletionItem{
+				Label:            spec.Name,
+				Kind:             CompletionKindFunction,
+				Detail:           spec.Usage(),
+				Documentation:    markdown(spec.Doc),
+				InsertText:       actionSnippet(spec),
+				InsertTextFormat: InsertTextFormatSnippet,
+			})
+		}
+		for _, kw := range keywords {
+			items = append(items, CompletionItem{
+				Label:            kw.name,
+				Kind:             CompletionKindKeyword,
+				Documentation:    markdown(kw.doc),
+				InsertText:       kw.snippet,
+				InsertTextFormat: InsertTextFormatSnippet,
+			})
+		}
+
+	case words[0] == "call" && (len(words) == 1 || len(words) == 2 && !strings.HasSuffix(prefix, " ")):
+		for name, proc := range s.procedures(d) {
+			snippet := name
+			for i, param := range proc.def.Params {
+				snippet += fmt.Sprintf(" ${%d:%s}", i+1, param)
+			}
+			items = append(items, CompletionItem{
+				Label:            name,
+				Kind:             CompletionKindFunction,
+				Detail:           proc.signature(),
+				Documentation:    markdown(proc.location()),
+				InsertText:       snippet,
+				InsertTextFormat: InsertTextFormatSnippet,
+			})
+		}
+	}
+	return items
+}
+
+// actionSnippet writes an action with placeholders for its required arguments
+func actionSnippet(spec parser.ActionSpec) string {
+	snippet := spec.Name
+	n := 0
+	for _, arg := range spec.Args {
+		if strings.HasSuffix(arg, "?") {
+			break
+		}
+		n++
+		snippet += fmt.Sprintf(" ${%d:%s}", n, arg)
+	}
+	return snippet
+}
+
+// hover documents the action, keyword or called procedure under pos
+func (s *Server) hover(d *document, pos Position) *Hover {
+	line, i, ok := d.tokenAt(pos)
+	if !ok {
+		return nil
+	}
+	tok := line.Tokens[i]
+	var text string
+
+	switch {
+	case i == 1 && line.Tokens[0].Value == "call":
+		proc, ok := s.procedures(d)[tok.Value]
+		if !ok {
+			return nil
+		}
+		text = "```\n" + proc.signature() + "\n```\n\n" + proc.location()
+	case i == 0 || i == 1 && tok.Value == "test" && (line.Tokens[0].Value == "skip" || line.Tokens[0].Value == "only"):
+		if spec, ok := parser.LookupAction(tok.Value); ok {
+			text = "```\n" + spec.Usage() + "\n```\n\n" + spec.Doc
+			break
+		}
+		for _, kw := range keywords {
+			if kw.name == tok.Value {
+				text = kw.doc
+			}
+		}
+	}
+	if text == "" {
+		return nil
+	}
+
+	r := d.tokenRange(tok)
+	return &Hover{Contents: *markdown(text), Range: &r}
+}
+
+// definition finds where a called procedure is defined, or the file an
+// import names
+func (s *Server) definition(d *document, pos Position) *Location {
+	line, i, ok := d.tokenAt(pos)
+	if !ok || i != 1 {
+		return nil
+	}
+	tok := line.Tokens[1]
+
+	switch line.Tokens[0].Value {
+	case "call":
+		proc, ok := s.procedures(d)[tok.Value]
+		if !ok {
+			return nil
+		}
+		start := Position{Line: proc.def.NamePos.Line - 1, Character: proc.def.NamePos.Column - 1}
+		if doc, ok := s.docs[pathToURI(proc.file.Name)]; ok {
+			start = doc.position(proc.def.NamePos.Line, proc.def.NamePos.Column)
+		}
+		end := start
+		end.Character += utf16Len(proc.def.Name)
+		return &Location{URI: pathToURI(proc.file.Name), Range: Range{Start: start, End: end}}
+
+	case "import", "include":
+		path := resolve(d.path, tok.Value)
+		if _, err := os.Stat(path); err != nil {
+			return nil
+		}
+		return &Location{URI: pathToURI(path)}
+	}
+	return nil
+}
+
+// symbols lists the tests, hooks and procedures of the document
+func (d *document) symbols() []DocumentSymbol {
+	symbols := []DocumentSymbol{}
+	if d.file == nil {
+		return symbols
+	}
+	for _, stmt := range d.file.Stmts {
+		sym := DocumentSymbol{Range: d.lineRange(stmt.Pos().Line, stmt.EndLine())}
+		sym.SelectionRange = d.lineRange(stmt.Pos().Line, stmt.Pos().Line)
+
+		switch n := stmt.(type) {
+		case *ast.Test:
+			if n.Name == "" {
+				continue
+			}
+			sym.Name = n.Name
+			sym.Kind = SymbolKindMethod
+			var details []string
+			if n.Skip {
+				details = append(details, "skip")
+			}
+			if n.Only {
+				details = append(details, "only")
+			}
+			for _, tag := range n.Tags {
+				details = append(details, "@"+tag)
+			}
+			sym.Detail = strings.Join(details, " ")
+		case *ast.Hook:
+			sym.Name = n.Kind
+			sym.Kind = SymbolKindEvent
+		case *ast.Define:
+			if n.Name == "" {
+				continue
+			}
+			sym.Name = n.Name
+			sym.Kind = SymbolKindFunction
+			sym.Detail = "(" + strings.Join(n.Params, ", ") + ")"
+			sym.SelectionRange.Start = d.position(n.NamePos.Line, n.NamePos.Column)
+			sym.SelectionRange.End = sym.SelectionRange.Start
+			sym.SelectionRange.End.Character += utf16Len(n.Name)
+		default:
+			continue
+		}
+		symbols = append(symbols, sym)
+	}
+	return symbols
+}
+
+type procedure struct {
+	def  *ast.Define
+	file *ast.File
+}
+
+func (p procedure) signature() string {
+	return "define " + p.def.Name + "(" + strings.Join(p.def.Params, ", ") + ")"
+}
+
+func (p procedure) location() string {
+	return fmt.Sprintf("Defined at %s:%d", filepath.Base(p.file.Name), p.def.NamePos.Line)
+}
+
+// procedures returns the procedures the document can call: its own and those
+// of the files it imports, directly or not. Open documents are read from the
+// editor, other files from disk.
+func (s *Server) procedures(d *document) map[string]procedure {
+	procs := make(map[string]procedure)
+	seen := make(map[string]bool)
+
+	var visit func(file *ast.File)
+	visit = func(file *ast.File) {
+		if file == nil || seen[file.Name] {
+			return
+		}
+		seen[file.Name] = true
+		for _, stmt := range file.Stmts {
+			switch n := stmt.(type) {
+			case *ast.Define:
+				if _, ok := procs[n.Name]; !ok && n.Name != "" {
+					procs[n.Name] = procedure{def: n, file: file}
+				}
+			case *ast.Import:
+				visit(s.load(resolve(file.Name, n.Path.Value)))
+			}
+		}
+	}
+	visit(d.file)
+	return procs
+}
+
+// load parses a file, preferring the editor's copy when it is open
+func (s *Server) load(path string) *ast.File {
+	if doc, ok := s.docs[pathToURI(path)]; ok {
+		return doc.file
+	}
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	file, _ := ast.Parse(path, src)
+	return file
+}
+
+// resolve returns the path of an import, which is relative to the importing
+// file
+func resolve(from, path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(filepath.Dir(from), path)
+}
+
+func markdown(text string) *MarkupContent {
+	return &MarkupContent{Kind: "markdown", Value: text}
+}
+
+// tokenAt returns the lexed line holding the token under pos, and the
+// token's index
+func (d *document) tokenAt(pos Position) (parser.Line, int, bool) {
+	column := d.byteColumn(pos) + 1
+	for _, line := range d.lexed {
+		for i, tok := range line.Tokens {
+			if tok.Line == pos.Line+1 && tok.Column <= column && column <= tok.Column+tok.End-tok.Offset {
+				return line, i, true
+			}
+		}
+	}
+	return parser.Line{}, 0, false
+}
+
+func (d *document) tokenRange(tok parser.Token) Range {
+	start := d.position(tok.Line, tok.Column)
+	end := d.position(tok.Line, tok.Column+tok.End-tok.Offset)
+	return Range{Start: start, End: end}
+}
+
+// wordRange is the range of the word at a 1-based line and column, or the
+// whole line when column is 0
+func (d *document) wordRange(line, column int) Range {
+	if line < 1 || line > len(d.lines) {
+		return Range{}
+	}
+	text := d.lines[line-1]
+	if column < 1 || column > len(text) {
+		column = len(text) - len(strings.TrimLeft(text, " \t")) + 1
+	}
+	end := strings.IndexAny(text[column-1:], " \t")
+	if end <= 0 {
+		end = len(text)
+	} else {
+		end += column - 1
+	}
+	return Range{Start: d.position(line, column), End: d.position(line, end+1)}
+}
+
+// lineRange covers whole lines, 1-based
+func (d *document) lineRange(first, last int) Range {
+	if last > len(d.lines) {
+		last = len(d.lines)
+	}
+	end := Position{Line: last - 1}
+	if last >= 1 {
+		end.Character = utf16Len(d.lines[last-1])
+	}
+	return Range{Start: Position{Line: first - 1}, End: end}
+}
+
+// position converts a 1-based line and byte column to an LSP position
+func (d *document) position(line, column int) Position {
+	pos := Position{Line: line - 1}
+	if line < 1 || line > len(d.lines) {
+		return pos
+	}
+	text := d.lines[line-1]
+	if column-1 > len(text) {
+		column = len(text) + 1
+	}
+	if column > 1 {
+		pos.Character = utf16Len(text[:column-1])
+	}
+	return pos
+}
+
+// byteColumn converts the character of an LSP position, in UTF-16 code
+// units, to a byte offset in its line
+func (d *document) byteColumn(pos Position) int {
+	if pos.Line < 0 || pos.Line >= len(d.lines) {
+		return 0
+	}
+	text := d.lines[pos.Line]
+	units := 0
+	for i, r := range text {
+		if units >= pos.Character {
+			return i
+		}
+		units += utf16RuneLen(r)
+	}
+	return len(text)
+}
+
+func utf16Len(s string) int {
+	n := 0
+	for _, r := range s {
+		n += utf16RuneLen(r)
+	}
+	return n
+}
+
+func utf16RuneLen(r rune) int {
+	if r >= 0x10000 && r <= utf8.MaxRune {
+		return 2
+	}
+	return 1
+}
@@ -0,0 +1,257 @@
+package lsp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// session sends messages to a server and returns what it wrote back
+func session(t *testing.T, messages ...map[string]interface{}) []map[string]interface{} {
+	t.Helper()
+	var in bytes.Buffer
+	for i, msg := range messages {
+		msg["jsonrpc"] = "2.0"
+		if _, ok := msg["id"]; !ok && !strings.HasPrefix(msg["method"].(string), "textDocument/did") {
+			msg["id"] = i + 1
+		}
+		if err := writeMessage(&in, msg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := NewServer(&in, &out).Run(); err != nil {
+		t.Fatalf("Run() error = %v", err)
+	}
+
+	var replies []map[string]interface{}
+	r := bufio.NewReader(&out)
+	for {
+		body, err := readMessage(r)
+		if err != nil {
+			break
+		}
+		var reply map[string]interface{}
+		if err := json.Unmarshal(body, &reply); err != nil {
+			t.Fatal(err)
+		}
+		replies = append(replies, reply)
+	}
+	return replies
+}
+
+func open(uri, text string) map[string]interface{} {
+	return map[string]interface{}{
+		"method": "textDocument/didOpen",
+		"params": map[string]interface{}{"textDocument": map[string]interface{}{"uri": uri, "version": 1, "text": text}},
+	}
+}
+
+func at(method, uri string, line, character int) map[string]interface{} {
+	return map[string]interface{}{
+		"method": method,
+		"params": map[string]interface{}{
+			"textDocument": map[string]interface{}{"uri": uri},
+			"position":     map[string]interface{}{"line": line, "character": character},
+		},
+	}
+}
+
+// result returns the result of the reply to request id
+func result(t *testing.T, replies []map[string]interface{}, id int) interface{} {
+	t.Helper()
+	for _, reply := range replies {
+		if n, ok := reply["id"].(float64); ok && int(n) == id {
+			if reply["error"] != nil {
+				t.Fatalf("Request %d failed: %v", id, reply["error"])
+			}
+			return reply["result"]
+		}
+	}
+	t.Fatalf("No reply to request %d", id)
+	return nil
+}
+
+func diagnostics(t *testing.T, replies []map[string]interface{}) []interface{} {
+	t.Helper()
+	for _, reply := range replies {
+		if reply["method"] == "textDocument/publishDiagnostics" {
+			return reply["params"].(map[string]interface{})["diagnostics"].([]interface{})
+		}
+	}
+	t.Fatal("No diagnostics published")
+	return nil
+}
+
+func TestInitialize(t *testing.T) {
+	replies := session(t,
+		map[string]interface{}{"method": "initialize", "params": map[string]interface{}{}},
+		map[string]interface{}{"method": "shutdown"},
+		map[string]interface{}{"method": "unknown/method"},
+	)
+	caps := result(t, replies, 1).(map[string]interface{})["capabilities"].(map[string]interface{})
+	for _, name := range []string{"hoverProvider", "definitionProvider", "documentSymbolProvider", "completionProvider"} {
+		if caps[name] == nil {
+			t.Errorf("Missing capability %s", name)
+		}
+	}
+	if replies[2]["error"].(map[string]interface{})["code"].(float64) != codeMethodNotFound {
+		t.Errorf("Unknown method reply = %v", replies[2])
+	}
+}
+
+func TestDiagnostics(t *testing.T) {
+	src := `test "Login"
+  click
+  navigate https://example.com
+  screenshot
+  call missing
+test "Login"
+  wait_for #app
+`
+	replies := session(t, open("file:///tmp/login.test", src))
+	var got []string
+	for _, d := range diagnostics(t, replies) {
+		d := d.(map[string]interface{})
+		start := d["range"].(map[string]interface{})["start"].(map[string]interface{})
+		got = append(got, strings.Join([]string{
+			d["code"].(string),
+			string(rune('0' + int(start["line"].(float64)))),
+			d["message"].(string),
+		}, " "))
+	}
+	want := []string{
+		"syntax 1 click requires a selector",
+		"syntax 4 undefined procedure: missing",
+		"screenshot-after-navigate 3 screenshot right after navigate; add a wait_for so the page has rendered",
+		`duplicate-test-name 5 duplicate test name "Login", also used at /tmp/login.test:1:1`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Got diagnostics:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	replies = session(t, open("file:///tmp/broken.test", "test \"Unclosed\n"))
+	if d := diagnostics(t, replies); len(d) != 1 || !strings.Contains(d[0].(map[string]interface{})["message"].(string), "unterminated string") {
+		t.Errorf("Got diagnostics %v", d)
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	src := "define login(user, pass)\n  type #user ${user}\nend\ntest \"a\"\n  wa\n  call \n"
+	uri := "file:///tmp/a.test"
+	replies := session(t, open(uri, src),
+		at("textDocument/completion", uri, 4, 4),
+		at("textDocument/completion", uri, 5, 7),
+		at("textDocument/completion", uri, 1, 10),
+	)
+
+	labels := func(id int) map[string]map[string]interface{} {
+		items := make(map[string]map[string]interface{})
+		for _, item := range result(t, replies, id).([]interface{}) {
+			item := item.(map[string]interface{})
+			items[item["label"].(string)] = item
+		}
+		return items
+	}
+
+	actions := labels(2)
+	if item, ok := actions["wait_for_text"]; !ok || item["insertText"] != "wait_for_text ${1:selector} ${2:text}" {
+		t.Errorf("wait_for_text completion = %v", item)
+	}
+	if _, ok := actions["before_each"]; !ok {
+		t.Error("Missing keyword completions")
+	}
+
+	procs := labels(3)
+	if item, ok := procs["login"]; len(procs) != 1 || !ok || item["insertText"] != "login ${1:user} ${2:pass}" {
+		t.Errorf("call completions = %v", procs)
+	}
+
+	if items := labels(4); len(items) != 0 {
+		t.Errorf("Expected no completions inside arguments, got %d", len(items))
+	}
+}
+
+func TestHoverAndDefinition(t *testing.T) {
+	dir := t.TempDir()
+	lib := filepath.Join(dir, "lib.test")
+	if err := os.WriteFile(lib, []byte("define login(user)\n  type #user ${user}\nend\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	src := "import \"lib.test\"\n\ntest \"a\"\n  click #go\n  call login admin\n"
+	uri := pathToURI(filepath.Join(dir, "a.test"))
+
+	replies := session(t, open(uri, src),
+		at("textDocument/hover", uri, 3, 3),
+		at("textDocument/hover", uri, 4, 8),
+		at("textDocument/definition", uri, 4, 8),
+		at("textDocument/definition", uri, 0, 10),
+		at("textDocument/hover", uri, 3, 9),
+	)
+
+	if d := diagnostics(t, replies); len(d) != 0 {
+		t.Errorf("Expected no diagnostics, got %v", d)
+	}
+
+	hover := result(t, replies, 2).(map[string]interface{})["contents"].(map[string]interface{})["value"].(string)
+	if !strings.Contains(hover, "click selector") || !strings.Contains(hover, "Click an element") {
+		t.Errorf("click hover = %q", hover)
+	}
+	hover = result(t, replies, 3).(map[string]interface{})["contents"].(map[string]interface{})["value"].(string)
+	if !strings.Contains(hover, "define login(user)") || !strings.Contains(hover, "lib.test:1") {
+		t.Errorf("call hover = %q", hover)
+	}
+
+	loc := result(t, replies, 4).(map[string]interface{})
+	start := loc["range"].(map[string]interface{})["start"].(map[string]interface{})
+	if loc["uri"] != pathToURI(lib) || start["line"].(float64) != 0 || start["character"].(float64) != 7 {
+		t.Errorf("call definition = %v", loc)
+	}
+	if loc := result(t, replies, 5).(map[string]interface{}); loc["uri"] != pathToURI(lib) {
+		t.Errorf("import definition = %v", loc)
+	}
+	if hover := result(t, replies, 6); hover != nil {
+		t.Errorf("Expected no hover on a selector, got %v", hover)
+	}
+}
+
+func TestDocumentSymbols(t *testing.T) {
+	src := "before_each\n  navigate /\n\ndefine login(user)\n  type #u ${user}\nend\n\nskip test \"Login\" @smoke\n  call login me\n"
+	uri := "file:///tmp/s.test"
+	replies := session(t, open(uri, src), map[string]interface{}{
+		"method": "textDocument/documentSymbol",
+		"params": map[string]interface{}{"textDocument": map[string]interface{}{"uri": uri}},
+	})
+
+	var got []string
+	for _, sym := range result(t, replies, 2).([]interface{}) {
+		sym := sym.(map[string]interface{})
+		r := sym["range"].(map[string]interface{})
+		detail, _ := sym["detail"].(string)
+		got = append(got, strings.Join([]string{
+			sym["name"].(string),
+			detail,
+			string(rune('0' + int(r["start"].(map[string]interface{})["line"].(float64)))),
+			string(rune('0' + int(r["end"].(map[string]interface{})["line"].(float64)))),
+		}, "|"))
+	}
+	want := []string{"before_each||0|1", "login|(user)|3|5", "Login|skip @smoke|7|8"}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("Got symbols:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestUTF16Positions(t *testing.T) {
+	doc := newDocument("file:///tmp/u.test", "test \"é😀\"\n  click #😀x\n")
+	if got := doc.position(2, 14); got.Character != 11 {
+		t.Errorf("position() character = %d, want 11", got.Character)
+	}
+	if got := doc.byteColumn(Position{Line: 1, Character: 11}); got != 13 {
+		t.Errorf("byteColumn() = %d, want 13", got)
+	}
+}
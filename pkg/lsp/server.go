@@ -0,0 +1,222 @@
+// Package lsp is a language server for .test files. It speaks the Language
+// Server Protocol over a pair of streams, usually standard input and output,
+// and answers from the same parser and action registry the runner uses, so
+// editors never drift from what testit accepts.
+package lsp
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"github.com/kidandcat/testit/pkg/dsl/ast"
+	"github.com/kidandcat/testit/pkg/parser"
+)
+
+// Server serves one client. Documents are synced in full on every change.
+type Server struct {
+	in       *bufio.Reader
+	out      io.Writer
+	docs     map[string]*document // Open documents by URI
+	shutdown bool
+}
+
+func NewServer(in io.Reader, out io.Writer) *Server {
+	return &Server{
+		in:   bufio.NewReader(in),
+		out:  out,
+		docs: make(map[string]*document),
+	}
+}
+
+// Run serves requests until the client sends exit or closes the input
+func (s *Server) Run() error {
+	for {
+		body, err := readMessage(s.in)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		var req request
+		if err := json.Unmarshal(body, &req); err != nil {
+			s.reply(nil, nil, &responseError{Code: codeParseError, Message: err.Error()})
+			continue
+		}
+		if req.Method == "exit" {
+			if !s.shutdown {
+				return errors.New("exit without shutdown")
+			}
+			return nil
+		}
+
+		result, err := s.handle(req)
+		if req.ID == nil {
+			continue
+		}
+		if err != nil {
+			var rpcErr *responseError
+			if !errors.As(err, &rpcErr) {
+				rpcErr = &responseError{Code: codeInvalidParams, Message: err.Error()}
+			}
+			s.reply(req.ID, nil, rpcErr)
+			continue
+		}
+		s.reply(req.ID, result, nil)
+	}
+}
+
+func (s *Server) handle(req request) (interface{}, error) {
+	switch req.Method {
+	case "initialize":
+		return map[string]interface{}{
+			"capabilities": map[string]interface{}{
+				"textDocumentSync":       1, // Full
+				"completionProvider":     map[string]interface{}{"triggerCharacters": []string{" "}},
+				"hoverProvider":          true,
+				"definitionProvider":     true,
+				"documentSymbolProvider": true,
+			},
+			"serverInfo": map[string]string{"name": "testit"},
+		}, nil
+	case "shutdown":
+		s.shutdown = true
+		return nil, nil
+
+	case "textDocument/didOpen":
+		var params DidOpenTextDocumentParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, err
+		}
+		s.open(params.TextDocument.URI, params.TextDocument.Text)
+		return nil, nil
+	case "textDocument/didChange":
+		var params DidChangeTextDocumentParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, err
+		}
+		if n := len(params.ContentChanges); n > 0 {
+			s.open(params.TextDocument.URI, params.ContentChanges[n-1].Text)
+		}
+		return nil, nil
+	case "textDocument/didClose":
+		var params DidCloseTextDocumentParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, err
+		}
+		delete(s.docs, params.TextDocument.URI)
+		s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{URI: params.TextDocument.URI, Diagnostics: []Diagnostic{}})
+		return nil, nil
+
+	case "textDocument/completion":
+		doc, pos, err := s.position(req.Params)
+		if err != nil {
+			return nil, err
+		}
+		return s.completion(doc, pos), nil
+	case "textDocument/hover":
+		doc, pos, err := s.position(req.Params)
+		if err != nil {
+			return nil, err
+		}
+		if hover := s.hover(doc, pos); hover != nil {
+			return hover, nil
+		}
+		return nil, nil
+	case "textDocument/definition":
+		doc, pos, err := s.position(req.Params)
+		if err != nil {
+			return nil, err
+		}
+		if loc := s.definition(doc, pos); loc != nil {
+			return loc, nil
+		}
+		return nil, nil
+	case "textDocument/documentSymbol":
+		var params DocumentSymbolParams
+		if err := json.Unmarshal(req.Params, &params); err != nil {
+			return nil, err
+		}
+		doc, ok := s.docs[params.TextDocument.URI]
+		if !ok {
+			return []DocumentSymbol{}, nil
+		}
+		return doc.symbols(), nil
+	}
+
+	if strings.HasPrefix(req.Method, "$/") || req.Method == "initialized" {
+		return nil, nil
+	}
+	return nil, &responseError{Code: codeMethodNotFound, Message: "method not found: " + req.Method}
+}
+
+// open stores the text of a document and publishes its diagnostics
+func (s *Server) open(uri, text string) {
+	doc := newDocument(uri, text)
+	s.docs[uri] = doc
+	s.notify("textDocument/publishDiagnostics", PublishDiagnosticsParams{URI: uri, Diagnostics: doc.diagnostics()})
+}
+
+func (s *Server) position(raw json.RawMessage) (*document, Position, error) {
+	var params TextDocumentPositionParams
+	if err := json.Unmarshal(raw, &params); err != nil {
+		return nil, Position{}, err
+	}
+	doc, ok := s.docs[params.TextDocument.URI]
+	if !ok {
+		return nil, Position{}, &responseError{Code: codeInvalidParams, Message: "document is not open: " + params.TextDocument.URI}
+	}
+	return doc, params.Position, nil
+}
+
+func (s *Server) reply(id *json.RawMessage, result interface{}, err *responseError) {
+	writeMessage(s.out, response{JSONRPC: "2.0", ID: id, Result: result, Error: err})
+}
+
+func (s *Server) notify(method string, params interface{}) {
+	writeMessage(s.out, notification{JSONRPC: "2.0", Method: method, Params: params})
+}
+
+// document is an open file as the editor has it, which may not be saved
+type document struct {
+	uri   string
+	path  string
+	text  string
+	lines []string
+	lexed []parser.Line
+	// file is the tree of the document, as much of it as parses
+	file *ast.File
+	// err holds the errors from building file
+	err error
+}
+
+func newDocument(uri, text string) *document {
+	doc := &document{uri: uri, path: uriToPath(uri), text: text}
+	doc.lines = strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	doc.lexed, _ = parser.Lex(text)
+	doc.file, doc.err = ast.Parse(doc.path, []byte(text))
+	return doc
+}
+
+// uriToPath returns the file path of a file: URI, or the URI itself for other
+// schemes, so unsaved buffers still get a name in messages
+func uriToPath(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != "file" {
+		return uri
+	}
+	return filepath.FromSlash(u.Path)
+}
+
+func pathToURI(path string) string {
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
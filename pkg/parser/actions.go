@@ -0,0 +1,235 @@
+package parser
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/kidandcat/testit/pkg/fasttest"
+)
+
+// ActionSpec describes a step action: its arguments and how they map onto a
+// fasttest.Step. Editors use the specs for completion and hover docs.
+type ActionSpec struct {
+	Name string
+	// Args names the arguments in order. Names ending in "?" are optional.
+	Args []string
+	// Rest means the last argument takes the rest of the line, so it may
+	// contain spaces without quotes
+	Rest bool
+	// Variadic means any number of arguments may follow those in Args.
+	// build gets them all, one per token.
+	Variadic bool
+	Doc      string
+	// requires is the error for missing arguments
+	requires string
+	// build makes the step from the arguments. When nil the first argument
+	// is the Target and the second the Value.
+	build func(args []string, lineNum int) (*fasttest.Step, error)
+}
+
+// Usage returns the action as it would be written, e.g. "type selector text"
+func (a ActionSpec) Usage() string {
+	usage := strings.TrimSpace(a.Name + " " + strings.Join(a.Args, " "))
+	if a.Variadic {
+		usage += " ..."
+	}
+	return usage
+}
+
+func (a ActionSpec) required() int {
+	n := 0
+	for _, arg := range a.Args {
+		if !strings.HasSuffix(arg, "?") {
+			n++
+		}
+	}
+	return n
+}
+
+var actions = map[string]ActionSpec{}
+
+func registerAction(spec ActionSpec) {
+	actions[spec.Name] = spec
+}
+
+// LookupAction returns the spec of a step action
+func LookupAction(name string) (ActionSpec, bool) {
+	spec, ok := actions[name]
+	return spec, ok
+}
+
+// Actions returns the specs of every step action, sorted by name
+func Actions() []ActionSpec {
+	specs := make([]ActionSpec, 0, len(actions))
+	for _, spec := range actions {
+		specs = append(specs, spec)
+	}
+	sort.Slice(specs, func(i, j int) bool { return specs[i].Name < specs[j].Name })
+	return specs
+}
+
+func init() {
+	registerAction(ActionSpec{Name: "navigate", Args: []string{"url"}, Rest: true, requires: "a URL",
+		Doc: "Open a URL in the browser"})
+	registerAction(ActionSpec{Name: "click", Args: []string{"selector"}, Rest: true, requires: "a selector",
+		Doc: "Click an element"})
+	registerAction(ActionSpec{Name: "type", Args: []string{"selector", "text"}, Rest: true, requires: "a selector and value",
+		Doc: "Type text into an input"})
+	registerAction(ActionSpec{Name: "clear", Args: []string{"selector"}, Rest: true, requires: "a selector",
+		Doc: "Empty an input, textarea or editable element"})
+	registerAction(ActionSpec{Name: "type_focused", Args: []string{"text"}, Rest: true, requires: "text",
+		Doc: "Type text into the focused element",
+		build: func(args []string, lineNum int) (*fasttest.Step, error) {
+			return &fasttest.Step{Action: "type_focused", Value: args[0]}, nil
+		}})
+	registerAction(ActionSpec{Name: "press", Args: []string{"keys"}, Rest: true, requires: "a key",
+		Doc: "Press keys or shortcuts in turn, e.g. Enter, Control+A or ArrowDown ArrowDown Enter",
+		build: func(args []string, lineNum int) (*fasttest.Step, error) {
+			return &fasttest.Step{Action: "press", Target: args[0]}, nil
+		}})
+	registerAction(ActionSpec{Name: "key_down", Args: []string{"key"}, requires: "a key",
+		Doc: "Hold a key down; held modifiers apply to the steps that follow"})
+	registerAction(ActionSpec{Name: "key_up", Args: []string{"key"}, requires: "a key",
+		Doc: "Release a key held with key_down"})
+	registerAction(ActionSpec{Name: "mock", Args: []string{"pattern"}, Variadic: true, requires: "a URL pattern",
+		Doc:   "Answer matching requests without the server: mock [METHOD] pattern [with file PATH | with body TEXT] [status CODE] [type CONTENT-TYPE]",
+		build: routeStep("mock")})
+	registerAction(ActionSpec{Name: "block", Args: []string{"pattern"}, Variadic: true, requires: "a URL pattern",
+		Doc:   "Fail matching requests: block [METHOD] pattern",
+		build: routeStep("block")})
+	registerAction(ActionSpec{Name: "delay", Args: []string{"pattern", "duration"}, Variadic: true, requires: "a URL pattern and a duration",
+		Doc:   "Hold matching requests for a while: delay [METHOD] pattern DURATION",
+		build: routeStep("delay")})
+	registerAction(ActionSpec{Name: "wait_for_request", Args: []string{"pattern"}, Variadic: true, requires: "a URL pattern",
+		Doc: "Wait for a request matching [METHOD] pattern, which later request assertions check",
+		build: func(args []string, lineNum int) (*fasttest.Step, error) {
+			target, rest, err := fasttest.ParseRequestPattern("wait_for_request", args)
+			if err != nil {
+				return nil, errorf(lineNum, "%v", err)
+			}
+			if len(rest) > 0 {
+				return nil, errorf(lineNum, "too many arguments, expected wait_for_request [METHOD] pattern")
+			}
+			return &fasttest.Step{Action: "wait_for_request", Target: target}, nil
+		}})
+	registerAction(ActionSpec{Name: "assert_request_body", Args: []string{"path", "expected"}, Rest: true, requires: "a JSON path and a value",
+		Doc: "Assert the JSON value at a path like $.items[0].name in the body of the last waited request",
+		build: func(args []string, lineNum int) (*fasttest.Step, error) {
+			return &fasttest.Step{Action: "assert_request_body", Target: args[0], Value: args[1]}, nil
+		}})
+	registerAction(ActionSpec{Name: "assert_response_status", Args: []string{"pattern", "status"}, Variadic: true, requires: "a URL pattern and a status",
+		Doc: "Assert the status, like 201 or 2xx, of the latest response to [METHOD] pattern",
+		build: func(args []string, lineNum int) (*fasttest.Step, error) {
+			target, rest, err := fasttest.ParseRequestPattern("assert_response_status", args)
+			if err != nil {
+				return nil, errorf(lineNum, "%v", err)
+			}
+			if len(rest) != 1 {
+				return nil, errorf(lineNum, "assert_response_status requires a URL pattern and a status")
+			}
+			return &fasttest.Step{Action: "assert_response_status", Target: target, Value: rest[0]}, nil
+		}})
+	registerAction(ActionSpec{Name: "ignore_console_error", Args: []string{"pattern"}, Rest: true, requires: "a pattern",
+		Doc: "Keep page errors whose message or URL matches a regular expression from failing the test"})
+	registerAction(ActionSpec{Name: "assert_console_error", Args: []string{"pattern"}, Rest: true, requires: "a pattern",
+		Doc: "Wait for a page error whose message or URL matches a regular expression; it no longer fails the test"})
+	registerAction(ActionSpec{Name: "assert_no_console_errors",
+		Doc: "Fail now if the page has reported errors that would fail the test",
+		build: func(args []string, lineNum int) (*fasttest.Step, error) {
+			return &fasttest.Step{Action: "assert_no_console_errors"}, nil
+		}})
+	registerAction(ActionSpec{Name: "upload", Args: []string{"selector", "path"}, Rest: true, requires: "a selector and file path",
+		Doc: "Set the file of an <input type=file>; the path is relative to the test file"})
+	registerAction(ActionSpec{Name: "expect_download", Args: []string{"name"}, Rest: true, requires: "a file name",
+		Doc: "Wait for a download with this file name, which may use * and ?, to finish"})
+	registerAction(ActionSpec{Name: "assert_download_size", Args: []string{"size"}, Rest: true, requires: "a size in bytes",
+		Doc: "Assert the size of the last expected download, e.g. 1024 or >0",
+		build: func(args []string, lineNum int) (*fasttest.Step, error) {
+			return &fasttest.Step{Action: "assert_download_size", Value: args[0]}, nil
+		}})
+	registerAction(ActionSpec{Name: "assert_download_contains", Args: []string{"text"}, Rest: true, requires: "text",
+		Doc: "Assert the last expected download contains text",
+		build: func(args []string, lineNum int) (*fasttest.Step, error) {
+			return &fasttest.Step{Action: "assert_download_contains", Value: args[0]}, nil
+		}})
+	registerAction(ActionSpec{Name: "wait_for", Args: []string{"selector"}, Rest: true, requires: "a selector",
+		Doc: "Wait for an element to be visible"})
+	registerAction(ActionSpec{Name: "wait_for_text", Args: []string{"selector", "text"}, Rest: true, requires: "a selector and text",
+		Doc: "Wait for an element to contain text"})
+	registerAction(ActionSpec{Name: "wait_for_url", Args: []string{"pattern"}, Rest: true, requires: "a URL pattern",
+		Doc: "Wait for the URL to contain a pattern"})
+	registerAction(ActionSpec{Name: "assert_text", Args: []string{"selector", "text"}, Rest: true, requires: "a selector and expected text",
+		Doc: "Assert an element's text equals the expected text"})
+	registerAction(ActionSpec{Name: "assert_text_contains", Args: []string{"selector", "text"}, Rest: true, requires: "a selector and text",
+		Doc: "Assert an element's text contains the given text"})
+	registerAction(ActionSpec{Name: "assert_element_exists", Args: []string{"selector"}, Rest: true, requires: "a selector",
+		Doc: "Assert an element exists"})
+	registerAction(ActionSpec{Name: "assert_element_not_exists", Args: []string{"selector"}, Rest: true, requires: "a selector",
+		Doc: "Assert an element does not exist"})
+	registerAction(ActionSpec{Name: "assert_url", Args: []string{"pattern"}, Rest: true, requires: "a URL pattern",
+		Doc: "Assert the URL contains a pattern"})
+	registerAction(ActionSpec{Name: "assert_title", Args: []string{"title"}, Rest: true, requires: "expected title",
+		Doc: "Assert the page title"})
+	registerAction(ActionSpec{Name: "assert_text_visible", Args: []string{"text"}, Rest: true, requires: "text to search for",
+		Doc: "Assert text is visible anywhere on the page",
+		build: func(args []string, lineNum int) (*fasttest.Step, error) {
+			return &fasttest.Step{Action: "assert_text_visible", Value: args[0]}, nil
+		}})
+	registerAction(ActionSpec{Name: "assert_attribute", Args: []string{"selector", "attribute", "value"}, Rest: true, requires: "selector, attribute name, and expected value",
+		Doc: "Assert an element's attribute value",
+		build: func(args []string, lineNum int) (*fasttest.Step, error) {
+			return &fasttest.Step{Action: "assert_attribute", Target: args[0] + "|" + args[1], Value: args[2]}, nil
+		}})
+	registerAction(ActionSpec{Name: "screenshot", Args: []string{"name?"}, Rest: true,
+		Doc: "Take a screenshot and compare it with its baseline"})
+	registerAction(ActionSpec{Name: "snapshot", Args: []string{"name?"}, Rest: true,
+		Doc: "Take an HTML snapshot and compare it with its baseline"})
+	registerAction(ActionSpec{Name: "select", Args: []string{"selector", "option"}, Rest: true, requires: "a selector and value",
+		Doc: "Select a dropdown option by value or label, or with label=, value= or index=N"})
+	registerAction(ActionSpec{Name: "check", Args: []string{"selector"}, Rest: true, requires: "a selector",
+		Doc: "Check a checkbox or radio button, unless it is already checked"})
+	registerAction(ActionSpec{Name: "uncheck", Args: []string{"selector"}, Rest: true, requires: "a selector",
+		Doc: "Uncheck a checkbox, unless it is already unchecked"})
+	registerAction(ActionSpec{Name: "hover", Args: []string{"selector"}, Rest: true, requires: "a selector",
+		Doc: "Move the mouse over an element, without clicking"})
+	registerAction(ActionSpec{Name: "double_click", Args: []string{"selector"}, Rest: true, requires: "a selector",
+		Doc: "Double-click an element"})
+	registerAction(ActionSpec{Name: "right_click", Args: []string{"selector"}, Rest: true, requires: "a selector",
+		Doc: "Right-click an element, opening its context menu"})
+	registerAction(ActionSpec{Name: "mouse_move", Args: []string{"x", "y"}, requires: "x and y coordinates",
+		Doc: "Move the mouse to a point of the viewport, dragging if a button is down"})
+	registerAction(ActionSpec{Name: "mouse_down", Args: []string{"button?"},
+		Doc: "Press a mouse button (left, middle or right) where the mouse is"})
+	registerAction(ActionSpec{Name: "mouse_up", Args: []string{"button?"},
+		Doc: "Release a mouse button (left, middle or right) where the mouse is"})
+	registerAction(ActionSpec{Name: "set", Args: []string{"name", "value"}, Rest: true, requires: "a variable name and value",
+		Doc: "Set a variable for ${name} interpolation",
+		build: func(args []string, lineNum int) (*fasttest.Step, error) {
+			if !fasttest.IsVarName(args[0]) {
+				return nil, errorf(lineNum, "invalid variable name: %s", args[0])
+			}
+			return &fasttest.Step{Action: "set", Target: args[0], Value: args[1]}, nil
+		}})
+	registerAction(ActionSpec{Name: "store_text", Args: []string{"selector", "name"}, requires: "a selector and variable name",
+		Doc: "Store an element's text in a variable",
+		build: func(args []string, lineNum int) (*fasttest.Step, error) {
+			if !fasttest.IsVarName(args[1]) {
+				return nil, errorf(lineNum, "invalid variable name: %s", args[1])
+			}
+			return &fasttest.Step{Action: "store_text", Target: args[0], Value: args[1]}, nil
+		}})
+	registerAction(ActionSpec{Name: "viewport", Args: []string{"width", "height"}, requires: "a width and height",
+		Doc: "Resize the viewport"})
+	registerAction(ActionSpec{Name: "device", Args: []string{"name"}, Rest: true, requires: "a device name",
+		Doc: "Emulate a device preset, e.g. \"iPhone 13\""})
+}
+
+func routeStep(action string) func(args []string, lineNum int) (*fasttest.Step, error) {
+	return func(args []string, lineNum int) (*fasttest.Step, error) {
+		step, err := fasttest.ParseRoute(action, args)
+		if err != nil {
+			return nil, errorf(lineNum, "%v", err)
+		}
+		return &step, nil
+	}
+}
@@ -0,0 +1,79 @@
+package parser
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Error is a problem found at a position in a .test file
+type Error struct {
+	File   string
+	Line   int
+	Column int
+	Msg    string
+}
+
+func (e *Error) Error() string {
+	switch {
+	case e.File != "" && e.Column > 0:
+		return fmt.Sprintf("%s:%d:%d: %s", e.File, e.Line, e.Column, e.Msg)
+	case e.File != "":
+		return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Msg)
+	}
+	return fmt.Sprintf("line %d: %s", e.Line, e.Msg)
+}
+
+// ErrorList is every error found while parsing a file, in the order found
+type ErrorList []*Error
+
+func (l ErrorList) Error() string {
+	switch len(l) {
+	case 0:
+		return "no errors"
+	case 1:
+		return l[0].Error()
+	}
+	return fmt.Sprintf("%s (and %d more errors)", l[0], len(l)-1)
+}
+
+// Err returns the list as an error, or nil if it is empty
+func (l ErrorList) Err() error {
+	if len(l) == 0 {
+		return nil
+	}
+	return l
+}
+
+// add records err at line, filling in whatever position it lacks
+func (l *ErrorList) add(err error, file string, line, column int) {
+	var list ErrorList
+	if errors.As(err, &list) {
+		for _, e := range list {
+			l.add(e, file, line, column)
+		}
+		return
+	}
+	var e *Error
+	if !errors.As(err, &e) {
+		e = &Error{Line: line, Msg: err.Error()}
+	}
+	if e.File == "" {
+		e.File = file
+	}
+	if e.Line == line && e.Column == 0 {
+		e.Column = column
+	}
+	*l = append(*l, e)
+}
+
+func errorf(line int, format string, args ...interface{}) *Error {
+	return &Error{Line: line, Msg: fmt.Sprintf(format, args...)}
+}
+
+// tokenErrorf is errorf for a problem with one token, reported at its column
+// rather than at the start of the line
+func tokenErrorf(tok Token, format string, args ...interface{}) *Error {
+	e := errorf(tok.Line, format, args...)
+	e.Column = tok.Column
+	return e
+}
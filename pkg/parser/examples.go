@@ -0,0 +1,209 @@
+package parser
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/kidandcat/testit/pkg/fasttest"
+)
+
+// examples is the data table of a test written with an examples: block.
+// The test runs once per row, with ${column} replaced by the row's values.
+type examples struct {
+	columns []string
+	rows    [][]string
+	line    int
+	// rowLines holds the line of each table row, for errors in its steps
+	rowLines []int
+	// source is the CSV or JSON file the rows came from, if any
+	source string
+	// failed is set when the file could not be loaded
+	failed bool
+}
+
+// parseExamplesHeader handles an "examples:" line. With a file name after
+// the colon the rows are loaded from that CSV or JSON file, resolved against
+// the directory of the test file; otherwise they follow as | table | rows.
+func parseExamplesHeader(line Line, filename string, lineNum int) (*examples, error) {
+	ex := &examples{line: lineNum}
+	if len(line.Tokens) > 2 || line.Tokens[0].Value != "examples:" {
+		return nil, errorf(lineNum, "expected examples: or examples: \"file\"")
+	}
+	if len(line.Tokens) == 1 {
+		return ex, nil
+	}
+	source := line.Tokens[1].Value
+	ex.source = source
+
+	if !filepath.IsAbs(source) && filename != "" {
+		source = filepath.Join(filepath.Dir(filename), source)
+	}
+	var err error
+	switch strings.ToLower(filepath.Ext(source)) {
+	case ".csv":
+		err = ex.loadCSV(source)
+	case ".json":
+		err = ex.loadJSON(source)
+	default:
+		err = fmt.Errorf("unsupported examples file format: %s", filepath.Ext(source))
+	}
+	if err != nil {
+		return nil, errorf(lineNum, "%v", err)
+	}
+	return ex, nil
+}
+
+// addRow adds a "| a | b |" table line. The first one holds the column names.
+func (ex *examples) addRow(line string, lineNum int) error {
+	if ex.source != "" {
+		return errorf(lineNum, "examples are already loaded from %s", ex.source)
+	}
+	cells := strings.Split(strings.TrimSpace(line), "|")
+	if len(cells) < 3 || cells[len(cells)-1] != "" {
+		return errorf(lineNum, "table rows must start and end with |")
+	}
+	cells = cells[1 : len(cells)-1]
+	for i := range cells {
+		cells[i] = strings.TrimSpace(cells[i])
+	}
+
+	if ex.columns == nil {
+		for _, column := range cells {
+			if !fasttest.IsVarName(column) {
+				return errorf(lineNum, "invalid column name: %q", column)
+			}
+		}
+		ex.columns = cells
+		return nil
+	}
+	if len(cells) != len(ex.columns) {
+		return errorf(lineNum, "row has %d cells, expected %d", len(cells), len(ex.columns))
+	}
+	ex.rows = append(ex.rows, cells)
+	ex.rowLines = append(ex.rowLines, lineNum)
+	return nil
+}
+
+func (ex *examples) loadCSV(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	if len(records) == 0 {
+		return fmt.Errorf("%s has no header row", path)
+	}
+	for _, column := range records[0] {
+		if !fasttest.IsVarName(strings.TrimSpace(column)) {
+			return fmt.Errorf("%s: invalid column name: %q", path, column)
+		}
+		ex.columns = append(ex.columns, strings.TrimSpace(column))
+	}
+	ex.rows = records[1:]
+	return nil
+}
+
+// loadJSON reads an array of objects. Columns are the keys of all objects,
+// sorted, since JSON objects have no order.
+func (ex *examples) loadJSON(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var objects []map[string]interface{}
+	if err := json.Unmarshal(data, &objects); err != nil {
+		return fmt.Errorf("failed to read %s: expected an array of objects: %v", path, err)
+	}
+
+	seen := make(map[string]bool)
+	for _, object := range objects {
+		for key := range object {
+			if !seen[key] {
+				if !fasttest.IsVarName(key) {
+					return fmt.Errorf("%s: invalid column name: %q", path, key)
+				}
+				seen[key] = true
+				ex.columns = append(ex.columns, key)
+			}
+		}
+	}
+	sort.Strings(ex.columns)
+
+	for _, object := range objects {
+		row := make([]string, len(ex.columns))
+		for i, column := range ex.columns {
+			switch value := object[column].(type) {
+			case nil:
+			case string:
+				row[i] = value
+			default:
+				row[i] = fmt.Sprint(value)
+			}
+		}
+		ex.rows = append(ex.rows, row)
+	}
+	return nil
+}
+
+// rowError places an error in the steps of a row at the row's line, or at
+// the examples: line for rows loaded from a file
+func (ex *examples) rowError(row int, step fasttest.Step, err error) *Error {
+	if ex.source != "" {
+		return errorf(ex.line, "row %d of %s: %v (in %s at line %d)", row+1, ex.source, err, step.Action, step.Line)
+	}
+	return errorf(ex.rowLines[row], "%v (in %s at line %d)", err, step.Action, step.Line)
+}
+
+// expand returns one test per row. Names that reference a column are
+// interpolated; other names get the row's values appended. Steps are
+// checked again once the row's values are in, so a value that is not valid
+// where it is used fails at its row.
+func (ex *examples) expand(test fasttest.Test) ([]fasttest.Test, error) {
+	if ex.failed {
+		return nil, nil
+	}
+	if len(ex.rows) == 0 {
+		return nil, errorf(ex.line, "examples for %q have no rows", test.Name)
+	}
+
+	var errs ErrorList
+	tests := make([]fasttest.Test, 0, len(ex.rows))
+	for r, row := range ex.rows {
+		values := make(map[string]string, len(ex.columns))
+		for i, column := range ex.columns {
+			values[column] = row[i]
+		}
+		lookup := func(name string) (string, bool) {
+			value, ok := values[name]
+			return value, ok
+		}
+
+		expanded := test
+		name, _ := fasttest.ExpandVars(test.Name, lookup)
+		if name == test.Name {
+			name = fmt.Sprintf("%s [%s]", test.Name, strings.Join(row, ", "))
+		}
+		expanded.Name = name
+		expanded.Steps = make([]fasttest.Step, len(test.Steps))
+		for i, step := range test.Steps {
+			step.Target, _ = fasttest.ExpandVars(step.Target, lookup)
+			step.Value, _ = fasttest.ExpandVars(step.Value, lookup)
+			if err := fasttest.CheckStep(step); err != nil {
+				errs = append(errs, ex.rowError(r, step, err))
+			}
+			expanded.Steps[i] = step
+		}
+		tests = append(tests, expanded)
+	}
+	return tests, errs.Err()
+}
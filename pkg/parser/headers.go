@@ -0,0 +1,156 @@
+package parser
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/kidandcat/testit/pkg/fasttest"
+)
+
+// The lines that give a file its structure are told apart from steps by
+// their first word. These functions are shared with the ast package, so the
+// formatter, linter and language server read files the way the parser does.
+
+var (
+	defineRe = regexp.MustCompile(`^define\s+([A-Za-z_][A-Za-z0-9_]*)\s*(?:\(([^)]*)\))?$`)
+	tagRe    = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+)
+
+// keyword returns the first word of a line, or "" if it is quoted or the
+// line has no tokens
+func keyword(l Line) string {
+	if len(l.Tokens) == 0 || l.Tokens[0].Quoted {
+		return ""
+	}
+	return l.Tokens[0].Value
+}
+
+// IsTestHeader reports whether a line starts a test, possibly with a skip or
+// only modifier in front
+func IsTestHeader(l Line) bool {
+	switch keyword(l) {
+	case "test":
+		return true
+	case "skip", "only":
+		return len(l.Tokens) > 1 && !l.Tokens[1].Quoted && l.Tokens[1].Value == "test"
+	}
+	return false
+}
+
+// IsHook reports whether a line opens a lifecycle hook block
+func IsHook(l Line) bool {
+	switch keyword(l) {
+	case "before_all", "before_each", "after_each", "after_all":
+		return len(l.Tokens) == 1
+	}
+	return false
+}
+
+// IsDefine reports whether a line starts a procedure
+func IsDefine(l Line) bool {
+	return keyword(l) == "define"
+}
+
+// IsEnd reports whether a line ends a procedure
+func IsEnd(l Line) bool {
+	return keyword(l) == "end" && len(l.Tokens) == 1
+}
+
+// IsImport reports whether a line is an import or include
+func IsImport(l Line) bool {
+	switch keyword(l) {
+	case "import", "include":
+		return true
+	}
+	return false
+}
+
+// IsExamples reports whether a line starts the examples of a test
+func IsExamples(l Line) bool {
+	return keyword(l) == "examples:"
+}
+
+// StartsBlock reports whether a line starts a test, hook or procedure,
+// which ends the block before it
+func StartsBlock(l Line) bool {
+	return IsTestHeader(l) || IsHook(l) || IsDefine(l)
+}
+
+// TestHeader is a parsed `[skip|only] test "Name" @tag ...` line
+type TestHeader struct {
+	Name string
+	// NameToken is the first token of the name
+	NameToken Token
+	Skip      bool
+	Only      bool
+	Tags      []string
+}
+
+// ParseTestHeader parses the header of a test. On error the header holds
+// what could be read, so tools can still show the test.
+func ParseTestHeader(l Line) (TestHeader, error) {
+	var h TestHeader
+	tokens := l.Tokens
+	switch tokens[0].Value {
+	case "skip":
+		h.Skip = true
+		tokens = tokens[1:]
+	case "only":
+		h.Only = true
+		tokens = tokens[1:]
+	}
+	tokens = tokens[1:]
+
+	// Tags are the trailing unquoted @words
+	var err error
+	for len(tokens) > 0 && !tokens[len(tokens)-1].Quoted && strings.HasPrefix(tokens[len(tokens)-1].Value, "@") {
+		tag := strings.TrimPrefix(tokens[len(tokens)-1].Value, "@")
+		if !tagRe.MatchString(tag) && err == nil {
+			err = tokenErrorf(tokens[len(tokens)-1], "invalid tag: @%s", tag)
+		}
+		h.Tags = append([]string{tag}, h.Tags...)
+		tokens = tokens[:len(tokens)-1]
+	}
+
+	if len(tokens) > 0 {
+		h.NameToken = tokens[0]
+		h.Name = l.Join(tokens)
+	}
+	if h.Name == "" {
+		return h, errorf(l.Num, "test requires a name")
+	}
+	return h, err
+}
+
+// DefineHeader is a parsed `define name(param, ...)` line
+type DefineHeader struct {
+	Name string
+	// NameOffset is where the name starts in the Text of the line
+	NameOffset int
+	Params     []string
+}
+
+// ParseDefine parses the header of a procedure
+func ParseDefine(l Line) (DefineHeader, error) {
+	var h DefineHeader
+	text := strings.TrimSpace(l.Text)
+	match := defineRe.FindStringSubmatchIndex(text)
+	if match == nil {
+		return h, errorf(l.Num, "invalid define, expected define name(param, ...)")
+	}
+	h.Name = text[match[2]:match[3]]
+	h.NameOffset = l.Indent() - 1 + match[2]
+
+	if match[4] >= 0 {
+		if params := strings.TrimSpace(text[match[4]:match[5]]); params != "" {
+			for _, param := range strings.Split(params, ",") {
+				param = strings.TrimSpace(param)
+				if !fasttest.IsVarName(param) {
+					return h, errorf(l.Num, "invalid parameter name: %q", param)
+				}
+				h.Params = append(h.Params, param)
+			}
+		}
+	}
+	return h, nil
+}
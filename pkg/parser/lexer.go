@@ -0,0 +1,248 @@
+package parser
+
+import (
+	"strings"
+)
+
+// Token is a word or a quoted string on a line of a .test file
+type Token struct {
+	Value  string // The text, with quotes removed and escapes resolved
+	Quoted bool
+	// Where the token starts in the file, 1-based
+	Line   int
+	Column int
+	// Byte offsets of the token in the Text of its Line
+	Offset int
+	End    int
+}
+
+// Line is a logical line of a .test file. It is usually one line of the file,
+// but a triple-quoted string makes it span several.
+type Line struct {
+	Num    int    // Line in the file where it starts, 1-based
+	Text   string // Source text, including indentation
+	Tokens []Token
+	// Comments and table rows are kept as text only
+	Comment  bool
+	TableRow bool
+}
+
+// Indent returns the column the line's content starts at, 1-based
+func (l Line) Indent() int {
+	return len(l.Text) - len(strings.TrimLeft(l.Text, " \t")) + 1
+}
+
+// Join joins tokens of the line with the spacing they had in the source. It
+// is how the words of a rest-of-line argument become one, so an unquoted
+// "div > span.item" stays a single selector.
+func (l Line) Join(tokens []Token) string {
+	if len(tokens) == 1 {
+		return tokens[0].Value
+	}
+	var b strings.Builder
+	for i, tok := range tokens {
+		if i > 0 {
+			b.WriteString(l.Text[tokens[i-1].End:tok.Offset])
+		}
+		b.WriteString(tok.Value)
+	}
+	return b.String()
+}
+
+// Lex splits a .test file into lines of tokens. Blank lines are dropped.
+//
+// Tokens are separated by spaces. A token that starts with " or ' runs to the
+// matching quote, and inside it \n, \t, \r, \\ and an escaped quote are
+// replaced; other backslashes are kept, so regular expressions need no double
+// escaping. A token that starts with """ runs to the next """, across lines,
+// with no escapes. If the text starts on the line after the opening quotes it
+// is dedented, so long text can be indented with the test.
+//
+// Lex keeps going after an unterminated string and returns every error.
+func Lex(src string) ([]Line, ErrorList) {
+	l := &lexer{src: src, line: 1, col: 1}
+	var lines []Line
+
+	for l.pos < len(l.src) {
+		start := l.pos
+		line := Line{Num: l.line}
+		l.skipSpace()
+
+		switch {
+		case l.atLineEnd():
+			l.next()
+			continue
+		case l.peek() == '#' || l.peek() == '|':
+			line.Comment = l.peek() == '#'
+			line.TableRow = l.peek() == '|'
+			for !l.atLineEnd() {
+				l.next()
+			}
+		default:
+			for !l.atLineEnd() {
+				line.Tokens = append(line.Tokens, l.token(start))
+				l.skipSpace()
+			}
+		}
+
+		line.Text = strings.TrimRight(l.src[start:l.pos], "\r")
+		lines = append(lines, line)
+		l.next()
+	}
+	return lines, l.errs
+}
+
+type lexer struct {
+	src       string
+	pos       int
+	line, col int
+	errs      ErrorList
+}
+
+func (l *lexer) peek() byte {
+	if l.pos >= len(l.src) {
+		return 0
+	}
+	return l.src[l.pos]
+}
+
+// next moves past the current byte, keeping track of line and column
+func (l *lexer) next() {
+	if l.pos >= len(l.src) {
+		return
+	}
+	if l.src[l.pos] == '\n' {
+		l.line++
+		l.col = 1
+	} else {
+		l.col++
+	}
+	l.pos++
+}
+
+func (l *lexer) atLineEnd() bool {
+	return l.pos >= len(l.src) || l.src[l.pos] == '\n'
+}
+
+func (l *lexer) skipSpace() {
+	for l.pos < len(l.src) && (l.src[l.pos] == ' ' || l.src[l.pos] == '\t' || l.src[l.pos] == '\r') {
+		l.next()
+	}
+}
+
+func (l *lexer) errorf(line, column int, format string, args ...interface{}) {
+	e := errorf(line, format, args...)
+	e.Column = column
+	l.errs = append(l.errs, e)
+}
+
+// token reads the token at the current position. lineStart is the offset
+// of the logical line in the source.
+func (l *lexer) token(lineStart int) Token {
+	tok := Token{Line: l.line, Column: l.col, Offset: l.pos - lineStart}
+
+	switch {
+	case strings.HasPrefix(l.src[l.pos:], `"""`):
+		tok.Quoted = true
+		tok.Value = l.tripleQuoted(tok)
+	case l.peek() == '"' || l.peek() == '\'':
+		tok.Quoted = true
+		tok.Value = l.quoted(tok)
+	default:
+		start := l.pos
+		for !l.atLineEnd() && l.peek() != ' ' && l.peek() != '\t' && l.peek() != '\r' {
+			l.next()
+		}
+		tok.Value = l.src[start:l.pos]
+	}
+
+	tok.End = l.pos - lineStart
+	return tok
+}
+
+func (l *lexer) quoted(tok Token) string {
+	quote := l.peek()
+	l.next()
+
+	var b strings.Builder
+	for {
+		if l.atLineEnd() {
+			l.errorf(tok.Line, tok.Column, "unterminated string")
+			return b.String()
+		}
+		c := l.peek()
+		l.next()
+		switch {
+		case c == quote:
+			return b.String()
+		case c == '\\' && !l.atLineEnd():
+			escaped := l.peek()
+			l.next()
+			switch escaped {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case '\\', '"', '\'':
+				b.WriteByte(escaped)
+			default:
+				b.WriteByte('\\')
+				b.WriteByte(escaped)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+}
+
+func (l *lexer) tripleQuoted(tok Token) string {
+	for i := 0; i < 3; i++ {
+		l.next()
+	}
+	end := strings.Index(l.src[l.pos:], `"""`)
+	if end < 0 {
+		l.errorf(tok.Line, tok.Column, "unterminated triple-quoted string")
+		end = len(l.src) - l.pos
+	}
+
+	content := l.src[l.pos : l.pos+end]
+	for i := 0; i < end+3 && l.pos < len(l.src); i++ {
+		l.next()
+	}
+	return dedent(content)
+}
+
+// dedent tidies the content of a triple-quoted string. When it starts on a
+// new line, that line break, the indentation shared by all its lines and the
+// whitespace before the closing quotes are removed.
+func dedent(s string) string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	if !strings.HasPrefix(s, "\n") {
+		return s
+	}
+	lines := strings.Split(s[1:], "\n")
+	if last := lines[len(lines)-1]; strings.TrimSpace(last) == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	indent := -1
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		n := len(line) - len(strings.TrimLeft(line, " \t"))
+		if indent < 0 || n < indent {
+			indent = n
+		}
+	}
+	for i, line := range lines {
+		if len(line) >= indent && indent > 0 {
+			lines[i] = line[indent:]
+		} else if strings.TrimSpace(line) == "" {
+			lines[i] = ""
+		}
+	}
+	return strings.Join(lines, "\n")
+}
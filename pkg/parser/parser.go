@@ -1,292 +1,592 @@
 package parser
 
 import (
-	"bufio"
+	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 
 	"github.com/kidandcat/testit/pkg/fasttest"
 )
 
-type Parser struct{}
+// Parser turns .test files into tests. A procedure defined with define can
+// be called from its own file and the files that import it, so which files
+// are parsed together, and in what order, does not change what a call means.
+type Parser struct {
+	// Files already parsed, keyed by absolute path
+	files map[string]*parsedFile
+	// Files currently being parsed, outermost first, to detect import cycles
+	importStack []string
+}
+
+// parsedFile is the result of parsing a single file
+type parsedFile struct {
+	tests []fasttest.Test
+	// File-level set declarations, including those of imported files. They
+	// run at the start of every test in the file.
+	setup []fasttest.Step
+	// Lifecycle hooks, including those of imported files
+	beforeAll  []fasttest.Step
+	beforeEach []fasttest.Step
+	afterEach  []fasttest.Step
+	afterAll   []fasttest.Step
+	// Procedures the file can call: its own and those of its imports
+	macros map[string]*macro
+}
+
+// suite wraps the file's tests and hooks for the runner
+func (pf *parsedFile) suite(name string) *fasttest.Suite {
+	return &fasttest.Suite{
+		Name:      name,
+		Setup:     pf.setup,
+		BeforeAll: pf.beforeAll,
+		AfterAll:  pf.afterAll,
+		Tests:     pf.tests,
+	}
+}
+
+// macro is a procedure declared with define ... end
+type macro struct {
+	name   string
+	params []string
+	body   []Line
+	file   string
+	line   int
+	// scope holds the procedures of the defining file, which the body's
+	// own calls resolve against
+	scope map[string]*macro
+}
+
+// location describes where the macro was defined, relative to a caller in file
+func (m *macro) location(file string) string {
+	if m.file != "" && m.file != file {
+		return fmt.Sprintf("%s:%d", m.file, m.line)
+	}
+	return fmt.Sprintf("line %d", m.line)
+}
 
 func New() *Parser {
-	return &Parser{}
+	return &Parser{
+		files: make(map[string]*parsedFile),
+	}
 }
 
 func (p *Parser) ParseFile(filename string) ([]fasttest.Test, error) {
-	file, err := os.Open(filename)
+	pf, err := p.parseFile(filename)
 	if err != nil {
 		return nil, err
 	}
-	defer file.Close()
+	return pf.tests, nil
+}
 
-	scanner := bufio.NewScanner(file)
-	return p.parse(scanner)
+// ParseSuite parses a file into a suite holding its tests together with
+// its before_all and after_all hooks
+func (p *Parser) ParseSuite(filename string) (*fasttest.Suite, error) {
+	pf, err := p.parseFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return pf.suite(filename), nil
 }
 
 func (p *Parser) ParseString(content string) ([]fasttest.Test, error) {
-	scanner := bufio.NewScanner(strings.NewReader(content))
-	return p.parse(scanner)
+	pf, err := p.parse(content, "")
+	if err != nil {
+		return nil, err
+	}
+	return pf.tests, nil
+}
+
+// ParseSource parses the content of a file that may differ from what is on
+// disk, like an unsaved editor buffer. Imports are resolved against filename.
+func (p *Parser) ParseSource(filename string, content []byte) ([]fasttest.Test, error) {
+	p.importStack = append(p.importStack, filename)
+	pf, err := p.parse(string(content), filename)
+	p.importStack = p.importStack[:len(p.importStack)-1]
+	if err != nil {
+		return nil, err
+	}
+	return pf.tests, nil
+}
+
+// parseFile parses each file only once per Parser, so a library imported
+// from several test files registers its procedures a single time
+func (p *Parser) parseFile(filename string) (*parsedFile, error) {
+	abs, err := filepath.Abs(filename)
+	if err != nil {
+		return nil, err
+	}
+	if pf, ok := p.files[abs]; ok {
+		return pf, nil
+	}
+	for i, importing := range p.importStack {
+		if importingAbs, _ := filepath.Abs(importing); importingAbs == abs {
+			cycle := append(append([]string(nil), p.importStack[i:]...), filename)
+			return nil, fmt.Errorf("import cycle: %s", strings.Join(cycle, " -> "))
+		}
+	}
+
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	p.importStack = append(p.importStack, filename)
+	pf, err := p.parse(string(content), filename)
+	p.importStack = p.importStack[:len(p.importStack)-1]
+	if err != nil {
+		return nil, err
+	}
+
+	p.files[abs] = pf
+	return pf, nil
+}
+
+// parseImport loads the procedures, setup and hooks of the file named by an
+// "import path" or "include path" line. Relative paths are resolved against
+// the directory of the importing file. Tests in the imported file are ignored.
+func (p *Parser) parseImport(line Line, filename string, lineNum int) (*parsedFile, error) {
+	keyword := line.Tokens[0].Value
+	if len(line.Tokens) != 2 || line.Tokens[1].Value == "" {
+		return nil, errorf(lineNum, "%s requires a file path", keyword)
+	}
+	path := line.Tokens[1].Value
+	if !filepath.IsAbs(path) && filename != "" {
+		path = filepath.Join(filepath.Dir(filename), path)
+	}
+
+	pf, err := p.parseFile(path)
+	if err != nil {
+		return nil, errorf(lineNum, "in %s %s: %v", keyword, path, err)
+	}
+	return pf, nil
 }
 
-func (p *Parser) parse(scanner *bufio.Scanner) ([]fasttest.Test, error) {
+// parse reads a whole file. It keeps going after a bad line, so the
+// returned ErrorList holds every error in the file rather than just the first.
+func (p *Parser) parse(src string, filename string) (*parsedFile, error) {
+	pf := &parsedFile{macros: make(map[string]*macro)}
+	var errs ErrorList
 	var tests []fasttest.Test
 	var currentTest *fasttest.Test
+	// Data table of the current test, if it has an examples: block
+	var currentExamples *examples
+	// File-level set declarations run at the start of every test that follows,
+	// before its hooks
+	var fileVars []fasttest.Step
+	// Procedure whose body is being read
+	var currentMacro *macro
+	// Steps of the test or hook being read
+	var block *[]fasttest.Step
 	lineNum := 0
+	column := 0
+
+	report := func(err error) {
+		errs.add(err, filename, lineNum, column)
+	}
+
+	lines, lexErrs := Lex(src)
+	errs.add(lexErrs, filename, 0, 0)
+
+	finishTest := func() {
+		if currentTest != nil && currentExamples != nil {
+			expanded, err := currentExamples.expand(*currentTest)
+			if err != nil {
+				report(err)
+			}
+			tests = append(tests, expanded...)
+		} else if currentTest != nil {
+			tests = append(tests, *currentTest)
+		}
+		currentTest = nil
+		currentExamples = nil
+	}
 
-	for scanner.Scan() {
-		lineNum++
-		line := strings.TrimSpace(scanner.Text())
+	for _, l := range lines {
+		lineNum = l.Num
+		column = l.Indent()
+		line := strings.TrimSpace(l.Text)
 
-		if line == "" || strings.HasPrefix(line, "#") {
+		if l.Comment {
 			continue
 		}
 
-		if strings.HasPrefix(line, "test ") {
-			if currentTest != nil {
-				tests = append(tests, *currentTest)
+		if currentMacro != nil {
+			switch {
+			case IsEnd(l):
+				pf.defineMacro(currentMacro)
+				currentMacro = nil
+				continue
+			case StartsBlock(l):
+				// Close the procedure here and read the line as usual
+				report(errorf(lineNum, "missing end for define %s at line %d", currentMacro.name, currentMacro.line))
+				pf.defineMacro(currentMacro)
+				currentMacro = nil
+			default:
+				currentMacro.body = append(currentMacro.body, l)
+				continue
 			}
+		}
 
-			testNamePart := strings.TrimPrefix(line, "test ")
-			testName := strings.Trim(testNamePart, `"'`)
-			currentTest = &fasttest.Test{
-				Name: testName,
+		if IsDefine(l) {
+			m, err := pf.parseDefine(l, filename)
+			if err != nil {
+				report(err)
+				// Skip the body, which would only add more errors
+				m = &macro{line: lineNum}
 			}
-		} else if currentTest != nil {
-			step, err := p.parseLine(line, lineNum)
+			currentMacro = m
+		} else if IsEnd(l) {
+			report(errorf(lineNum, "end without define"))
+		} else if IsImport(l) {
+			if block != nil {
+				report(errorf(lineNum, "imports must come before the first test or hook"))
+				continue
+			}
+			imported, err := p.parseImport(l, filename, lineNum)
+			if err != nil {
+				report(err)
+				continue
+			}
+			if err := pf.importMacros(imported, filename, lineNum); err != nil {
+				report(err)
+			}
+			fileVars = append(fileVars, imported.setup...)
+			pf.beforeAll = append(pf.beforeAll, imported.beforeAll...)
+			pf.beforeEach = append(pf.beforeEach, imported.beforeEach...)
+			pf.afterEach = append(pf.afterEach, imported.afterEach...)
+			pf.afterAll = append(pf.afterAll, imported.afterAll...)
+		} else if IsTestHeader(l) {
+			finishTest()
+
+			test, err := p.parseTestHeader(l)
 			if err != nil {
-				return nil, err
+				report(err)
+				// Still read the steps, to report their errors too
+				test = &fasttest.Test{}
+			}
+			test.Setup = append([]fasttest.Step(nil), fileVars...)
+			currentTest = test
+			block = &currentTest.Steps
+		} else if IsHook(l) {
+			finishTest()
+			block = pf.hook(line)
+		} else if IsExamples(l) {
+			if currentTest == nil || block != &currentTest.Steps {
+				report(errorf(lineNum, "examples must be inside a test"))
+				continue
+			}
+			if currentExamples != nil {
+				report(errorf(lineNum, "test already has examples at line %d", currentExamples.line))
+				continue
+			}
+			ex, err := parseExamplesHeader(l, filename, lineNum)
+			if err != nil {
+				report(err)
+				// Rows that follow are still checked, but the test is dropped
+				ex = &examples{line: lineNum, failed: true}
+			}
+			currentExamples = ex
+		} else if l.TableRow {
+			if currentExamples == nil {
+				report(errorf(lineNum, "table row without examples:"))
+			} else if err := currentExamples.addRow(line, lineNum); err != nil {
+				report(err)
+			}
+		} else if currentExamples != nil {
+			report(errorf(lineNum, "steps must come before examples"))
+		} else if block == nil && strings.HasPrefix(line, "set ") {
+			step, err := p.parseStep(l, filename)
+			if err != nil {
+				report(err)
+				continue
+			}
+			fileVars = append(fileVars, *step)
+		} else if block != nil && l.Tokens[0].Value == "call" {
+			steps, err := p.expandCall(pf.macros, l, filename, lineNum, nil)
+			if err != nil {
+				report(err)
+				continue
+			}
+			*block = append(*block, steps...)
+		} else if block != nil {
+			step, err := p.parseStep(l, filename)
+			if err != nil {
+				report(err)
+				continue
 			}
 			if step != nil {
-				currentTest.Steps = append(currentTest.Steps, *step)
+				*block = append(*block, *step)
 			}
 		}
 	}
 
-	if currentTest != nil {
-		tests = append(tests, *currentTest)
+	if currentMacro != nil {
+		errs.add(errorf(currentMacro.line, "missing end for define %s", currentMacro.name), filename, 0, 0)
 	}
+	finishTest()
 
-	if err := scanner.Err(); err != nil {
+	if err := errs.Err(); err != nil {
 		return nil, err
 	}
 
-	return tests, nil
+	// before_each and after_each apply to every test in the file, wherever
+	// they are written
+	for i := range tests {
+		tests[i].BeforeEach = pf.beforeEach
+		tests[i].AfterEach = pf.afterEach
+	}
+	pf.tests = tests
+	pf.setup = fileVars
+	return pf, nil
 }
 
-func (p *Parser) parseLine(line string, lineNum int) (*fasttest.Step, error) {
-	parts := strings.Fields(line)
-	if len(parts) == 0 {
-		return nil, nil
+// defineMacro registers a procedure once its body has been read. Procedures
+// whose define line was invalid have no name and are dropped.
+func (pf *parsedFile) defineMacro(m *macro) {
+	if m.name != "" {
+		m.scope = pf.macros
+		pf.macros[m.name] = m
 	}
+}
 
-	action := parts[0]
-
-	switch action {
-	case "navigate":
-		if len(parts) < 2 {
-			return nil, fmt.Errorf("line %d: navigate requires a URL", lineNum)
-		}
-		return &fasttest.Step{
-			Action: "navigate",
-			Target: strings.Trim(strings.Join(parts[1:], " "), `"'`),
-		}, nil
-
-	case "click":
-		if len(parts) < 2 {
-			return nil, fmt.Errorf("line %d: click requires a selector", lineNum)
-		}
-		return &fasttest.Step{
-			Action: "click",
-			Target: strings.Trim(strings.Join(parts[1:], " "), `"'`),
-		}, nil
-
-	case "type":
-		if len(parts) < 3 {
-			return nil, fmt.Errorf("line %d: type requires a selector and value", lineNum)
-		}
-		selector := strings.Trim(parts[1], `"'`)
-		value := strings.Trim(strings.Join(parts[2:], " "), `"'`)
-		return &fasttest.Step{
-			Action: "type",
-			Target: selector,
-			Value:  value,
-		}, nil
-
-	case "wait_for":
-		if len(parts) < 2 {
-			return nil, fmt.Errorf("line %d: wait_for requires a selector", lineNum)
-		}
-		return &fasttest.Step{
-			Action: "wait_for",
-			Target: strings.Trim(strings.Join(parts[1:], " "), `"'`),
-		}, nil
-
-	case "assert_text":
-		if len(parts) < 3 {
-			return nil, fmt.Errorf("line %d: assert_text requires a selector and expected text", lineNum)
-		}
-		selector := strings.Trim(parts[1], `"'`)
-		expectedText := strings.Trim(strings.Join(parts[2:], " "), `"'`)
-		return &fasttest.Step{
-			Action: "assert_text",
-			Target: selector,
-			Value:  expectedText,
-		}, nil
-
-	case "assert_element_exists":
-		if len(parts) < 2 {
-			return nil, fmt.Errorf("line %d: assert_element_exists requires a selector", lineNum)
-		}
-		return &fasttest.Step{
-			Action: "assert_element_exists",
-			Target: strings.Trim(strings.Join(parts[1:], " "), `"'`),
-		}, nil
-
-	case "assert_element_not_exists":
-		if len(parts) < 2 {
-			return nil, fmt.Errorf("line %d: assert_element_not_exists requires a selector", lineNum)
-		}
-		return &fasttest.Step{
-			Action: "assert_element_not_exists",
-			Target: strings.Trim(strings.Join(parts[1:], " "), `"'`),
-		}, nil
-
-	case "assert_text_contains":
-		if len(parts) < 3 {
-			return nil, fmt.Errorf("line %d: assert_text_contains requires a selector and text", lineNum)
-		}
-		selector := strings.Trim(parts[1], `"'`)
-		text := strings.Trim(strings.Join(parts[2:], " "), `"'`)
-		return &fasttest.Step{
-			Action: "assert_text_contains",
-			Target: selector,
-			Value:  text,
-		}, nil
-
-	case "assert_url":
-		if len(parts) < 2 {
-			return nil, fmt.Errorf("line %d: assert_url requires a URL pattern", lineNum)
-		}
-		return &fasttest.Step{
-			Action: "assert_url",
-			Target: strings.Trim(strings.Join(parts[1:], " "), `"'`),
-		}, nil
-
-	case "assert_title":
-		if len(parts) < 2 {
-			return nil, fmt.Errorf("line %d: assert_title requires expected title", lineNum)
+// importMacros makes the procedures of an imported file callable. A file
+// reached through several imports brings the same procedures each time,
+// which is fine; two different procedures with one name are not.
+func (pf *parsedFile) importMacros(imported *parsedFile, filename string, lineNum int) error {
+	names := make([]string, 0, len(imported.macros))
+	for name := range imported.macros {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		m := imported.macros[name]
+		if existing, ok := pf.macros[name]; ok && existing != m {
+			return errorf(lineNum, "%s from %s is already defined at %s", name, m.location(filename), existing.location(filename))
 		}
-		return &fasttest.Step{
-			Action: "assert_title",
-			Target: strings.Trim(strings.Join(parts[1:], " "), `"'`),
-		}, nil
-
-	case "assert_text_visible":
-		if len(parts) < 2 {
-			return nil, fmt.Errorf("line %d: assert_text_visible requires text to search for", lineNum)
+		pf.macros[name] = m
+	}
+	return nil
+}
+
+// parseTestHeader parses `[skip|only] test "Name" @tag ...`
+func (p *Parser) parseTestHeader(line Line) (*fasttest.Test, error) {
+	h, err := ParseTestHeader(line)
+	if err != nil {
+		return nil, err
+	}
+	return &fasttest.Test{Name: h.Name, Tags: h.Tags, Skip: h.Skip, Only: h.Only}, nil
+}
+
+// hook returns the step list a hook block header appends to
+func (pf *parsedFile) hook(line string) *[]fasttest.Step {
+	switch line {
+	case "before_all":
+		return &pf.beforeAll
+	case "before_each":
+		return &pf.beforeEach
+	case "after_each":
+		return &pf.afterEach
+	default:
+		return &pf.afterAll
+	}
+}
+
+// parseDefine parses a "define name(param, ...)" header
+func (pf *parsedFile) parseDefine(line Line, filename string) (*macro, error) {
+	h, err := ParseDefine(line)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &macro{
+		name:   h.Name,
+		params: h.Params,
+		file:   filename,
+		line:   line.Num,
+	}
+	if existing, ok := pf.macros[m.name]; ok {
+		return nil, errorf(line.Num, "%s is already defined at %s", m.name, existing.location(filename))
+	}
+	return m, nil
+}
+
+// expandCall turns "call name arg ..." into the steps of the procedure body,
+// with ${param} references replaced by the arguments. Errors name both the
+// call site and the line in the definition, and so do the steps, through
+// CallFile and CallLine; for nested calls that is the outermost call.
+// Names resolve against scope. stack holds the procedures already being
+// expanded, to catch recursion.
+func (p *Parser) expandCall(scope map[string]*macro, line Line, filename string, lineNum int, stack []string) ([]fasttest.Step, error) {
+	if len(line.Tokens) < 2 {
+		return nil, errorf(lineNum, "call requires a procedure name")
+	}
+
+	name := line.Tokens[1].Value
+	m, ok := scope[name]
+	if !ok {
+		return nil, tokenErrorf(line.Tokens[1], "undefined procedure: %s", name)
+	}
+	for _, caller := range stack {
+		if caller == name {
+			return nil, tokenErrorf(line.Tokens[1], "recursive call to %s", name)
 		}
-		return &fasttest.Step{
-			Action: "assert_text_visible",
-			Value:  strings.Trim(strings.Join(parts[1:], " "), `"'`),
-		}, nil
-
-	case "assert_attribute":
-		if len(parts) < 4 {
-			return nil, fmt.Errorf("line %d: assert_attribute requires selector, attribute name, and expected value", lineNum)
+	}
+
+	args := line.Tokens[2:]
+	if len(args) != len(m.params) {
+		return nil, errorf(lineNum, "%s expects %d arguments, got %d", name, len(m.params), len(args))
+	}
+	values := make(map[string]string, len(args))
+	for i, param := range m.params {
+		values[param] = args[i].Value
+	}
+	lookup := func(name string) (string, bool) {
+		value, ok := values[name]
+		return value, ok
+	}
+
+	// Wrap errors from the body so they point at both places
+	wrap := func(err error) error {
+		return errorf(lineNum, "in call to %s (defined at %s): %v", name, m.location(filename), err)
+	}
+
+	var steps []fasttest.Step
+	for _, bodyLine := range m.body {
+		// Arguments are substituted into each token, so values with spaces or
+		// quotes stay a single argument. Other ${...} references are left for
+		// the runner to resolve.
+		expanded := bodyLine
+		expanded.Tokens = make([]Token, len(bodyLine.Tokens))
+		for i, tok := range bodyLine.Tokens {
+			tok.Value, _ = fasttest.ExpandVars(tok.Value, lookup)
+			expanded.Tokens[i] = tok
 		}
-		selector := strings.Trim(parts[1], `"'`)
-		attribute := strings.Trim(parts[2], `"'`)
-		value := strings.Trim(strings.Join(parts[3:], " "), `"'`)
-		return &fasttest.Step{
-			Action: "assert_attribute",
-			Target: selector + "|" + attribute,
-			Value:  value,
-		}, nil
-
-	case "screenshot":
-		filename := ""
-		if len(parts) >= 2 {
-			filename = strings.Trim(strings.Join(parts[1:], " "), `"'`)
+
+		if len(expanded.Tokens) > 0 && expanded.Tokens[0].Value == "call" {
+			nested, err := p.expandCall(m.scope, expanded, m.file, bodyLine.Num, append(stack, name))
+			if err != nil {
+				return nil, wrap(err)
+			}
+			steps = append(steps, nested...)
+			continue
 		}
-		return &fasttest.Step{
-			Action: "screenshot",
-			Target: filename,
-		}, nil
-
-	case "snapshot":
-		filename := ""
-		if len(parts) >= 2 {
-			filename = strings.Trim(strings.Join(parts[1:], " "), `"'`)
+		step, err := p.parseStep(expanded, m.file)
+		if err != nil {
+			return nil, wrap(err)
 		}
-		return &fasttest.Step{
-			Action: "snapshot",
-			Target: filename,
-		}, nil
-
-	case "wait_for_text":
-		if len(parts) < 3 {
-			return nil, fmt.Errorf("line %d: wait_for_text requires a selector and text", lineNum)
+		if step != nil {
+			steps = append(steps, *step)
 		}
-		selector := strings.Trim(parts[1], `"'`)
-		text := strings.Trim(strings.Join(parts[2:], " "), `"'`)
-		return &fasttest.Step{
-			Action: "wait_for_text",
-			Target: selector,
-			Value:  text,
-		}, nil
-
-	case "wait_for_url":
-		if len(parts) < 2 {
-			return nil, fmt.Errorf("line %d: wait_for_url requires a URL pattern", lineNum)
+	}
+	for i := range steps {
+		steps[i].CallFile = filename
+		steps[i].CallLine = lineNum
+	}
+	return steps, nil
+}
+
+// parseStep parses a step and records where it was written, for error
+// messages at run time
+func (p *Parser) parseStep(line Line, filename string) (*fasttest.Step, error) {
+	step, err := p.parseLine(line, line.Num)
+	if step != nil {
+		step.File = filename
+		step.Line = line.Num
+		step.Text = strings.TrimSpace(line.Text)
+	}
+	return step, err
+}
+
+// parseLine turns a line into a step and checks its arguments
+func (p *Parser) parseLine(line Line, lineNum int) (*fasttest.Step, error) {
+	step, err := p.buildStep(line, lineNum)
+	if err != nil || step == nil {
+		return step, err
+	}
+	if err := fasttest.CheckStep(*step); err != nil {
+		return nil, tokenErrorf(argToken(line, err), "%v", err)
+	}
+	return step, nil
+}
+
+// argToken returns the token of the argument a CheckStep error is about, or
+// the first argument if it cannot tell, as when the argument came from
+// several tokens
+func argToken(line Line, err error) Token {
+	var argErr *fasttest.ArgError
+	if errors.As(err, &argErr) {
+		for _, tok := range line.Tokens[1:] {
+			if tok.Value == argErr.Arg {
+				return tok
+			}
 		}
-		return &fasttest.Step{
-			Action: "wait_for_url",
-			Target: strings.Trim(strings.Join(parts[1:], " "), `"'`),
-		}, nil
-
-	case "select":
-		if len(parts) < 3 {
-			return nil, fmt.Errorf("line %d: select requires a selector and value", lineNum)
+	}
+	if len(line.Tokens) > 1 {
+		return line.Tokens[1]
+	}
+	return line.Tokens[0]
+}
+
+// buildStep turns a line into a step, using the action's spec to find where
+// each argument ends
+func (p *Parser) buildStep(line Line, lineNum int) (*fasttest.Step, error) {
+	if len(line.Tokens) == 0 {
+		return nil, nil
+	}
+
+	action := line.Tokens[0].Value
+	spec, ok := actions[action]
+	if !ok {
+		return nil, tokenErrorf(line.Tokens[0], "unknown action: %s", action)
+	}
+
+	args := line.Tokens[1:]
+	if len(args) < spec.required() {
+		return nil, errorf(lineNum, "%s requires %s", action, spec.requires)
+	}
+	if spec.Variadic {
+		values := make([]string, len(args))
+		for i, tok := range args {
+			values[i] = tok.Value
 		}
-		selector := strings.Trim(parts[1], `"'`)
-		value := strings.Trim(strings.Join(parts[2:], " "), `"'`)
-		return &fasttest.Step{
-			Action: "select",
-			Target: selector,
-			Value:  value,
-		}, nil
-
-	case "check":
-		if len(parts) < 2 {
-			return nil, fmt.Errorf("line %d: check requires a selector", lineNum)
+		return spec.build(values, lineNum)
+	}
+	if !spec.Rest && len(args) > len(spec.Args) {
+		return nil, tokenErrorf(args[len(spec.Args)], "too many arguments, expected %s", spec.Usage())
+	}
+
+	values := make([]string, len(spec.Args))
+	for i := range values {
+		if i >= len(args) {
+			break
 		}
-		return &fasttest.Step{
-			Action: "check",
-			Target: strings.Trim(strings.Join(parts[1:], " "), `"'`),
-		}, nil
-
-	case "uncheck":
-		if len(parts) < 2 {
-			return nil, fmt.Errorf("line %d: uncheck requires a selector", lineNum)
+		if i < len(values)-1 || !spec.Rest {
+			values[i] = args[i].Value
+			continue
 		}
-		return &fasttest.Step{
-			Action: "uncheck",
-			Target: strings.Trim(strings.Join(parts[1:], " "), `"'`),
-		}, nil
-
-	case "hover":
-		if len(parts) < 2 {
-			return nil, fmt.Errorf("line %d: hover requires a selector", lineNum)
+
+		// The last argument takes the rest of the line. Only bare words are
+		// joined; a quoted string among several words is ambiguous.
+		rest := args[i:]
+		if len(rest) > 1 {
+			for _, tok := range rest {
+				if tok.Quoted {
+					return nil, tokenErrorf(tok, "too many arguments, expected %s", spec.Usage())
+				}
+			}
 		}
-		return &fasttest.Step{
-			Action: "hover",
-			Target: strings.Trim(strings.Join(parts[1:], " "), `"'`),
-		}, nil
+		values[i] = line.Join(rest)
+	}
 
-	default:
-		return nil, fmt.Errorf("line %d: unknown action: %s", lineNum, action)
+	if spec.build != nil {
+		return spec.build(values, lineNum)
+	}
+	step := &fasttest.Step{Action: action, Target: values[0]}
+	if len(values) > 1 {
+		step.Value = values[1]
 	}
+	return step, nil
 }
@@ -1,6 +1,10 @@
 package parser
 
 import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/kidandcat/testit/pkg/fasttest"
@@ -165,6 +169,317 @@ test "Test with comments"
 				},
 			},
 		},
+		{
+			name: "viewport and device",
+			input: `test "Mobile test"
+  viewport 375 812
+  device "iPhone 13"
+  device Pixel 5`,
+			want: []fasttest.Test{
+				{
+					Name: "Mobile test",
+					Steps: []fasttest.Step{
+						{Action: "viewport", Target: "375", Value: "812"},
+						{Action: "device", Target: "iPhone 13"},
+						{Action: "device", Target: "Pixel 5"},
+					},
+				},
+			},
+		},
+		{
+			name: "invalid viewport",
+			input: `test "Invalid"
+  viewport wide 812`,
+			wantErr: true,
+		},
+		{
+			name: "unknown device",
+			input: `test "Invalid"
+  device "Nokia 3310"`,
+			wantErr: true,
+		},
+		{
+			name: "select by label and index",
+			input: `test "Select test"
+  select #country United States
+  select #size index=2`,
+			want: []fasttest.Test{
+				{
+					Name: "Select test",
+					Steps: []fasttest.Step{
+						{Action: "select", Target: "#country", Value: "United States"},
+						{Action: "select", Target: "#size", Value: "index=2"},
+					},
+				},
+			},
+		},
+		{
+			name: "invalid option index",
+			input: `test "Invalid"
+  select #size index=two`,
+			wantErr: true,
+		},
+		{
+			name: "keyboard commands",
+			input: `test "Keyboard test"
+  clear #search
+  type_focused "hello world"
+  press Control+A
+  press ArrowDown ArrowDown Enter
+  key_down Shift
+  key_up Shift`,
+			want: []fasttest.Test{
+				{
+					Name: "Keyboard test",
+					Steps: []fasttest.Step{
+						{Action: "clear", Target: "#search"},
+						{Action: "type_focused", Value: "hello world"},
+						{Action: "press", Target: "Control+A"},
+						{Action: "press", Target: "ArrowDown ArrowDown Enter"},
+						{Action: "key_down", Target: "Shift"},
+						{Action: "key_up", Target: "Shift"},
+					},
+				},
+			},
+		},
+		{
+			name: "unknown key",
+			input: `test "Invalid"
+  press Control+Hyper+Foo`,
+			wantErr: true,
+		},
+		{
+			name: "upload and download commands",
+			input: `test "Files test"
+  upload "input[type=file]" fixtures/avatar.png
+  click #export
+  expect_download report-*.csv
+  assert_download_size >0
+  assert_download_contains id,name`,
+			want: []fasttest.Test{
+				{
+					Name: "Files test",
+					Steps: []fasttest.Step{
+						{Action: "upload", Target: "input[type=file]", Value: "fixtures/avatar.png"},
+						{Action: "click", Target: "#export"},
+						{Action: "expect_download", Target: "report-*.csv"},
+						{Action: "assert_download_size", Value: ">0"},
+						{Action: "assert_download_contains", Value: "id,name"},
+					},
+				},
+			},
+		},
+		{
+			name: "invalid download size",
+			input: `test "Invalid"
+  assert_download_size about 1KB`,
+			wantErr: true,
+		},
+		{
+			name: "network commands",
+			input: `test "Network test"
+  mock GET /api/user with file fixtures/user.json status 200
+  mock POST /api/login status 401 with body "{\"error\": \"bad password\"}"
+  block *.analytics.com/*
+  delay regex:/api/search\?q= 2s`,
+			want: []fasttest.Test{
+				{
+					Name: "Network test",
+					Steps: []fasttest.Step{
+						{Action: "mock", Target: "GET /api/user", Value: "status 200 file fixtures/user.json"},
+						{Action: "mock", Target: "POST /api/login", Value: `status 401 body {"error": "bad password"}`},
+						{Action: "block", Target: "*.analytics.com/*"},
+						{Action: "delay", Target: `regex:/api/search\?q=`, Value: "2s"},
+					},
+				},
+			},
+		},
+		{
+			name: "network assertions",
+			input: `test "Save test"
+  click #save
+  wait_for_request POST /api/items
+  assert_request_body $.name Milk
+  assert_request_body items[0].qty 2
+  assert_response_status POST /api/items 201
+  assert_response_status /api/* 2xx`,
+			want: []fasttest.Test{
+				{
+					Name: "Save test",
+					Steps: []fasttest.Step{
+						{Action: "click", Target: "#save"},
+						{Action: "wait_for_request", Target: "POST /api/items"},
+						{Action: "assert_request_body", Target: "$.name", Value: "Milk"},
+						{Action: "assert_request_body", Target: "items[0].qty", Value: "2"},
+						{Action: "assert_response_status", Target: "POST /api/items", Value: "201"},
+						{Action: "assert_response_status", Target: "/api/*", Value: "2xx"},
+					},
+				},
+			},
+		},
+		{
+			name: "invalid response status",
+			input: `test "Invalid"
+  assert_response_status /api/items created`,
+			wantErr: true,
+		},
+		{
+			name: "invalid JSON path",
+			input: `test "Invalid"
+  assert_request_body $.items[first] 2`,
+			wantErr: true,
+		},
+		{
+			name: "console error commands",
+			input: `test "Errors test"
+  ignore_console_error "ResizeObserver loop"
+  click #broken
+  assert_console_error Cannot read properties of undefined
+  assert_no_console_errors`,
+			want: []fasttest.Test{
+				{
+					Name: "Errors test",
+					Steps: []fasttest.Step{
+						{Action: "ignore_console_error", Target: "ResizeObserver loop"},
+						{Action: "click", Target: "#broken"},
+						{Action: "assert_console_error", Target: "Cannot read properties of undefined"},
+						{Action: "assert_no_console_errors"},
+					},
+				},
+			},
+		},
+		{
+			name: "invalid console error pattern",
+			input: `test "Invalid"
+  ignore_console_error "widget(.js"`,
+			wantErr: true,
+		},
+		{
+			name: "assert_no_console_errors takes no arguments",
+			input: `test "Invalid"
+  assert_no_console_errors now`,
+			wantErr: true,
+		},
+		{
+			name: "invalid mock status",
+			input: `test "Invalid"
+  mock /api/user status ok`,
+			wantErr: true,
+		},
+		{
+			name: "delay without duration",
+			input: `test "Invalid"
+  delay /api/*`,
+			wantErr: true,
+		},
+		{
+			name: "mouse commands",
+			input: `test "Mouse test"
+  double_click .cell
+  right_click "#file"
+  mouse_move 10 20.5
+  mouse_down
+  mouse_up right`,
+			want: []fasttest.Test{
+				{
+					Name: "Mouse test",
+					Steps: []fasttest.Step{
+						{Action: "double_click", Target: ".cell"},
+						{Action: "right_click", Target: "#file"},
+						{Action: "mouse_move", Target: "10", Value: "20.5"},
+						{Action: "mouse_down"},
+						{Action: "mouse_up", Target: "right"},
+					},
+				},
+			},
+		},
+		{
+			name: "invalid mouse coordinate",
+			input: `test "Invalid"
+  mouse_move 10 top`,
+			wantErr: true,
+		},
+		{
+			name: "unknown mouse button",
+			input: `test "Invalid"
+  mouse_down back`,
+			wantErr: true,
+		},
+		{
+			name: "variables",
+			input: `set HOST "http://localhost:8080"
+
+test "Vars test"
+  set user admin
+  navigate "${HOST}/login"
+  type "#username" ${user}
+  store_text ".order-id" orderId
+  assert_url "${HOST}/orders/${orderId}"
+
+test "Second vars test"
+  navigate ${HOST}`,
+			want: []fasttest.Test{
+				{
+					Name: "Vars test",
+					Setup: []fasttest.Step{
+						{Action: "set", Target: "HOST", Value: "http://localhost:8080"},
+					},
+					Steps: []fasttest.Step{
+						{Action: "set", Target: "user", Value: "admin"},
+						{Action: "navigate", Target: "${HOST}/login"},
+						{Action: "type", Target: "#username", Value: "${user}"},
+						{Action: "store_text", Target: ".order-id", Value: "orderId"},
+						{Action: "assert_url", Target: "${HOST}/orders/${orderId}"},
+					},
+				},
+				{
+					Name: "Second vars test",
+					Setup: []fasttest.Step{
+						{Action: "set", Target: "HOST", Value: "http://localhost:8080"},
+					},
+					Steps: []fasttest.Step{
+						{Action: "navigate", Target: "${HOST}"},
+					},
+				},
+			},
+		},
+		{
+			name: "variables in checked arguments",
+			input: `test "Variables"
+  press ${key} Enter
+  assert_response_status /api/items ${status}
+  assert_download_size ${size}
+  select #country ${option}
+  mouse_move ${x} 20
+  viewport ${width} ${height}
+  device ${device}`,
+			want: []fasttest.Test{
+				{
+					Name: "Variables",
+					Steps: []fasttest.Step{
+						{Action: "press", Target: "${key} Enter"},
+						{Action: "assert_response_status", Target: "/api/items", Value: "${status}"},
+						{Action: "assert_download_size", Value: "${size}"},
+						{Action: "select", Target: "#country", Value: "${option}"},
+						{Action: "mouse_move", Target: "${x}", Value: "20"},
+						{Action: "viewport", Target: "${width}", Value: "${height}"},
+						{Action: "device", Target: "${device}"},
+					},
+				},
+			},
+		},
+		{
+			name: "invalid argument next to a variable",
+			input: `test "Invalid"
+  viewport ${width} tall`,
+			wantErr: true,
+		},
+		{
+			name: "invalid variable name",
+			input: `test "Invalid"
+  set 1st value`,
+			wantErr: true,
+		},
 		{
 			name: "invalid command",
 			input: `test "Invalid"
@@ -196,12 +511,20 @@ test "Test with comments"
 					if got[i].Name != tt.want[i].Name {
 						t.Errorf("Test[%d].Name = %v, want %v", i, got[i].Name, tt.want[i].Name)
 					}
+					if len(got[i].Setup) != len(tt.want[i].Setup) {
+						t.Errorf("Test[%d] got %d setup steps, want %d", i, len(got[i].Setup), len(tt.want[i].Setup))
+					}
+					for j := range got[i].Setup {
+						if j < len(tt.want[i].Setup) && withoutPosition(got[i].Setup[j]) != tt.want[i].Setup[j] {
+							t.Errorf("Test[%d].Setup[%d] = %v, want %v", i, j, got[i].Setup[j], tt.want[i].Setup[j])
+						}
+					}
 					if len(got[i].Steps) != len(tt.want[i].Steps) {
 						t.Errorf("Test[%d] got %d steps, want %d", i, len(got[i].Steps), len(tt.want[i].Steps))
 						continue
 					}
 					for j := range got[i].Steps {
-						if got[i].Steps[j] != tt.want[i].Steps[j] {
+						if withoutPosition(got[i].Steps[j]) != tt.want[i].Steps[j] {
 							t.Errorf("Test[%d].Steps[%d] = %v, want %v", i, j, got[i].Steps[j], tt.want[i].Steps[j])
 						}
 					}
@@ -210,3 +533,897 @@ test "Test with comments"
 		})
 	}
 }
+
+func TestProcedures(t *testing.T) {
+	input := `define login(user, pass)
+  navigate "${BASE_URL}/login"
+  type #username ${user}
+  type #password ${pass}
+  click button[type='submit']
+end
+
+define login_as_admin
+  call login admin secret
+end
+
+test "Admin can login"
+  call login_as_admin
+  wait_for .dashboard
+
+test "User can login"
+  call login "jane" hunter2`
+
+	got, err := New().ParseString(input)
+	if err != nil {
+		t.Fatalf("ParseString() error = %v", err)
+	}
+
+	want := []fasttest.Test{
+		{
+			Name: "Admin can login",
+			Steps: []fasttest.Step{
+				{Action: "navigate", Target: "${BASE_URL}/login"},
+				{Action: "type", Target: "#username", Value: "admin"},
+				{Action: "type", Target: "#password", Value: "secret"},
+				{Action: "click", Target: "button[type='submit']"},
+				{Action: "wait_for", Target: ".dashboard"},
+			},
+		},
+		{
+			Name: "User can login",
+			Steps: []fasttest.Step{
+				{Action: "navigate", Target: "${BASE_URL}/login"},
+				{Action: "type", Target: "#username", Value: "jane"},
+				{Action: "type", Target: "#password", Value: "hunter2"},
+				{Action: "click", Target: "button[type='submit']"},
+			},
+		},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("ParseString() got %d tests, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if len(got[i].Steps) != len(want[i].Steps) {
+			t.Errorf("Test[%d] got %d steps, want %d", i, len(got[i].Steps), len(want[i].Steps))
+			continue
+		}
+		for j := range want[i].Steps {
+			if withoutPosition(got[i].Steps[j]) != want[i].Steps[j] {
+				t.Errorf("Test[%d].Steps[%d] = %v, want %v", i, j, got[i].Steps[j], want[i].Steps[j])
+			}
+		}
+	}
+}
+
+func TestProcedureErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		input   string
+		wantErr []string
+	}{
+		{
+			name: "error in body reports call site and definition",
+			input: `define open_menu
+  hover #menu
+  click
+end
+
+test "Menu"
+  call open_menu`,
+			wantErr: []string{"line 7", "defined at line 1", "line 3: click requires a selector"},
+		},
+		{
+			name: "wrong number of arguments",
+			input: `define greet(name)
+  type #name ${name}
+end
+
+test "Greet"
+  call greet`,
+			wantErr: []string{"line 6", "expects 1 arguments, got 0"},
+		},
+		{
+			name: "undefined procedure",
+			input: `test "Missing"
+  call nothing_here`,
+			wantErr: []string{"line 2", "undefined procedure: nothing_here"},
+		},
+		{
+			name: "recursion",
+			input: `define loop
+  call loop
+end
+
+test "Loop"
+  call loop`,
+			wantErr: []string{"recursive call to loop"},
+		},
+		{
+			name: "missing end",
+			input: `define unfinished
+  click #a
+
+test "Unfinished"
+  click #b`,
+			wantErr: []string{"missing end for define unfinished"},
+		},
+		{
+			name:    "end without define",
+			input:   `end`,
+			wantErr: []string{"end without define"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New().ParseString(tt.input)
+			if err == nil {
+				t.Fatal("Expected an error")
+			}
+			for _, want := range tt.wantErr {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("Error %q does not contain %q", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestProcedureScope(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+	write("cookies.testit", "define accept_cookies\n  click #accept\nend\n")
+	write("menu.testit", "import cookies.testit\n\ndefine open_menu\n  call accept_cookies\n  click #menu\nend\n")
+	// Reaches cookies.testit twice, through menu.testit and directly
+	uses := write("uses.test", "import menu.testit\nimport cookies.testit\n\ntest \"Menu\"\n  call open_menu\n")
+	// Same-named helpers in files that do not import each other
+	a := write("a.test", "define helper\n  click #a\nend\n\ntest \"A\"\n  call helper\n")
+	b := write("b.test", "define helper\n  click #b\nend\n\ntest \"B\"\n  call helper\n")
+	other := write("other.test", "test \"Other\"\n  call accept_cookies\n")
+
+	p := New()
+	tests, err := p.ParseFile(uses)
+	if err != nil {
+		t.Fatalf("ParseFile(uses) error = %v", err)
+	}
+	if len(tests[0].Steps) != 2 || tests[0].Steps[0].Target != "#accept" || tests[0].Steps[1].Target != "#menu" {
+		t.Errorf("Unexpected steps: %v", tests[0].Steps)
+	}
+
+	for path, want := range map[string]string{a: "#a", b: "#b"} {
+		tests, err := p.ParseFile(path)
+		if err != nil {
+			t.Fatalf("ParseFile(%s) error = %v", path, err)
+		}
+		if tests[0].Steps[0].Target != want {
+			t.Errorf("%s called the helper of another file: %v", path, tests[0].Steps)
+		}
+	}
+
+	// Procedures of files parsed earlier are not visible without an import
+	if _, err := p.ParseFile(other); err == nil || !strings.Contains(err.Error(), "undefined procedure: accept_cookies") {
+		t.Errorf("Expected undefined procedure, got %v", err)
+	}
+
+	// Parsing a file again must not count as a redefinition
+	if _, err := p.ParseSource(a, []byte("define helper\n  click #a\nend\n")); err != nil {
+		t.Errorf("Parsing the same file twice should not redefine: %v", err)
+	}
+}
+
+func TestProcedureImportConflict(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		"one.testit": "define login\n  click #one\nend\n",
+		"two.testit": "define login\n  click #two\nend\n",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	path := filepath.Join(dir, "both.test")
+	if err := os.WriteFile(path, []byte("import one.testit\nimport two.testit\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := New().ParseFile(path)
+	if err == nil || !strings.Contains(err.Error(), "both.test:2:1: login from") || !strings.Contains(err.Error(), "is already defined at") {
+		t.Errorf("Expected a conflict at the second import, got %v", err)
+	}
+}
+
+func TestImports(t *testing.T) {
+	dir := t.TempDir()
+	writeFile := func(name, content string) string {
+		t.Helper()
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	writeFile("common/auth.testit", `import "env.testit"
+
+define login(user)
+  navigate ${BASE_URL}/login
+  type #username ${user}
+end
+
+test "Tests in libraries are not imported"
+  navigate https://example.com`)
+	writeFile("common/env.testit", `set BASE_URL http://localhost:3000`)
+	main := writeFile("login.test", `import "common/auth.testit"
+
+test "Login"
+  call login admin`)
+
+	tests, err := New().ParseFile(main)
+	if err != nil {
+		t.Fatalf("ParseFile() error = %v", err)
+	}
+	if len(tests) != 1 {
+		t.Fatalf("Expected 1 test, got %d", len(tests))
+	}
+	if len(tests[0].Setup) != 1 || withoutPosition(tests[0].Setup[0]) != (fasttest.Step{Action: "set", Target: "BASE_URL", Value: "http://localhost:3000"}) {
+		t.Errorf("Setup = %v, want the imported set BASE_URL", tests[0].Setup)
+	}
+	want := []fasttest.Step{
+		{Action: "navigate", Target: "${BASE_URL}/login"},
+		{Action: "type", Target: "#username", Value: "admin"},
+	}
+	if len(tests[0].Steps) != len(want) {
+		t.Fatalf("Got steps %v, want %v", tests[0].Steps, want)
+	}
+	for i := range want {
+		if withoutPosition(tests[0].Steps[i]) != want[i] {
+			t.Errorf("Steps[%d] = %v, want %v", i, tests[0].Steps[i], want[i])
+		}
+	}
+
+	// A library on its own has no tests to run
+	lib, err := New().ParseFile(filepath.Join(dir, "common/env.testit"))
+	if err != nil {
+		t.Fatalf("ParseFile() error = %v", err)
+	}
+	if len(lib) != 0 {
+		t.Errorf("Expected no tests in library, got %d", len(lib))
+	}
+}
+
+func TestImportErrors(t *testing.T) {
+	dir := t.TempDir()
+	a := filepath.Join(dir, "a.test")
+	b := filepath.Join(dir, "b.test")
+	os.WriteFile(a, []byte("import b.test\n"), 0644)
+	os.WriteFile(b, []byte("include \"a.test\"\n"), 0644)
+
+	_, err := New().ParseFile(a)
+	if err == nil || !strings.Contains(err.Error(), "import cycle") {
+		t.Errorf("Expected import cycle error, got %v", err)
+	}
+
+	missing := filepath.Join(dir, "missing.test")
+	os.WriteFile(missing, []byte("import \"nope.testit\"\n"), 0644)
+	_, err = New().ParseFile(missing)
+	if err == nil || !strings.HasPrefix(err.Error(), missing+":1:1: in import") {
+		t.Errorf("Expected error for missing import, got %v", err)
+	}
+
+	late := filepath.Join(dir, "late.test")
+	os.WriteFile(late, []byte("test \"First\"\n  click #a\nimport \"b.test\"\n"), 0644)
+	_, err = New().ParseFile(late)
+	if err == nil || !strings.Contains(err.Error(), "before the first test") {
+		t.Errorf("Expected error for import after a test, got %v", err)
+	}
+}
+
+func TestHooks(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "hooks.test")
+	content := `before_all
+  navigate http://localhost:3000/reset
+
+before_each
+  navigate http://localhost:3000
+  click #accept-cookies
+
+test "First"
+  click #a
+
+after_each
+  screenshot
+
+test "Second"
+  click #b
+
+after_all
+  navigate http://localhost:3000/cleanup`
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	suite, err := New().ParseSuite(file)
+	if err != nil {
+		t.Fatalf("ParseSuite() error = %v", err)
+	}
+	if suite.Name != file {
+		t.Errorf("Suite.Name = %s, want %s", suite.Name, file)
+	}
+	if len(suite.BeforeAll) != 1 || suite.BeforeAll[0].Target != "http://localhost:3000/reset" {
+		t.Errorf("Unexpected before_all: %v", suite.BeforeAll)
+	}
+	if len(suite.AfterAll) != 1 || suite.AfterAll[0].Target != "http://localhost:3000/cleanup" {
+		t.Errorf("Unexpected after_all: %v", suite.AfterAll)
+	}
+	if len(suite.Tests) != 2 {
+		t.Fatalf("Expected 2 tests, got %d", len(suite.Tests))
+	}
+	for _, test := range suite.Tests {
+		if len(test.Steps) != 1 {
+			t.Errorf("%s: hook steps leaked into the test: %v", test.Name, test.Steps)
+		}
+		if len(test.BeforeEach) != 2 {
+			t.Errorf("%s: expected 2 before_each steps, got %v", test.Name, test.BeforeEach)
+		}
+		if len(test.AfterEach) != 1 || test.AfterEach[0].Action != "screenshot" {
+			t.Errorf("%s: unexpected after_each steps: %v", test.Name, test.AfterEach)
+		}
+	}
+}
+
+func TestHookVariables(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "vars.test")
+	content := `set BASE http://localhost:3000
+
+before_all
+  navigate ${BASE}/seed
+
+before_each
+  navigate ${BASE}/reset
+
+test "First"
+  click #a`
+	if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	suite, err := New().ParseSuite(file)
+	if err != nil {
+		t.Fatalf("ParseSuite() error = %v", err)
+	}
+	set := fasttest.Step{Action: "set", Target: "BASE", Value: "http://localhost:3000"}
+	if len(suite.Setup) != 1 || withoutPosition(suite.Setup[0]) != set {
+		t.Errorf("Suite.Setup = %v, want %v", suite.Setup, set)
+	}
+	if len(suite.Tests) != 1 {
+		t.Fatalf("Expected 1 test, got %d", len(suite.Tests))
+	}
+	test := suite.Tests[0]
+	if len(test.Setup) != 1 || withoutPosition(test.Setup[0]) != set {
+		t.Errorf("Test.Setup = %v, want %v", test.Setup, set)
+	}
+	if len(test.Steps) != 1 || test.Steps[0].Action != "click" {
+		t.Errorf("Setup steps leaked into the test: %v", test.Steps)
+	}
+}
+
+func TestTestModifiers(t *testing.T) {
+	input := `test "Checkout" @smoke @slow
+  click #buy
+
+skip test "Flaky search" @search
+  click #search
+
+only test Login flow
+  click #login`
+
+	tests, err := New().ParseString(input)
+	if err != nil {
+		t.Fatalf("ParseString() error = %v", err)
+	}
+	if len(tests) != 3 {
+		t.Fatalf("Expected 3 tests, got %d", len(tests))
+	}
+
+	if tests[0].Name != "Checkout" || len(tests[0].Tags) != 2 || tests[0].Tags[0] != "smoke" || tests[0].Tags[1] != "slow" {
+		t.Errorf("Unexpected first test: %+v", tests[0])
+	}
+	if tests[0].Skip || tests[0].Only {
+		t.Errorf("First test should have no modifiers")
+	}
+	if tests[1].Name != "Flaky search" || !tests[1].Skip || !tests[1].HasTag("search") {
+		t.Errorf("Unexpected skipped test: %+v", tests[1])
+	}
+	if tests[2].Name != "Login flow" || !tests[2].Only {
+		t.Errorf("Unexpected only test: %+v", tests[2])
+	}
+
+	if _, err := New().ParseString("test \"Bad tag\" @no!pe\n  click #a"); err == nil {
+		t.Error("Expected error for invalid tag")
+	}
+}
+
+func TestExamples(t *testing.T) {
+	input := `test "Login as ${user}"
+  type #username ${user}
+  type #password ${pass}
+  assert_text .flash ${message}
+
+  examples:
+    | user     | pass   | message         |
+    | tomsmith | secret | You logged in   |
+    | invalid  | wrong  | Invalid ${user} |
+
+test "Search" @smoke
+  type #q ${term}
+  examples:
+    | term |
+    | shoes |
+    | hats  |`
+
+	tests, err := New().ParseString(input)
+	if err != nil {
+		t.Fatalf("ParseString() error = %v", err)
+	}
+	if len(tests) != 4 {
+		t.Fatalf("Expected 4 tests, got %d", len(tests))
+	}
+
+	if tests[0].Name != "Login as tomsmith" || tests[1].Name != "Login as invalid" {
+		t.Errorf("Unexpected names %q, %q", tests[0].Name, tests[1].Name)
+	}
+	want := []fasttest.Step{
+		{Action: "type", Target: "#username", Value: "invalid"},
+		{Action: "type", Target: "#password", Value: "wrong"},
+		{Action: "assert_text", Target: ".flash", Value: "Invalid ${user}"},
+	}
+	for i := range want {
+		if withoutPosition(tests[1].Steps[i]) != want[i] {
+			t.Errorf("Steps[%d] = %v, want %v", i, tests[1].Steps[i], want[i])
+		}
+	}
+
+	if tests[2].Name != "Search [shoes]" || tests[3].Name != "Search [hats]" {
+		t.Errorf("Unexpected names %q, %q", tests[2].Name, tests[3].Name)
+	}
+	if !tests[3].HasTag("smoke") || tests[3].Steps[0].Value != "hats" {
+		t.Errorf("Unexpected expanded test: %+v", tests[3])
+	}
+}
+
+func TestExamplesWithCheckedArguments(t *testing.T) {
+	input := `test "Resize to ${w}"
+  press ${key}
+  viewport ${w} 800
+  examples:
+    | key       | w    |
+    | Enter     | 375  |
+    | Control+A | 1280 |`
+
+	tests, err := New().ParseString(input)
+	if err != nil {
+		t.Fatalf("ParseString() error = %v", err)
+	}
+	if len(tests) != 2 {
+		t.Fatalf("Expected 2 tests, got %d", len(tests))
+	}
+	want := []fasttest.Step{
+		{Action: "press", Target: "Control+A"},
+		{Action: "viewport", Target: "1280", Value: "800"},
+	}
+	for i := range want {
+		if withoutPosition(tests[1].Steps[i]) != want[i] {
+			t.Errorf("Steps[%d] = %v, want %v", i, tests[1].Steps[i], want[i])
+		}
+	}
+}
+
+func TestExamplesFromFile(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "users.csv"), []byte("user,pass\nalice,one\nbob,two\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "terms.json"), []byte(`[{"term": "shoes", "count": 3}, {"term": "hats"}]`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "data.test")
+	content := `test "Login as ${user}"
+  type #password ${pass}
+  examples: "users.csv"
+
+test "Search ${term}"
+  assert_text .count "${count}"
+  examples: terms.json`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests, err := New().ParseFile(path)
+	if err != nil {
+		t.Fatalf("ParseFile() error = %v", err)
+	}
+	var names []string
+	for _, test := range tests {
+		names = append(names, test.Name)
+	}
+	if strings.Join(names, "|") != "Login as alice|Login as bob|Search shoes|Search hats" {
+		t.Fatalf("Unexpected tests %q", names)
+	}
+	if tests[1].Steps[0].Value != "two" {
+		t.Errorf("Expected password two, got %q", tests[1].Steps[0].Value)
+	}
+	if tests[2].Steps[0].Value != "3" || tests[3].Steps[0].Value != "" {
+		t.Errorf("Unexpected counts %q, %q", tests[2].Steps[0].Value, tests[3].Steps[0].Value)
+	}
+}
+
+func TestExamplesErrors(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{
+			name:  "outside test",
+			input: "examples:\n  | a |\n  | 1 |",
+			want:  "line 1: examples must be inside a test",
+		},
+		{
+			name:  "row without examples",
+			input: "test \"A\"\n  click #a\n  | a |",
+			want:  "line 3: table row without examples:",
+		},
+		{
+			name:  "cell count",
+			input: "test \"A\"\n  type #a ${a}\n  examples:\n  | a | b |\n  | 1 |",
+			want:  "line 5: row has 1 cells, expected 2",
+		},
+		{
+			name:  "no rows",
+			input: "test \"A\"\n  type #a ${a}\n  examples:\n  | a |",
+			want:  "line 3: examples for \"A\" have no rows",
+		},
+		{
+			name:  "steps after examples",
+			input: "test \"A\"\n  examples:\n  | a |\n  | 1 |\n  click #a",
+			want:  "line 5: steps must come before examples",
+		},
+		{
+			name:  "invalid value in a row",
+			input: "test \"A\"\n  press ${key}\n  viewport ${w} 800\n  examples:\n  | key   | w   |\n  | Enter | 375 |\n  | Tab   | 0   |",
+			want:  "line 7: invalid viewport size: 0 (in viewport at line 3)",
+		},
+		{
+			name:  "missing file",
+			input: "test \"A\"\n  examples: missing.csv",
+			want:  "line 2: open missing.csv",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := New().ParseString(tt.input)
+			if err == nil || !strings.HasPrefix(err.Error(), tt.want) {
+				t.Errorf("Expected error starting with %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+// withoutPosition drops where a step was written, to compare only what it does
+func withoutPosition(step fasttest.Step) fasttest.Step {
+	return fasttest.Step{Action: step.Action, Target: step.Target, Value: step.Value}
+}
+
+func TestStepPositions(t *testing.T) {
+	dir := t.TempDir()
+	lib := filepath.Join(dir, "auth.testit")
+	if err := os.WriteFile(lib, []byte("define login(user)\n  type #username ${user}\nend\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "login.test")
+	content := `import "auth.testit"
+
+test "Login"
+  navigate https://example.com
+  # comment
+  call login admin`
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests, err := New().ParseFile(path)
+	if err != nil {
+		t.Fatalf("ParseFile() error = %v", err)
+	}
+	steps := tests[0].Steps
+	if len(steps) != 2 {
+		t.Fatalf("Expected 2 steps, got %d", len(steps))
+	}
+	if steps[0].File != path || steps[0].Line != 4 || steps[0].Text != "navigate https://example.com" {
+		t.Errorf("Unexpected position for navigate: %s:%d %q", steps[0].File, steps[0].Line, steps[0].Text)
+	}
+	// Steps from a procedure point at its definition, and where it was called
+	if steps[1].File != lib || steps[1].Line != 2 || steps[1].Text != "type #username ${user}" {
+		t.Errorf("Unexpected position for type: %s:%d %q", steps[1].File, steps[1].Line, steps[1].Text)
+	}
+	if steps[1].CallFile != path || steps[1].CallLine != 6 {
+		t.Errorf("Unexpected call site for type: %s:%d", steps[1].CallFile, steps[1].CallLine)
+	}
+	if steps[0].CallLine != 0 {
+		t.Errorf("navigate should have no call site, got line %d", steps[0].CallLine)
+	}
+}
+
+func TestErrorList(t *testing.T) {
+	input := `define broken(
+  click #a
+end
+
+test "First"
+  navigate
+  click #ok
+    type #only-selector
+end
+
+test "Second" @bad!tag
+  wait_for
+  call nowhere`
+
+	_, err := New().ParseString(input)
+	var list ErrorList
+	if !errors.As(err, &list) {
+		t.Fatalf("Expected an ErrorList, got %v", err)
+	}
+
+	want := []struct {
+		line, column int
+		msg          string
+	}{
+		{1, 1, "invalid define"},
+		{6, 3, "navigate requires a URL"},
+		{8, 5, "type requires a selector and value"},
+		{9, 1, "end without define"},
+		{11, 15, "invalid tag"},
+		{12, 3, "wait_for requires a selector"},
+		{13, 8, "undefined procedure: nowhere"},
+	}
+	if len(list) != len(want) {
+		t.Fatalf("Expected %d errors, got %d: %v", len(want), len(list), list)
+	}
+	for i, w := range want {
+		if list[i].Line != w.line || list[i].Column != w.column || !strings.Contains(list[i].Msg, w.msg) {
+			t.Errorf("Error %d = %d:%d %q, want %d:%d containing %q", i, list[i].Line, list[i].Column, list[i].Msg, w.line, w.column, w.msg)
+		}
+	}
+	if !strings.HasSuffix(err.Error(), "(and 6 more errors)") {
+		t.Errorf("Unexpected summary: %v", err)
+	}
+
+	// Errors in files carry the file name
+	path := filepath.Join(t.TempDir(), "bad.test")
+	if err := os.WriteFile(path, []byte("test \"A\"\n  click\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = New().ParseFile(path)
+	if err == nil || err.Error() != path+":2:3: click requires a selector" {
+		t.Errorf("Unexpected error %v", err)
+	}
+}
+
+func TestErrorColumns(t *testing.T) {
+	input := `test "Columns"
+  clik #a
+  key_down Enter Shift
+  press Enter NoSuchKey
+  viewport 800 tall
+  type #a "one" "two" three`
+
+	_, err := New().ParseString(input)
+	var list ErrorList
+	if !errors.As(err, &list) {
+		t.Fatalf("Expected an ErrorList, got %v", err)
+	}
+
+	// Errors point at the token at fault, not the start of the line
+	want := []struct {
+		line, column int
+		msg          string
+	}{
+		{2, 3, "unknown action: clik"},
+		{3, 18, "too many arguments"},
+		{4, 15, "unknown key: NoSuchKey"},
+		{5, 16, "invalid viewport size: tall"},
+		{6, 11, "too many arguments"},
+	}
+	if len(list) != len(want) {
+		t.Fatalf("Expected %d errors, got %d: %v", len(want), len(list), list)
+	}
+	for i, w := range want {
+		if list[i].Line != w.line || list[i].Column != w.column || !strings.Contains(list[i].Msg, w.msg) {
+			t.Errorf("Error %d = %d:%d %q, want %d:%d containing %q", i, list[i].Line, list[i].Column, list[i].Msg, w.line, w.column, w.msg)
+		}
+	}
+}
+
+func TestLex(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{name: "bare words", input: "click  div > span.item", want: []string{"click", "div", ">", "span.item"}},
+		{name: "double quotes", input: `type "#my field" "hello world"`, want: []string{"type", "#my field", "hello world"}},
+		{name: "single quotes", input: `type '[name="q"]' 'it\'s'`, want: []string{"type", `[name="q"]`, "it's"}},
+		{name: "escapes", input: `type #a "say \"hi\"\n\tbye \\ \d+"`, want: []string{"type", "#a", "say \"hi\"\n\tbye \\ \\d+"}},
+		{name: "quotes inside bare words", input: `click input[name='email']`, want: []string{"click", "input[name='email']"}},
+		{name: "leading hash in a value", input: `type #a "#1 choice"`, want: []string{"type", "#a", "#1 choice"}},
+		{name: "empty string", input: `set name ""`, want: []string{"set", "name", ""}},
+		{name: "triple quotes on one line", input: `type #a """say "hi" \n"""`, want: []string{"type", "#a", `say "hi" \n`}},
+		{
+			name:  "triple quotes across lines",
+			input: "  type #bio \"\"\"\n    First line\n      indented\n\n    Last line\n    \"\"\"",
+			want:  []string{"type", "#bio", "First line\n  indented\n\nLast line"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines, errs := Lex(tt.input)
+			if len(errs) > 0 {
+				t.Fatalf("Lex() errors = %v", errs)
+			}
+			if len(lines) != 1 {
+				t.Fatalf("Expected 1 line, got %d", len(lines))
+			}
+			var got []string
+			for _, tok := range lines[0].Tokens {
+				got = append(got, tok.Value)
+			}
+			if strings.Join(got, "|") != strings.Join(tt.want, "|") || len(got) != len(tt.want) {
+				t.Errorf("Lex() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLexLines(t *testing.T) {
+	input := "# comment\n\ntest \"A\"\n  type #a \"\"\"\n  one\n  two\n  \"\"\"\n  | a | b |\n  click #b\r\n"
+	lines, errs := Lex(input)
+	if len(errs) > 0 {
+		t.Fatalf("Lex() errors = %v", errs)
+	}
+	if len(lines) != 5 {
+		t.Fatalf("Expected 5 lines, got %d", len(lines))
+	}
+	if !lines[0].Comment || lines[0].Num != 1 || len(lines[0].Tokens) != 0 {
+		t.Errorf("Expected comment on line 1, got %+v", lines[0])
+	}
+	if lines[2].Num != 4 || lines[2].Tokens[2].Value != "one\ntwo" {
+		t.Errorf("Unexpected multi-line token %+v", lines[2])
+	}
+	if !lines[3].TableRow || lines[3].Num != 8 {
+		t.Errorf("Expected table row on line 8, got %+v", lines[3])
+	}
+	last := lines[4]
+	if last.Num != 9 || last.Text != "  click #b" || last.Indent() != 3 {
+		t.Errorf("Unexpected last line %+v", last)
+	}
+	if tok := last.Tokens[1]; tok.Line != 9 || tok.Column != 9 || tok.Value != "#b" {
+		t.Errorf("Unexpected token position %+v", tok)
+	}
+}
+
+func TestLexErrors(t *testing.T) {
+	input := "type #a \"open\nclick 'b\ntype #c \"\"\"never closed\nclick #d"
+	lines, errs := Lex(input)
+	if len(errs) != 3 {
+		t.Fatalf("Expected 3 errors, got %v", errs)
+	}
+	want := []struct {
+		line, column int
+		msg          string
+	}{
+		{1, 9, "unterminated string"},
+		{2, 7, "unterminated string"},
+		{3, 9, "unterminated triple-quoted string"},
+	}
+	for i, w := range want {
+		if errs[i].Line != w.line || errs[i].Column != w.column || errs[i].Msg != w.msg {
+			t.Errorf("Error %d = %d:%d %q, want %d:%d %q", i, errs[i].Line, errs[i].Column, errs[i].Msg, w.line, w.column, w.msg)
+		}
+	}
+	// The unterminated strings still end at the end of their line
+	if len(lines) != 3 || lines[0].Tokens[2].Value != "open" {
+		t.Errorf("Unexpected lines %+v", lines)
+	}
+}
+
+func TestQuotedArguments(t *testing.T) {
+	input := `define fill(selector, text)
+  type ${selector} ${text}
+end
+
+test "Quoted arguments"
+  type "#my field" "hello"
+  click div > span.item
+  type #a "\"quoted\""
+  assert_text h1   Welcome  back
+  type #bio """
+    Line one
+    Line two
+    """
+  call fill "#full name" "Jane Doe"
+  assert_attribute "a.link" href "/a b"`
+
+	tests, err := New().ParseString(input)
+	if err != nil {
+		t.Fatalf("ParseString() error = %v", err)
+	}
+	want := []fasttest.Step{
+		{Action: "type", Target: "#my field", Value: "hello"},
+		{Action: "click", Target: "div > span.item"},
+		{Action: "type", Target: "#a", Value: `"quoted"`},
+		{Action: "assert_text", Target: "h1", Value: "Welcome  back"},
+		{Action: "type", Target: "#bio", Value: "Line one\nLine two"},
+		{Action: "type", Target: "#full name", Value: "Jane Doe"},
+		{Action: "assert_attribute", Target: "a.link|href", Value: "/a b"},
+	}
+	steps := tests[0].Steps
+	if len(steps) != len(want) {
+		t.Fatalf("Got steps %v, want %v", steps, want)
+	}
+	for i := range want {
+		if withoutPosition(steps[i]) != want[i] {
+			t.Errorf("Steps[%d] = %+v, want %+v", i, withoutPosition(steps[i]), want[i])
+		}
+	}
+	if steps[4].Line != 10 {
+		t.Errorf("Expected multi-line step on line 10, got %d", steps[4].Line)
+	}
+
+	for _, bad := range []string{
+		"test \"A\"\n  type #a \"b\" c",
+		"test \"A\"\n  store_text h1 name extra",
+		"test \"A\"\n  click \"#a",
+	} {
+		if _, err := New().ParseString(bad); err == nil {
+			t.Errorf("Expected error for %q", bad)
+		}
+	}
+}
+
+func TestActions(t *testing.T) {
+	spec, ok := LookupAction("type")
+	if !ok || spec.Usage() != "type selector text" || spec.Doc == "" {
+		t.Errorf("Unexpected spec for type: %+v", spec)
+	}
+	if _, ok := LookupAction("call"); ok {
+		t.Error("call is not a step action")
+	}
+	all := Actions()
+	for i := 1; i < len(all); i++ {
+		if all[i-1].Name >= all[i].Name {
+			t.Errorf("Actions() not sorted: %s before %s", all[i-1].Name, all[i].Name)
+		}
+	}
+
+	// The runner keeps its own list of the actions that take a selector
+	for _, spec := range all {
+		takesSelector := len(spec.Args) > 0 && spec.Args[0] == "selector"
+		if fasttest.TakesSelector(spec.Name) != takesSelector {
+			t.Errorf("fasttest.TakesSelector(%q) = %v, want %v", spec.Name, !takesSelector, takesSelector)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+const { spawn } = require('child_process');
+
+// LanguageClient talks JSON-RPC to `testit lsp` over stdio. The server syncs
+// whole documents and answers a handful of requests, so this is all the
+// client it needs, and the extension ships without dependencies.
+class LanguageClient {
+  constructor(command, args) {
+    this.command = command;
+    this.args = args;
+    this.nextId = 1;
+    this.pending = new Map();
+    this.handlers = new Map();
+    this.buffer = Buffer.alloc(0);
+    this.stderr = '';
+  }
+
+  // start runs the server and resolves once it has answered initialize
+  start(rootUri) {
+    return new Promise((resolve, reject) => {
+      this.process = spawn(this.command, this.args);
+      this.process.on('error', reject);
+      this.process.on('exit', code => {
+        const err = new Error(`exited with code ${code}${this.stderr ? ': ' + this.stderr.trim() : ''}`);
+        for (const { reject } of this.pending.values()) {
+          reject(err);
+        }
+        this.pending.clear();
+        this.process = undefined;
+      });
+      // Writes fail once the server is gone, which exit already reports
+      this.process.stdin.on('error', () => {});
+      this.process.stderr.on('data', data => { this.stderr += data; });
+      this.process.stdout.on('data', data => this.receive(data));
+
+      this.request('initialize', { processId: process.pid, rootUri, capabilities: {} })
+        .then(() => {
+          this.notify('initialized', {});
+          resolve();
+        }, reject);
+    });
+  }
+
+  stop() {
+    if (!this.process) {
+      return Promise.resolve();
+    }
+    return this.request('shutdown', null)
+      .catch(() => {})
+      .then(() => {
+        this.notify('exit', null);
+        if (this.process) {
+          this.process.kill();
+        }
+      });
+  }
+
+  request(method, params) {
+    const id = this.nextId++;
+    return new Promise((resolve, reject) => {
+      this.pending.set(id, { resolve, reject });
+      this.send({ jsonrpc: '2.0', id, method, params });
+    });
+  }
+
+  notify(method, params) {
+    this.send({ jsonrpc: '2.0', method, params });
+  }
+
+  onNotification(method, handler) {
+    this.handlers.set(method, handler);
+  }
+
+  send(message) {
+    if (!this.process) {
+      return;
+    }
+    const body = Buffer.from(JSON.stringify(message), 'utf8');
+    this.process.stdin.write(`Content-Length: ${body.length}\r\n\r\n`);
+    this.process.stdin.write(body);
+  }
+
+  // receive splits the output of the server into messages, each a
+  // Content-Length header followed by a JSON body
+  receive(data) {
+    this.buffer = Buffer.concat([this.buffer, data]);
+    for (;;) {
+      const headerEnd = this.buffer.indexOf('\r\n\r\n');
+      if (headerEnd < 0) {
+        return;
+      }
+      const match = /Content-Length: *(\d+)/i.exec(this.buffer.slice(0, headerEnd).toString('ascii'));
+      if (!match) {
+        // Not a header we understand, so drop it
+        this.buffer = this.buffer.slice(headerEnd + 4);
+        continue;
+      }
+      const start = headerEnd + 4;
+      const end = start + Number(match[1]);
+      if (this.buffer.length < end) {
+        return;
+      }
+      const body = this.buffer.slice(start, end).toString('utf8');
+      this.buffer = this.buffer.slice(end);
+      this.dispatch(JSON.parse(body));
+    }
+  }
+
+  dispatch(message) {
+    if (message.method === undefined) {
+      const call = this.pending.get(message.id);
+      if (!call) {
+        return;
+      }
+      this.pending.delete(message.id);
+      if (message.error) {
+        call.reject(new Error(message.error.message));
+      } else {
+        call.resolve(message.result);
+      }
+      return;
+    }
+
+    const handler = this.handlers.get(message.method);
+    if (handler) {
+      handler(message.params);
+    }
+    // The server sends no requests of its own, but one must not be left hanging
+    if (message.id !== undefined) {
+      this.send({ jsonrpc: '2.0', id: message.id, result: null });
+    }
+  }
+}
+
+module.exports = { LanguageClient };
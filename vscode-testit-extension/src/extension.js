@@ -1,4 +1,8 @@
 const vscode = require('vscode');
+const { LanguageClient } = require('./client');
+
+// The client of `testit lsp`, while it runs
+let client;
 
 const TESTIT_COMMANDS = [
   // Navigation
@@ -47,6 +51,133 @@ const CSS_SELECTORS = [
 ];
 
 function activate(context) {
+  const config = vscode.workspace.getConfiguration('testit');
+  if (!config.get('lsp.enabled', true)) {
+    registerBuiltinProviders(context);
+    return;
+  }
+
+  // The language server checks files the way the installed TestIt runs them,
+  // so the built-in command lists are only used when it cannot be started
+  const command = config.get('lsp.path', 'testit');
+  client = new LanguageClient(command, ['lsp']);
+  const folder = vscode.workspace.workspaceFolders && vscode.workspace.workspaceFolders[0];
+  return client.start(folder ? folder.uri.toString() : null).then(() => {
+    registerServerProviders(context);
+  }, err => {
+    client = undefined;
+    vscode.window.showWarningMessage(`Could not start "${command} lsp", using built-in completion instead: ${err.message}`);
+    registerBuiltinProviders(context);
+  });
+}
+
+// registerServerProviders keeps the server in sync with open .test files and
+// answers the editor from it
+function registerServerProviders(context) {
+  const selector = [{ scheme: 'file', language: 'testit' }, { scheme: 'untitled', language: 'testit' }];
+  const isTestit = document => document.languageId === 'testit';
+
+  const diagnostics = vscode.languages.createDiagnosticCollection('testit');
+  client.onNotification('textDocument/publishDiagnostics', params => {
+    diagnostics.set(vscode.Uri.parse(params.uri), params.diagnostics.map(toDiagnostic));
+  });
+
+  const open = document => {
+    if (isTestit(document)) {
+      client.notify('textDocument/didOpen', {
+        textDocument: { uri: document.uri.toString(), languageId: 'testit', version: document.version, text: document.getText() }
+      });
+    }
+  };
+  vscode.workspace.textDocuments.forEach(open);
+
+  const position = (document, pos) => ({
+    textDocument: { uri: document.uri.toString() },
+    position: { line: pos.line, character: pos.character }
+  });
+  // A failed request leaves the editor without an answer rather than an error
+  const ask = (method, params, convert) => client
+    ? client.request(method, params).then(result => result ? convert(result) : undefined, () => undefined)
+    : undefined;
+
+  context.subscriptions.push(
+    diagnostics,
+    vscode.workspace.onDidOpenTextDocument(open),
+    vscode.workspace.onDidChangeTextDocument(event => {
+      if (isTestit(event.document)) {
+        client.notify('textDocument/didChange', {
+          textDocument: { uri: event.document.uri.toString(), version: event.document.version },
+          contentChanges: [{ text: event.document.getText() }]
+        });
+      }
+    }),
+    vscode.workspace.onDidCloseTextDocument(document => {
+      if (isTestit(document)) {
+        client.notify('textDocument/didClose', { textDocument: { uri: document.uri.toString() } });
+      }
+    }),
+    vscode.languages.registerCompletionItemProvider(selector, {
+      provideCompletionItems(document, pos) {
+        return ask('textDocument/completion', position(document, pos), items => items.map(toCompletionItem));
+      }
+    }, ' '),
+    vscode.languages.registerHoverProvider(selector, {
+      provideHover(document, pos) {
+        return ask('textDocument/hover', position(document, pos), hover =>
+          new vscode.Hover(new vscode.MarkdownString(hover.contents.value), hover.range && toRange(hover.range)));
+      }
+    }),
+    vscode.languages.registerDefinitionProvider(selector, {
+      provideDefinition(document, pos) {
+        return ask('textDocument/definition', position(document, pos), loc =>
+          new vscode.Location(vscode.Uri.parse(loc.uri), toRange(loc.range)));
+      }
+    }),
+    vscode.languages.registerDocumentSymbolProvider(selector, {
+      provideDocumentSymbols(document) {
+        return ask('textDocument/documentSymbol', { textDocument: { uri: document.uri.toString() } }, symbols => symbols.map(toSymbol));
+      }
+    })
+  );
+}
+
+// The server speaks LSP, whose kinds count from 1 where the VS Code API
+// counts from 0
+
+function toRange(range) {
+  return new vscode.Range(range.start.line, range.start.character, range.end.line, range.end.character);
+}
+
+function toDiagnostic(d) {
+  const severity = d.severity === 1 ? vscode.DiagnosticSeverity.Error : vscode.DiagnosticSeverity.Warning;
+  const diagnostic = new vscode.Diagnostic(toRange(d.range), d.message, severity);
+  diagnostic.source = d.source;
+  if (d.code) {
+    diagnostic.code = d.code;
+  }
+  return diagnostic;
+}
+
+function toCompletionItem(item) {
+  const completion = new vscode.CompletionItem(item.label, item.kind - 1);
+  completion.detail = item.detail;
+  if (item.documentation) {
+    completion.documentation = new vscode.MarkdownString(item.documentation.value);
+  }
+  if (item.insertText) {
+    completion.insertText = item.insertTextFormat === 2 ? new vscode.SnippetString(item.insertText) : item.insertText;
+  }
+  return completion;
+}
+
+function toSymbol(symbol) {
+  const result = new vscode.DocumentSymbol(symbol.name, symbol.detail || '', symbol.kind - 1, toRange(symbol.range), toRange(symbol.selectionRange));
+  result.children = (symbol.children || []).map(toSymbol);
+  return result;
+}
+
+// registerBuiltinProviders adds completion and hover from the lists above
+function registerBuiltinProviders(context) {
   // Register completion provider for TestIt commands
   const commandProvider = vscode.languages.registerCompletionItemProvider(
     'testit',
@@ -130,7 +261,9 @@ function activate(context) {
   context.subscriptions.push(commandProvider, hoverProvider);
 }
 
-function deactivate() {}
+function deactivate() {
+  return client ? client.stop() : undefined;
+}
 
 module.exports = {
   activate,